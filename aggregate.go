@@ -0,0 +1,62 @@
+package show
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Aggregate defines an aggregate function to apply to the values of a column for all the features in a cluster or bin.
+type Aggregate struct {
+	// The name of the aggregate function. Valid options are: sum, min, max, avg.
+	Function string
+	// The name of the column to aggregate.
+	Column string
+}
+
+// Property returns the name of the property that the aggregate value is assigned to, for example "sum_population".
+func (a *Aggregate) Property() string {
+	return fmt.Sprintf("%s_%s", a.Function, a.Column)
+}
+
+// expression returns the SQL expression used to derive the aggregate value.
+func (a *Aggregate) expression() string {
+
+	switch a.Function {
+	case "sum":
+		// SUM of integer columns yields a HUGEINT which can not be encoded in vector tiles.
+		return fmt.Sprintf(`SUM("%s")::DOUBLE`, a.Column)
+	default:
+		return fmt.Sprintf(`%s("%s")`, strings.ToUpper(a.Function), a.Column)
+	}
+}
+
+// ParseAggregate derives a new `Aggregate` instance from a string in the form of "{FUNCTION}={COLUMN}".
+func ParseAggregate(str_agg string) (*Aggregate, error) {
+
+	fn, col, ok := strings.Cut(str_agg, "=")
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid aggregate, expected {FUNCTION}={COLUMN}")
+	}
+
+	fn = strings.ToLower(strings.TrimSpace(fn))
+	col = strings.TrimSpace(col)
+
+	switch fn {
+	case "sum", "min", "max", "avg":
+		// pass
+	default:
+		return nil, fmt.Errorf("Invalid aggregate function '%s'", fn)
+	}
+
+	if col == "" {
+		return nil, fmt.Errorf("Missing column for aggregate function '%s'", fn)
+	}
+
+	a := &Aggregate{
+		Function: fn,
+		Column:   col,
+	}
+
+	return a, nil
+}
@@ -0,0 +1,41 @@
+package show
+
+import (
+	"testing"
+)
+
+func TestParseAggregate(t *testing.T) {
+
+	a, err := ParseAggregate("SUM=population")
+
+	if err != nil {
+		t.Fatalf("Failed to parse aggregate, %v", err)
+	}
+
+	if a.Property() != "sum_population" {
+		t.Fatalf("Unexpected property name '%s'", a.Property())
+	}
+
+	if a.expression() != `SUM("population")::DOUBLE` {
+		t.Fatalf("Unexpected expression '%s'", a.expression())
+	}
+
+	a, err = ParseAggregate("max=wof:lastmodified")
+
+	if err != nil {
+		t.Fatalf("Failed to parse aggregate, %v", err)
+	}
+
+	if a.expression() != `MAX("wof:lastmodified")` {
+		t.Fatalf("Unexpected expression '%s'", a.expression())
+	}
+
+	for _, str_agg := range []string{"median=population", "sum=", "population"} {
+
+		_, err := ParseAggregate(str_agg)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", str_agg)
+		}
+	}
+}
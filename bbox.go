@@ -0,0 +1,297 @@
+package show
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// BboxColumns defines the names of the columns containing the bounding box of each feature's geometry. Column names
+// that reference struct fields are expressed using dot notation, for example "bbox.xmin". Any column may be left empty.
+type BboxColumns struct {
+	// The name of the column containing the minimum X (longitude) value of each geometry.
+	MinX string
+	// The name of the column containing the minimum Y (latitude) value of each geometry.
+	MinY string
+	// The name of the column containing the maximum X (longitude) value of each geometry.
+	MaxX string
+	// The name of the column containing the maximum Y (latitude) value of each geometry.
+	MaxY string
+}
+
+// IsZero reports whether no bounding box columns have been defined.
+func (c *BboxColumns) IsZero() bool {
+	return c.MinX == "" && c.MinY == "" && c.MaxX == "" && c.MaxY == ""
+}
+
+// Merge returns a new `BboxColumns` instance whose values are those of 'c' unless they are set in 'other'.
+func (c *BboxColumns) Merge(other *BboxColumns) *BboxColumns {
+
+	merged := *c
+
+	if other.MinX != "" {
+		merged.MinX = other.MinX
+	}
+
+	if other.MinY != "" {
+		merged.MinY = other.MinY
+	}
+
+	if other.MaxX != "" {
+		merged.MaxX = other.MaxX
+	}
+
+	if other.MaxY != "" {
+		merged.MaxY = other.MaxY
+	}
+
+	return &merged
+}
+
+// DeriveBboxColumns attempts to derive the columns containing the bounding box of each geometry in the column named
+// 'geom_col'. It will first look for a GeoParquet 1.1 "covering" in 'md' and then for columns in 'table_cols' (a dictionary
+// of column names and their DuckDB types) named "xmin", "ymin", "xmax" and "ymax", either as top-level columns or as the
+// fields of a STRUCT column named "bbox". Field names must match exactly. If no columns can be derived an empty `BboxColumns` instance is returned.
+func DeriveBboxColumns(md *GeoMetadata, geom_col string, table_cols map[string]string) *BboxColumns {
+
+	if md != nil {
+
+		col_md, exists := md.Columns[geom_col]
+
+		if exists && col_md.Covering != nil && col_md.Covering.Bbox != nil {
+
+			bbox := col_md.Covering.Bbox
+
+			return &BboxColumns{
+				MinX: strings.Join(bbox.XMin, "."),
+				MinY: strings.Join(bbox.YMin, "."),
+				MaxX: strings.Join(bbox.XMax, "."),
+				MaxY: strings.Join(bbox.YMax, "."),
+			}
+		}
+	}
+
+	flat := true
+
+	for _, k := range []string{"xmin", "ymin", "xmax", "ymax"} {
+
+		_, exists := table_cols[k]
+
+		if !exists {
+			flat = false
+			break
+		}
+	}
+
+	if flat {
+		return &BboxColumns{
+			MinX: "xmin",
+			MinY: "ymin",
+			MaxX: "xmax",
+			MaxY: "ymax",
+		}
+	}
+
+	bbox_type, exists := table_cols["bbox"]
+
+	if exists {
+
+		fields, ok := parseStructFields(bbox_type)
+
+		has_fields := ok
+
+		for _, k := range []string{"xmin", "ymin", "xmax", "ymax"} {
+
+			if !slices.Contains(fields, k) {
+				has_fields = false
+				break
+			}
+		}
+
+		if has_fields {
+			return &BboxColumns{
+				MinX: "bbox.xmin",
+				MinY: "bbox.ymin",
+				MaxX: "bbox.xmax",
+				MaxY: "bbox.ymax",
+			}
+		}
+	}
+
+	return &BboxColumns{}
+}
+
+// parseStructFields returns the names of the (top-level) fields of the DuckDB STRUCT type 'col_type', for example
+// "STRUCT(xmin FLOAT, \"y min\" FLOAT, extra STRUCT(a INTEGER, b VARCHAR))". Quoted field names are unquoted and the
+// fields of nested types are ignored. If 'col_type' is not a STRUCT type then false is returned.
+func parseStructFields(col_type string) ([]string, bool) {
+
+	col_type = strings.TrimSpace(col_type)
+
+	if !strings.HasPrefix(strings.ToUpper(col_type), "STRUCT(") || !strings.HasSuffix(col_type, ")") {
+		return nil, false
+	}
+
+	body := col_type[len("STRUCT(") : len(col_type)-1]
+
+	fields := make([]string, 0)
+
+	depth := 0
+	in_quotes := false
+	start := 0
+
+	// Split the body on commas which are not inside parentheses (nested types) or quotes (field names).
+
+	defs := make([]string, 0)
+
+	for i, r := range body {
+
+		switch {
+		case r == '"':
+			in_quotes = !in_quotes
+		case in_quotes:
+			continue
+		case r == '(':
+			depth += 1
+		case r == ')':
+			depth -= 1
+		case r == ',' && depth == 0:
+			defs = append(defs, body[start:i])
+			start = i + 1
+		}
+	}
+
+	if in_quotes || depth != 0 {
+		return nil, false
+	}
+
+	defs = append(defs, body[start:])
+
+	for _, def := range defs {
+
+		def = strings.TrimSpace(def)
+
+		if def == "" {
+			return nil, false
+		}
+
+		var name string
+
+		if strings.HasPrefix(def, `"`) {
+
+			// Quoted names escape quotes by doubling them.
+
+			var sb strings.Builder
+			closed := false
+
+			for i := 1; i < len(def); i++ {
+
+				if def[i] != '"' {
+					sb.WriteByte(def[i])
+					continue
+				}
+
+				if i+1 < len(def) && def[i+1] == '"' {
+					sb.WriteByte('"')
+					i += 1
+					continue
+				}
+
+				closed = true
+				break
+			}
+
+			if !closed {
+				return nil, false
+			}
+
+			name = sb.String()
+
+		} else {
+
+			end := strings.Index(def, " ")
+
+			if end == -1 {
+				return nil, false
+			}
+
+			name = def[0:end]
+		}
+
+		fields = append(fields, name)
+	}
+
+	return fields, true
+}
+
+// bboxPredicate returns a SQL boolean expression which is true for rows whose bounding box (defined by 'cols') overlaps 'bound'.
+// Bounds are inlined as literal values, rather than query arguments, so that DuckDB can push the predicate down to Parquet
+// row group statistics. If 'cols' is empty then an empty string is returned.
+func bboxPredicate(cols *BboxColumns, bound orb.Bound) string {
+
+	where := make([]string, 0)
+
+	if cols.MinX != "" {
+		where = append(where, fmt.Sprintf(`%s <= %s`, quoteColumnName(cols.MinX), formatFloat(bound.Max[0])))
+	}
+
+	if cols.MinY != "" {
+		where = append(where, fmt.Sprintf(`%s <= %s`, quoteColumnName(cols.MinY), formatFloat(bound.Max[1])))
+	}
+
+	if cols.MaxX != "" {
+		where = append(where, fmt.Sprintf(`%s >= %s`, quoteColumnName(cols.MaxX), formatFloat(bound.Min[0])))
+	}
+
+	if cols.MaxY != "" {
+		where = append(where, fmt.Sprintf(`%s >= %s`, quoteColumnName(cols.MaxY), formatFloat(bound.Min[1])))
+	}
+
+	return strings.Join(where, " AND ")
+}
+
+// quoteColumnName returns a SQL identifier for 'name' which may reference struct fields using dot notation.
+func quoteColumnName(name string) string {
+	return quoteColumnPath(strings.Split(name, "."))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ParseBound derives a new `orb.Bound` instance from a string in the form of "{MINX},{MINY},{MAXX},{MAXY}".
+func ParseBound(str_bound string) (*orb.Bound, error) {
+
+	parts := strings.Split(str_bound, ",")
+
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("Invalid bounding box, expected {MINX},{MINY},{MAXX},{MAXY}")
+	}
+
+	coords := make([]float64, 4)
+
+	for i, str_coord := range parts {
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(str_coord), 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bounding box coordinate '%s', %w", str_coord, err)
+		}
+
+		coords[i] = v
+	}
+
+	if coords[0] > coords[2] || coords[1] > coords[3] {
+		return nil, fmt.Errorf("Invalid bounding box, minimum coordinates are greater than maximum coordinates")
+	}
+
+	b := &orb.Bound{
+		Min: orb.Point{coords[0], coords[1]},
+		Max: orb.Point{coords[2], coords[3]},
+	}
+
+	return b, nil
+}
@@ -0,0 +1,174 @@
+package show
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestDeriveBboxColumns(t *testing.T) {
+
+	md := &GeoMetadata{
+		PrimaryColumn: "geometry",
+		Columns: map[string]*GeoColumnMetadata{
+			"geometry": &GeoColumnMetadata{
+				Encoding: "WKB",
+				Covering: &GeoCovering{
+					Bbox: &GeoCoveringBbox{
+						XMin: []string{"bbox", "xmin"},
+						YMin: []string{"bbox", "ymin"},
+						XMax: []string{"bbox", "xmax"},
+						YMax: []string{"bbox", "ymax"},
+					},
+				},
+			},
+		},
+	}
+
+	tests := map[string]*BboxColumns{
+		"covering": DeriveBboxColumns(md, "geometry", nil),
+		"flat": DeriveBboxColumns(nil, "geometry", map[string]string{
+			"xmin": "DOUBLE",
+			"ymin": "DOUBLE",
+			"xmax": "DOUBLE",
+			"ymax": "DOUBLE",
+		}),
+		"struct": DeriveBboxColumns(nil, "geometry", map[string]string{
+			"bbox": "STRUCT(xmin FLOAT, ymin FLOAT, xmax FLOAT, ymax FLOAT)",
+		}),
+	}
+
+	expected := map[string]string{
+		"covering": "bbox.xmin",
+		"flat":     "xmin",
+		"struct":   "bbox.xmin",
+	}
+
+	for label, cols := range tests {
+
+		if cols.MinX != expected[label] {
+			t.Fatalf("Unexpected minx column for %s: %s", label, cols.MinX)
+		}
+	}
+
+	empty := []map[string]string{
+		map[string]string{"xmin": "DOUBLE"},
+		// Field names which only contain the expected names
+		map[string]string{"bbox": "STRUCT(bbox_xmin FLOAT, bbox_ymin FLOAT, bbox_xmax FLOAT, bbox_ymax FLOAT)"},
+		// Field names which only appear in the names of nested fields
+		map[string]string{"bbox": "STRUCT(extent STRUCT(xmin FLOAT, ymin FLOAT, xmax FLOAT, ymax FLOAT))"},
+		map[string]string{"bbox": "FLOAT[]"},
+	}
+
+	for _, table_cols := range empty {
+
+		cols := DeriveBboxColumns(nil, "geometry", table_cols)
+
+		if !cols.IsZero() {
+			t.Fatalf("Expected empty bbox columns for %v", table_cols)
+		}
+	}
+}
+
+func TestParseStructFields(t *testing.T) {
+
+	tests := map[string][]string{
+		"STRUCT(xmin FLOAT, ymin FLOAT)":                      []string{"xmin", "ymin"},
+		`STRUCT("x min" DOUBLE, "say ""hi""" VARCHAR)`:        []string{"x min", `say "hi"`},
+		"STRUCT(a STRUCT(b INTEGER, c VARCHAR), d DOUBLE[])":  []string{"a", "d"},
+		`STRUCT("a,b" DECIMAL(18,3), "c)" MAP(VARCHAR, INT))`: []string{"a,b", "c)"},
+	}
+
+	for col_type, expected := range tests {
+
+		fields, ok := parseStructFields(col_type)
+
+		if !ok {
+			t.Fatalf("Failed to parse %s", col_type)
+		}
+
+		if !slices.Equal(fields, expected) {
+			t.Fatalf("Unexpected fields for %s: %v", col_type, fields)
+		}
+	}
+
+	for _, col_type := range []string{"VARCHAR", "STRUCT(", `STRUCT("xmin FLOAT)`} {
+
+		_, ok := parseStructFields(col_type)
+
+		if ok {
+			t.Fatalf("Expected %s to fail to parse", col_type)
+		}
+	}
+}
+
+func TestBboxPredicate(t *testing.T) {
+
+	bound := orb.Bound{
+		Min: orb.Point{-122.5, 37.5},
+		Max: orb.Point{-122.25, 37.75},
+	}
+
+	cols := &BboxColumns{
+		MinX: "bbox.xmin",
+		MinY: "bbox.ymin",
+		MaxX: "bbox.xmax",
+		MaxY: "bbox.ymax",
+	}
+
+	expected := `"bbox"."xmin" <= -122.25 AND "bbox"."ymin" <= 37.75 AND "bbox"."xmax" >= -122.5 AND "bbox"."ymax" >= 37.5`
+
+	where := bboxPredicate(cols, bound)
+
+	if where != expected {
+		t.Fatalf("Unexpected predicate: %s", where)
+	}
+
+	// Legacy -max-x-column and -max-y-column flags only
+
+	cols = &BboxColumns{
+		MaxX: "max_x",
+		MaxY: "max_y",
+	}
+
+	expected = `"max_x" >= -122.5 AND "max_y" >= 37.5`
+
+	where = bboxPredicate(cols, bound)
+
+	if where != expected {
+		t.Fatalf("Unexpected predicate: %s", where)
+	}
+}
+
+func TestGetFeaturesForTileBboxColumns(t *testing.T) {
+
+	opts := countriesTileOptions(t)
+
+	datasource := countriesDerivedFixture(t, opts.Database, "countries-bbox.parquet",
+		`SELECT id, name, geometry, {'xmin': ST_XMin(geom), 'ymin': ST_YMin(geom), 'xmax': ST_XMax(geom), 'ymax': ST_YMax(geom)} AS bbox FROM countries`, "")
+
+	bbox_opts := *opts
+	bbox_opts.Datasource = datasource
+	bbox_opts.BboxColumns = &BboxColumns{
+		MinX: "bbox.xmin",
+		MinY: "bbox.ymin",
+		MaxX: "bbox.xmax",
+		MaxY: "bbox.ymax",
+	}
+
+	cb := GetFeaturesForTileFunc(opts)
+	bbox_cb := GetFeaturesForTileFunc(&bbox_opts)
+
+	// Filtering features by their bounding boxes must not change the features in each tile
+
+	for _, tile := range countriesTiles() {
+
+		expected := tileFeatureIds(t, cb, uint32(tile.Z), tile.X, tile.Y)
+		ids := tileFeatureIds(t, bbox_cb, uint32(tile.Z), tile.X, tile.Y)
+
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("Unexpected features for tile %d/%d/%d using bbox columns: %v, expected %v", tile.Z, tile.X, tile.Y, ids, expected)
+		}
+	}
+}
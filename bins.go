@@ -0,0 +1,297 @@
+package show
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/sfomuseum/go-http-mvt"
+)
+
+// Valid shapes for the cells used to aggregate features in to bins.
+const (
+	// Aggregate features in to square cells.
+	BIN_SHAPE_SQUARE string = "square"
+	// Aggregate features in to (pointy-topped) hexagonal cells.
+	BIN_SHAPE_HEX string = "hex"
+)
+
+// The default size, in pixels, of the cells used to aggregate features in to bins.
+const default_bin_size int = 32
+
+// The name of the property containing the number of features in a bin.
+const bin_count_property string = "count"
+
+// The suffix appended to a layer's name to derive the name of its bins layer.
+const bins_layer_suffix string = "-bins"
+
+// IsValidBinShape reports whether 'shape' is a valid shape for the cells used to aggregate features in to bins.
+func IsValidBinShape(shape string) bool {
+
+	switch shape {
+	case BIN_SHAPE_SQUARE, BIN_SHAPE_HEX:
+		return true
+	default:
+		return false
+	}
+}
+
+// BinOptions defines configuration details for aggregating the features in a tile in to bins.
+type BinOptions struct {
+	// The shape of the cells used to aggregate features. Valid options are: square, hex.
+	Shape string
+	// The width, in pixels, of the cells used to aggregate features.
+	Size int
+	// Zero or more aggregate functions to apply to the features in each bin.
+	Aggregates []*Aggregate
+}
+
+// GetBinsForTileFunc returns a `mvt.GetFeaturesCallbackFunc` callback function which aggregates the features defined by 'opts'
+// in to a grid of square or hexagonal cells, as defined by 'opts.Bins', each encoded as a polygon feature with a "count" property
+// and a property for each of the aggregates defined in 'opts.Bins'.
+func GetBinsForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeaturesCallbackFunc {
+
+	size := float64(opts.Bins.Size)
+
+	if size <= 0 {
+		size = float64(default_bin_size)
+	}
+
+	fn := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		logger := slog.Default()
+		logger = logger.With("layer", layer)
+		logger = logger.With("tile", fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y))
+
+		fc := geojson.NewFeatureCollection()
+
+		collections := map[string]*geojson.FeatureCollection{
+			layer: fc,
+		}
+
+		t1 := time.Now()
+
+		defer func() {
+			logger.Debug("Time to get bins", "count", len(fc.Features), "time", time.Since(t1))
+		}()
+
+		zoom := int(t.Z)
+
+		if !inZoomRange(zoom, opts.MinZoom, opts.MaxZoom) {
+			return collections, nil
+		}
+
+		// Bins which overlap the edges of the tile must be derived from all of their features, including those
+		// in neighbouring tiles, so features are queried for an area two bins wider than the tile on each side.
+
+		where, args, pruned, err := tileConstraints(ctx, opts, pixelBound(t, size*2), zoom)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if pruned != nil {
+			logger.Debug("Prune row groups", "row_groups", pruned.Total, "pruned", pruned.Pruned)
+		}
+
+		q, err := binsQuery(opts, where, zoom, size)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := opts.Database.QueryContext(ctx, q, args...)
+
+		if err != nil {
+
+			if errors.Is(err, context.Canceled) {
+				return nil, nil
+			}
+
+			logger.Error("Failed to query bins", "error", err, "query", q)
+			return nil, fmt.Errorf("Failed to query bins, %w", err)
+		}
+
+		defer rows.Close()
+
+		// The pixel bounds of the tile, expanded by the size of a bin, used to exclude
+		// bins which do not overlap the tile.
+
+		min_px := float64(t.X)*tile_size - size
+		max_px := float64(t.X+1)*tile_size + size
+		min_py := float64(t.Y)*tile_size - size
+		max_py := float64(t.Y+1)*tile_size + size
+
+		for rows.Next() {
+
+			var cx float64
+			var cy float64
+			var count int64
+
+			values := make([]any, len(opts.Bins.Aggregates))
+			pointers := []any{&cx, &cy, &count}
+
+			for idx, _ := range values {
+				pointers = append(pointers, &values[idx])
+			}
+
+			err := rows.Scan(pointers...)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to scan bin, %w", err)
+			}
+
+			if cx < min_px || cx > max_px || cy < min_py || cy > max_py {
+				continue
+			}
+
+			props := geojson.Properties{
+				bin_count_property: count,
+			}
+
+			for idx, a := range opts.Bins.Aggregates {
+				props[a.Property()] = values[idx]
+			}
+
+			opts.Properties.Apply(props)
+
+			f := geojson.NewFeature(binPolygon(opts.Bins.Shape, cx, cy, size, zoom))
+			f.Properties = props
+
+			fc.Append(f)
+		}
+
+		err = rows.Err()
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return nil, fmt.Errorf("There was a problem scanning bins, %w", err)
+		}
+
+		return collections, nil
+	}
+
+	return fn
+}
+
+// binsQuery returns the SQL query used to aggregate the features in the data defined by 'opts', and matching 'where', in to
+// bins 'size' pixels wide at zoom level 'zoom'. The query yields the (Web Mercator) pixel coordinates of the center of each bin,
+// the number of features in the bin and the value of each of the aggregates defined in 'opts.Bins'.
+func binsQuery(opts *GetFeaturesForTileFuncOptions, where string, zoom int, size float64) (string, error) {
+
+	geom, err := geometryExpression(opts)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to derive geometry expression, %w", err)
+	}
+
+	px, py := mercatorPixelExpressions(`ST_X(__centroid)`, `ST_Y(__centroid)`, zoom)
+	cx, cy := binCenterExpressions(opts.Bins.Shape, `__px`, `__py`, size)
+
+	cols := make([]string, 0)
+	aggs := make([]string, 0)
+
+	for _, a := range opts.Bins.Aggregates {
+		cols = append(cols, fmt.Sprintf(`"%s"`, a.Column))
+		aggs = append(aggs, fmt.Sprintf(`, %s AS "%s"`, a.expression(), a.Property()))
+	}
+
+	str_cols := ""
+
+	if len(cols) > 0 {
+		str_cols = ", " + strings.Join(cols, ",")
+	}
+
+	centroids := fmt.Sprintf(`SELECT ST_Centroid(%s) AS __centroid%s FROM %s WHERE %s`, geom, str_cols, fromClause(opts), where)
+	pixels := fmt.Sprintf(`SELECT %s AS __px, %s AS __py%s FROM (%s)`, px, py, str_cols, centroids)
+	centers := fmt.Sprintf(`SELECT %s AS __cx, %s AS __cy%s FROM (%s)`, cx, cy, str_cols, pixels)
+
+	q := fmt.Sprintf(`SELECT __cx, __cy, COUNT(*) AS "%s"%s FROM (%s) GROUP BY __cx, __cy`,
+		bin_count_property, strings.Join(aggs, ""), centers)
+
+	return q, nil
+}
+
+// binCenterExpressions returns the SQL expressions used to derive the pixel coordinates of the center of the bin, 'size' pixels
+// wide, containing the pixel coordinates 'px' and 'py'.
+func binCenterExpressions(shape string, px string, py string, size float64) (string, string) {
+
+	str_size := formatFloat(size)
+
+	switch shape {
+	case BIN_SHAPE_HEX:
+
+		// A grid of (pointy-topped) hexagons is the union of two rectangular grids of hexagon centers, the second offset
+		// by half a cell in each direction. A point belongs to the hexagon whose center, from either grid, is nearest.
+
+		dx := size
+		dy := size * math.Sqrt(3)
+
+		str_dx := formatFloat(dx)
+		str_dy := formatFloat(dy)
+		str_hx := formatFloat(dx / 2)
+		str_hy := formatFloat(dy / 2)
+
+		ax := fmt.Sprintf(`(round(%s / %s) * %s)`, px, str_dx, str_dx)
+		ay := fmt.Sprintf(`(round(%s / %s) * %s)`, py, str_dy, str_dy)
+		bx := fmt.Sprintf(`(round((%s - %s) / %s) * %s + %s)`, px, str_hx, str_dx, str_dx, str_hx)
+		by := fmt.Sprintf(`(round((%s - %s) / %s) * %s + %s)`, py, str_hy, str_dy, str_dy, str_hy)
+
+		nearest_a := fmt.Sprintf(`(pow(%s - %s, 2) + pow(%s - %s, 2) <= pow(%s - %s, 2) + pow(%s - %s, 2))`, px, ax, py, ay, px, bx, py, by)
+
+		cx := fmt.Sprintf(`CASE WHEN %s THEN %s ELSE %s END`, nearest_a, ax, bx)
+		cy := fmt.Sprintf(`CASE WHEN %s THEN %s ELSE %s END`, nearest_a, ay, by)
+
+		return cx, cy
+
+	default:
+
+		cx := fmt.Sprintf(`((floor(%s / %s) + 0.5) * %s)`, px, str_size, str_size)
+		cy := fmt.Sprintf(`((floor(%s / %s) + 0.5) * %s)`, py, str_size, str_size)
+
+		return cx, cy
+	}
+}
+
+// binPolygon returns the polygon, in longitude and latitude, for the bin 'size' pixels wide whose center is located at the
+// (Web Mercator) pixel coordinates 'cx' and 'cy' at zoom level 'zoom'.
+func binPolygon(shape string, cx float64, cy float64, size float64, zoom int) orb.Polygon {
+
+	ring := orb.Ring{}
+
+	switch shape {
+	case BIN_SHAPE_HEX:
+
+		// The distance from the center of a hexagon to each of its corners
+		radius := size / math.Sqrt(3)
+
+		// Corners are added in order of decreasing angle which, because pixel Y coordinates increase
+		// southwards, yields a counter-clockwise ring of longitudes and latitudes.
+
+		for i := 0; i < 6; i++ {
+			angle := (30 - 60*float64(i)) * math.Pi / 180
+			ring = append(ring, pixelToLonLat(cx+radius*math.Cos(angle), cy+radius*math.Sin(angle), zoom))
+		}
+
+	default:
+
+		half := size / 2
+
+		ring = append(ring,
+			pixelToLonLat(cx-half, cy+half, zoom),
+			pixelToLonLat(cx+half, cy+half, zoom),
+			pixelToLonLat(cx+half, cy-half, zoom),
+			pixelToLonLat(cx-half, cy-half, zoom),
+		)
+	}
+
+	ring = append(ring, ring[0])
+
+	return orb.Polygon{ring}
+}
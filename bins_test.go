@@ -0,0 +1,111 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/planar"
+)
+
+func TestPixelBound(t *testing.T) {
+
+	tile := maptile.New(163, 395, 10)
+
+	expected := tile.Bound()
+	bound := pixelBound(&tile, 0)
+
+	for i, v := range []float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]} {
+
+		e := []float64{expected.Min[0], expected.Min[1], expected.Max[0], expected.Max[1]}[i]
+
+		if math.Abs(v-e) > 1e-9 {
+			t.Fatalf("Unexpected bound %v, expected %v", bound, expected)
+		}
+	}
+
+	padded := pixelBound(&tile, 32)
+
+	if !padded.Contains(bound.Min) || !padded.Contains(bound.Max) || padded.Equal(bound) {
+		t.Fatalf("Expected padded bound %v to contain %v", padded, bound)
+	}
+}
+
+func TestBinPolygon(t *testing.T) {
+
+	tests := map[string]int{
+		BIN_SHAPE_SQUARE: 5,
+		BIN_SHAPE_HEX:    7,
+	}
+
+	for shape, count := range tests {
+
+		poly := binPolygon(shape, 41744, 101136, 32, 10)
+		ring := poly[0]
+
+		if len(ring) != count {
+			t.Fatalf("Unexpected number of points for %s bin: %d", shape, len(ring))
+		}
+
+		if !ring.Closed() {
+			t.Fatalf("Expected %s bin to be closed", shape)
+		}
+
+		if ring.Orientation() != orb.CCW {
+			t.Fatalf("Expected %s bin to be counter-clockwise", shape)
+		}
+
+		center := pixelToLonLat(41744, 101136, 10)
+
+		if !planar.RingContains(ring, center) {
+			t.Fatalf("Expected %s bin to contain its center", shape)
+		}
+	}
+}
+
+func TestGetBinsForTileCounts(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+
+	var total int64
+
+	err := opts.Database.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s`, fromClause(opts))).Scan(&total)
+
+	if err != nil {
+		t.Fatalf("Failed to count features, %v", err)
+	}
+
+	// Features are assigned to bins by their centroids so, at zoom level 0, every feature is counted exactly once
+
+	tile := maptile.New(0, 0, 0)
+
+	for _, shape := range []string{BIN_SHAPE_SQUARE, BIN_SHAPE_HEX} {
+
+		bins_opts := *opts
+		bins_opts.Bins = &BinOptions{
+			Shape: shape,
+			Size:  default_bin_size,
+		}
+
+		collections, err := GetBinsForTileFunc(&bins_opts)(ctx, "countries", &tile)
+
+		if err != nil {
+			t.Fatalf("Failed to get %s bins for tile, %v", shape, err)
+		}
+
+		var count int64
+
+		for _, f := range collections["countries"].Features {
+			count += f.Properties[bin_count_property].(int64)
+		}
+
+		if count != total {
+			t.Fatalf("Expected %s bins to count %d features, got %d", shape, total, count)
+		}
+	}
+}
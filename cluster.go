@@ -0,0 +1,197 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// The default size, in pixels, of the grid cells used to cluster points.
+const default_cluster_grid_size int = 64
+
+// The name of the property containing the number of points in a cluster.
+const cluster_count_property string = "point_count"
+
+// ClusterOptions defines configuration details for grouping the features in a tile in to clusters.
+type ClusterOptions struct {
+	// Features in tiles below this zoom level are clustered.
+	MaxZoom int
+	// The size, in pixels, of the (256 x 256 pixel tile) grid cells used to cluster points. It must evenly divide the tile size so
+	// that no cell spans more than one tile. If 0 the default size (64 pixels) is used.
+	GridSize int
+	// Zero or more aggregate functions to apply to the points in each cluster.
+	Aggregates []*Aggregate
+}
+
+// IsValidClusterGridSize reports whether 'size' is a valid size, in pixels, for the grid cells used to cluster points. Since points
+// are grouped in a grid covering the world, but only the points in a single tile are queried, cells must not span more than one tile.
+func IsValidClusterGridSize(size int) bool {
+	return size > 0 && int(tile_size)%size == 0
+}
+
+// clusterFeatures groups the features in 'from' matching 'where' (and 'args') in to a single point feature for each cell,
+// 'opts.Cluster.GridSize' pixels wide, in a grid covering the world at the zoom level of 't'. Each cluster is assigned a
+// "point_count" property and a property for each of the aggregates defined in 'opts.Cluster'. Features are only clustered
+// in the tile which contains their centroid, so that features which span more than one tile are only counted once.
+func clusterFeatures(ctx context.Context, opts *GetFeaturesForTileFuncOptions, layer string, t *maptile.Tile, where string, args []any) (map[string]*geojson.FeatureCollection, error) {
+
+	grid_size := opts.Cluster.GridSize
+
+	if grid_size == 0 {
+		grid_size = default_cluster_grid_size
+	}
+
+	if !IsValidClusterGridSize(grid_size) {
+		return nil, fmt.Errorf("Invalid cluster grid size %d, must evenly divide the tile size (256 pixels)", grid_size)
+	}
+
+	geom, err := geometryExpression(opts)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive geometry expression, %w", err)
+	}
+
+	inner_cols := []string{
+		fmt.Sprintf(`ST_Centroid(%s) AS __centroid`, geom),
+	}
+
+	select_cols := []string{
+		fmt.Sprintf(`COUNT(*) AS "%s"`, cluster_count_property),
+		`AVG(ST_X(__centroid)) AS __x`,
+		`AVG(ST_Y(__centroid)) AS __y`,
+	}
+
+	zoom := int(t.Z)
+
+	px, py := mercatorPixelExpressions(`ST_X(__centroid)`, `ST_Y(__centroid)`, zoom)
+
+	group_by := []string{
+		fmt.Sprintf(`floor(__px / %d)`, grid_size),
+		fmt.Sprintf(`floor(__py / %d)`, grid_size),
+	}
+
+	for _, a := range opts.Cluster.Aggregates {
+		inner_cols = append(inner_cols, fmt.Sprintf(`"%s"`, a.Column))
+		select_cols = append(select_cols, fmt.Sprintf(`%s AS "%s"`, a.expression(), a.Property()))
+	}
+
+	if opts.LayerBy != "" {
+		inner_cols = append(inner_cols, fmt.Sprintf(`"%s"`, opts.LayerBy))
+		select_cols = append(select_cols, fmt.Sprintf(`"%s"`, opts.LayerBy))
+		group_by = append(group_by, fmt.Sprintf(`"%s"`, opts.LayerBy))
+	}
+
+	// Centroids are assigned to the tile whose (half-open) pixel range contains them so that centroids on the edge shared by
+	// two tiles are only counted in one of them. Centroids on the eastern and southern edges of the world are included in
+	// the last column and row of tiles respectively.
+
+	min_px := float64(t.X) * tile_size
+	min_py := float64(t.Y) * tile_size
+
+	max_px_op := "<"
+	max_py_op := "<"
+
+	if t.X == uint32(1<<t.Z)-1 {
+		max_px_op = "<="
+	}
+
+	if t.Y == uint32(1<<t.Z)-1 {
+		max_py_op = "<="
+	}
+
+	in_tile := fmt.Sprintf(`__px >= %s AND __px %s %s AND __py >= %s AND __py %s %s`,
+		formatFloat(min_px), max_px_op, formatFloat(min_px+tile_size), formatFloat(min_py), max_py_op, formatFloat(min_py+tile_size))
+
+	centroids := fmt.Sprintf(`SELECT %s FROM %s WHERE %s`, strings.Join(inner_cols, ","), fromClause(opts), where)
+	pixels := fmt.Sprintf(`SELECT *, %s AS __px, %s AS __py FROM (%s)`, px, py, centroids)
+
+	q := fmt.Sprintf(`SELECT %s FROM (%s) WHERE %s GROUP BY %s`,
+		strings.Join(select_cols, ","), pixels, in_tile, strings.Join(group_by, ","))
+
+	rows, err := opts.Database.QueryContext(ctx, q, args...)
+
+	if err != nil {
+
+		if errors.Is(err, context.Canceled) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Failed to query clusters, %w", err)
+	}
+
+	defer rows.Close()
+
+	collections := make(map[string]*geojson.FeatureCollection)
+
+	for rows.Next() {
+
+		var count int64
+		var x sql.NullFloat64
+		var y sql.NullFloat64
+
+		values := make([]any, len(opts.Cluster.Aggregates))
+		pointers := []any{&count, &x, &y}
+
+		for idx, _ := range values {
+			pointers = append(pointers, &values[idx])
+		}
+
+		var layer_by any
+
+		if opts.LayerBy != "" {
+			pointers = append(pointers, &layer_by)
+		}
+
+		err := rows.Scan(pointers...)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan cluster, %w", err)
+		}
+
+		if !x.Valid || !y.Valid {
+			continue
+		}
+
+		props := geojson.Properties{
+			cluster_count_property: count,
+		}
+
+		for idx, a := range opts.Cluster.Aggregates {
+			props[a.Property()] = values[idx]
+		}
+
+		opts.Properties.Apply(props)
+
+		f := geojson.NewFeature(orb.Point{x.Float64, y.Float64})
+		f.Properties = props
+
+		fc_name := layer
+
+		if opts.LayerBy != "" {
+			fc_name = PartitionName(layer_by, layer)
+		}
+
+		fc, exists := collections[fc_name]
+
+		if !exists {
+			fc = geojson.NewFeatureCollection()
+			collections[fc_name] = fc
+		}
+
+		fc.Append(f)
+	}
+
+	err = rows.Err()
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		return nil, fmt.Errorf("There was a problem scanning clusters, %w", err)
+	}
+
+	return collections, nil
+}
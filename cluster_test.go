@@ -0,0 +1,84 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestIsValidClusterGridSize(t *testing.T) {
+
+	tests := map[int]bool{
+		16:  true,
+		64:  true,
+		256: true,
+		0:   false,
+		-64: false,
+		48:  false,
+		100: false,
+		512: false,
+	}
+
+	for size, expected := range tests {
+
+		if IsValidClusterGridSize(size) != expected {
+			t.Fatalf("Unexpected result for grid size %d", size)
+		}
+	}
+}
+
+func TestClusterFeaturesAcrossTiles(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+
+	var total int64
+
+	err := opts.Database.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s`, fromClause(opts))).Scan(&total)
+
+	if err != nil {
+		t.Fatalf("Failed to count features, %v", err)
+	}
+
+	opts.Cluster = &ClusterOptions{
+		MaxZoom: 2,
+	}
+
+	cb := GetFeaturesForTileFunc(opts)
+
+	// Countries span more than one tile, and share edges with tiles, but each one must only be counted once
+
+	var count int64
+
+	for x := uint32(0); x < 2; x++ {
+
+		for y := uint32(0); y < 2; y++ {
+
+			tile := maptile.New(x, y, 1)
+
+			collections, err := cb(ctx, "countries", &tile)
+
+			if err != nil {
+				t.Fatalf("Failed to cluster features for tile, %v", err)
+			}
+
+			bound := tile.Bound()
+
+			for _, f := range collections["countries"].Features {
+
+				if !bound.Contains(f.Point()) {
+					t.Fatalf("Expected cluster %v to be inside tile %d/%d/%d", f.Point(), tile.Z, tile.X, tile.Y)
+				}
+
+				count += f.Properties[cluster_count_property].(int64)
+			}
+		}
+	}
+
+	if count != total {
+		t.Fatalf("Expected clusters to count %d features, got %d", total, count)
+	}
+}
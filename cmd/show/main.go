@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 
 	"github.com/sfomuseum/go-geoparquet-show"
 )
@@ -10,7 +11,23 @@ import (
 func main() {
 
 	ctx := context.Background()
-	err := show.Run(ctx)
+
+	var err error
+
+	subcommand := ""
+
+	if len(os.Args) > 1 {
+		subcommand = os.Args[1]
+	}
+
+	switch subcommand {
+	case "export":
+		err = show.RunExport(ctx, os.Args[2:])
+	case "seed":
+		err = show.RunSeed(ctx, os.Args[2:])
+	default:
+		err = show.Run(ctx)
+	}
 
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,114 @@
+package show
+
+import (
+	"fmt"
+
+	"github.com/paulmach/orb"
+)
+
+// Strategies for handling GEOMETRYCOLLECTION geometries, which cannot be encoded in vector tiles.
+const (
+	// Create a separate feature for each member of a geometry collection.
+	GEOMETRY_COLLECTION_EXPLODE string = "explode"
+	// Merge the members of a geometry collection in to one feature for each of MultiPoint, MultiLineString and MultiPolygon geometries.
+	GEOMETRY_COLLECTION_MERGE string = "merge"
+)
+
+// IsValidGeometryCollectionStrategy reports whether 'strategy' is a known strategy for handling geometry collections. An empty
+// string is valid and is treated as `GEOMETRY_COLLECTION_EXPLODE`.
+func IsValidGeometryCollectionStrategy(strategy string) bool {
+
+	switch strategy {
+	case "", GEOMETRY_COLLECTION_EXPLODE, GEOMETRY_COLLECTION_MERGE:
+		return true
+	default:
+		return false
+	}
+}
+
+// expandGeometry returns the list of geometries to create features for, for 'geom'. If 'geom' is not an `orb.Collection` it is
+// returned as-is. Otherwise its members (including the members of any nested collections) are handled according to 'strategy'. If
+// 'strategy' is empty `GEOMETRY_COLLECTION_EXPLODE` is assumed.
+func expandGeometry(geom orb.Geometry, strategy string) ([]orb.Geometry, error) {
+
+	collection, ok := geom.(orb.Collection)
+
+	if !ok {
+		return []orb.Geometry{geom}, nil
+	}
+
+	members := flattenCollection(collection)
+
+	switch strategy {
+	case "", GEOMETRY_COLLECTION_EXPLODE:
+		return members, nil
+	case GEOMETRY_COLLECTION_MERGE:
+		return mergeGeometries(members), nil
+	default:
+		return nil, fmt.Errorf("Invalid geometry collection strategy '%s'", strategy)
+	}
+}
+
+// flattenCollection returns the members of 'collection' and any nested collections.
+func flattenCollection(collection orb.Collection) []orb.Geometry {
+
+	members := make([]orb.Geometry, 0)
+
+	for _, g := range collection {
+
+		switch g := g.(type) {
+		case orb.Collection:
+			members = append(members, flattenCollection(g)...)
+		default:
+			members = append(members, g)
+		}
+	}
+
+	return members
+}
+
+// mergeGeometries merges 'geoms' in to (at most) one MultiPoint, one MultiLineString and one MultiPolygon geometry.
+func mergeGeometries(geoms []orb.Geometry) []orb.Geometry {
+
+	points := orb.MultiPoint{}
+	lines := orb.MultiLineString{}
+	polygons := orb.MultiPolygon{}
+
+	for _, g := range geoms {
+
+		switch g := g.(type) {
+		case orb.Point:
+			points = append(points, g)
+		case orb.MultiPoint:
+			points = append(points, g...)
+		case orb.LineString:
+			lines = append(lines, g)
+		case orb.MultiLineString:
+			lines = append(lines, g...)
+		case orb.Ring:
+			polygons = append(polygons, orb.Polygon{g})
+		case orb.Polygon:
+			polygons = append(polygons, g)
+		case orb.MultiPolygon:
+			polygons = append(polygons, g...)
+		case orb.Bound:
+			polygons = append(polygons, g.ToPolygon())
+		}
+	}
+
+	merged := make([]orb.Geometry, 0)
+
+	if len(points) > 0 {
+		merged = append(merged, points)
+	}
+
+	if len(lines) > 0 {
+		merged = append(merged, lines)
+	}
+
+	if len(polygons) > 0 {
+		merged = append(merged, polygons)
+	}
+
+	return merged
+}
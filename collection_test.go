@@ -0,0 +1,110 @@
+package show
+
+import (
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+)
+
+func TestExpandGeometry(t *testing.T) {
+
+	poly := orb.Polygon{
+		orb.Ring{
+			orb.Point{-122.388006, 37.614539},
+			orb.Point{-122.387968, 37.614548},
+			orb.Point{-122.387967, 37.614547},
+			orb.Point{-122.388006, 37.614539},
+		},
+	}
+
+	collection := orb.Collection{
+		orb.MultiPolygon{poly, poly},
+		orb.MultiPolygon{poly},
+		orb.Collection{
+			orb.Point{-122.388006, 37.614539},
+			orb.LineString{
+				orb.Point{-122.388006, 37.614539},
+				orb.Point{-122.387968, 37.614548},
+			},
+		},
+	}
+
+	enc, err := wkb.Marshal(collection)
+
+	if err != nil {
+		t.Fatalf("Failed to marshal collection, %v", err)
+	}
+
+	geom, err := wkb.Unmarshal(enc)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal collection, %v", err)
+	}
+
+	exploded, err := expandGeometry(geom, GEOMETRY_COLLECTION_EXPLODE)
+
+	if err != nil {
+		t.Fatalf("Failed to explode collection, %v", err)
+	}
+
+	if len(exploded) != 4 {
+		t.Fatalf("Expected 4 exploded geometries, got %d", len(exploded))
+	}
+
+	merged, err := expandGeometry(geom, GEOMETRY_COLLECTION_MERGE)
+
+	if err != nil {
+		t.Fatalf("Failed to merge collection, %v", err)
+	}
+
+	if len(merged) != 3 {
+		t.Fatalf("Expected 3 merged geometries, got %d", len(merged))
+	}
+
+	mp, ok := merged[2].(orb.MultiPolygon)
+
+	if !ok {
+		t.Fatalf("Expected merged MultiPolygon, got %T", merged[2])
+	}
+
+	if len(mp) != 3 {
+		t.Fatalf("Expected merged MultiPolygon with 3 polygons, got %d", len(mp))
+	}
+
+	single, err := expandGeometry(poly, GEOMETRY_COLLECTION_MERGE)
+
+	if err != nil {
+		t.Fatalf("Failed to expand polygon, %v", err)
+	}
+
+	if len(single) != 1 || single[0].GeoJSONType() != "Polygon" {
+		t.Fatalf("Expected polygon to be returned as-is")
+	}
+
+	// An empty strategy (for example options created without setting one) explodes collections
+
+	if !IsValidGeometryCollectionStrategy("") {
+		t.Fatalf("Expected empty strategy to be valid")
+	}
+
+	defaulted, err := expandGeometry(geom, "")
+
+	if err != nil {
+		t.Fatalf("Failed to expand collection with empty strategy, %v", err)
+	}
+
+	if len(defaulted) != len(exploded) {
+		t.Fatalf("Expected empty strategy to explode collection, got %d geometries", len(defaulted))
+	}
+
+	if IsValidGeometryCollectionStrategy("flatten") {
+		t.Fatalf("Expected unknown strategy to be invalid")
+	}
+
+	_, err = expandGeometry(geom, "flatten")
+
+	if err == nil {
+		t.Fatalf("Expected unknown strategy to fail")
+	}
+}
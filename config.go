@@ -1,5 +1,13 @@
 package show
 
+// The types of layers described by `mapLayerConfig`.
+const (
+	// A layer containing individual features (or clusters of features).
+	layer_type_features string = "features"
+	// A layer containing features aggregated in to bins.
+	layer_type_bins string = "bins"
+)
+
 // mapConfig defines common configuration details for maps.
 type mapConfig struct {
 	// MinX is the minimum longitude of the database's extent
@@ -14,4 +22,40 @@ type mapConfig struct {
 	LabelProperties []string `json:"label_properties"`
 	// Which vector tile renderer to use. Valid options are: leaflet, maplibre.
 	Renderer string `json:"renderer"`
+	// The list of vector tile layers to display
+	Layers []*mapLayerConfig `json:"layers"`
+	// The optional URL prefix for retrieving complete features by ID, for layers which define an ID column.
+	FeaturesURL string `json:"features_url,omitempty"`
+	// The optional URL of the server-sent events endpoint which notifies the map when the tileset has been reloaded.
+	EventsURL string `json:"events_url,omitempty"`
+}
+
+// mapLayerConfig defines configuration details for an individual vector tile layer.
+type mapLayerConfig struct {
+	// The name of the layer (in both the tile URL and the vector tiles themselves)
+	Name string `json:"name"`
+	// The names of the layers encoded in the layer's vector tiles. This will be the same as 'Name' unless features are
+	// being partitioned in to separate layers by the value of a column.
+	SourceLayers []string `json:"source_layers"`
+	// The URL template for the layer's vector tiles
+	TilesURL string `json:"tiles_url"`
+	// The type of layer. Valid options are: "features" or "bins" (features aggregated in to square or hexagonal cells).
+	Type string `json:"type"`
+	// The minimum zoom level at which the layer is displayed
+	MinZoom int `json:"min_zoom"`
+	// The maximum zoom level at which the layer is displayed. If 0 there is no maximum zoom level.
+	MaxZoom int `json:"max_zoom"`
+	// The maximum zoom level for which the layer's tiles are available. Tiles for higher zoom levels are derived (overzoomed) by the renderer
+	// from tiles at this zoom level. If 0 tiles are available for all zoom levels.
+	MaxNativeZoom int `json:"max_native_zoom"`
+	// MinX is the minimum longitude of the layer's extent
+	MinX float64 `json:"minx"`
+	// MinY is the minimum latitude of the layer's extent
+	MinY float64 `json:"miny"`
+	// MaxX is the maximum longitude of the layer's extent
+	MaxX float64 `json:"maxx"`
+	// MaxY is the maximum latitude of the layer's extent
+	MaxY float64 `json:"maxy"`
+	// The optional name of the property uniquely identifying each feature, used to retrieve complete features.
+	IdColumn string `json:"id_column,omitempty"`
 }
@@ -0,0 +1,291 @@
+package show
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/encoding/wkb"
+)
+
+// The CRS that geometries are transformed in to for display.
+const display_crs string = "EPSG:4326"
+
+// The number of points to add along each edge of a bounding box before transforming it in to another CRS.
+const densify_bound_points int = 16
+
+// The maximum number of transformed bounding boxes (for example tile boundaries) to keep in memory. See `transformBoundCached`.
+const transformed_bounds_max int = 4096
+
+// The fraction of the size of a layer's extent used to pad it before tile boundaries are clamped to it. This accounts for
+// the extent, like any transformed bounding box, being an approximation.
+const extent_padding float64 = 0.01
+
+// The cache of transformed bounding boxes used by `transformBoundCached`.
+var transformed_bounds = newBoundCache(transformed_bounds_max)
+
+// projjsonCRS defines the subset of a PROJJSON document needed to derive an "AUTHORITY:CODE" identifier.
+type projjsonCRS struct {
+	Id *projjsonId `json:"id,omitempty"`
+}
+
+type projjsonId struct {
+	Authority string `json:"authority"`
+	Code      any    `json:"code"`
+}
+
+// SourceCRS returns a CRS definition suitable for passing to the DuckDB `ST_Transform` function for the geometries described by 'col_md'.
+// If the geometries are already (longitude, latitude) WGS84 coordinates, or the CRS is unknown, an empty string is returned. CRS
+// definitions are expressed as "AUTHORITY:CODE" strings when possible, otherwise the PROJJSON document itself is returned.
+func (col_md *GeoColumnMetadata) SourceCRS() (string, error) {
+
+	// Per the GeoParquet spec, if the "crs" key is absent then data are assumed to be OGC:CRS84
+	// and if it is explicitly null then the CRS is undefined. In both cases there is nothing to transform.
+
+	if len(col_md.CRS) == 0 || string(col_md.CRS) == "null" {
+		return "", nil
+	}
+
+	var crs *projjsonCRS
+
+	err := json.Unmarshal(col_md.CRS, &crs)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to unmarshal PROJJSON CRS, %w", err)
+	}
+
+	if crs.Id == nil || crs.Id.Authority == "" {
+		return string(col_md.CRS), nil
+	}
+
+	code := fmt.Sprintf("%s:%v", strings.ToUpper(crs.Id.Authority), crs.Id.Code)
+
+	if isDisplayCRS(code) {
+		return "", nil
+	}
+
+	return code, nil
+}
+
+// isDisplayCRS reports whether 'crs' is equivalent to the CRS that geometries are displayed in.
+func isDisplayCRS(crs string) bool {
+
+	switch strings.ToUpper(crs) {
+	case "", "EPSG:4326", "OGC:CRS84":
+		return true
+	default:
+		return false
+	}
+}
+
+// transformExpression returns a SQL expression that transforms the geometry produced by 'expr' from 'source_crs' to 'target_crs'.
+func transformExpression(expr string, source_crs string, target_crs string) string {
+	return fmt.Sprintf(`ST_Transform(%s, %s, %s, always_xy := true)`, expr, quoteString(source_crs), quoteString(target_crs))
+}
+
+// transformBound transforms 'bound' from 'source_crs' to 'target_crs' using the DuckDB `ST_Transform` function and returns
+// the bounding box of the result. The edges of 'bound' are densified before being transformed to account for the fact
+// that straight lines in one CRS may be curves in another.
+func transformBound(ctx context.Context, db *sql.DB, bound orb.Bound, source_crs string, target_crs string) (orb.Bound, error) {
+
+	ring := densifyBound(bound, densify_bound_points)
+	poly := orb.Polygon{ring}
+
+	enc_poly, err := wkb.MarshalToHex(poly, wkb.DefaultByteOrder)
+
+	if err != nil {
+		return bound, fmt.Errorf("Failed to marshal bound to WKBHEX, %w", err)
+	}
+
+	geom := transformExpression("ST_GeomFromHEXWKB(?)", source_crs, target_crs)
+
+	q := fmt.Sprintf(`SELECT ST_XMin(g), ST_YMin(g), ST_XMax(g), ST_YMax(g) FROM (SELECT %s AS g)`, geom)
+
+	var minx float64
+	var miny float64
+	var maxx float64
+	var maxy float64
+
+	err = db.QueryRowContext(ctx, q, string(enc_poly)).Scan(&minx, &miny, &maxx, &maxy)
+
+	if err != nil {
+		return bound, fmt.Errorf("Failed to transform bound, %w", err)
+	}
+
+	transformed := orb.Bound{
+		Min: orb.Point{minx, miny},
+		Max: orb.Point{maxx, maxy},
+	}
+
+	return transformed, nil
+}
+
+// transformBoundCached is identical to `transformBound` except that transformed bounding boxes are cached (in memory) so that
+// requests for the same tile boundary, for example for multiple layers, do not query the database each time.
+func transformBoundCached(ctx context.Context, db *sql.DB, bound orb.Bound, source_crs string, target_crs string) (orb.Bound, error) {
+
+	key := fmt.Sprintf("%s#%s#%s,%s,%s,%s", source_crs, target_crs, formatFloat(bound.Min[0]), formatFloat(bound.Min[1]), formatFloat(bound.Max[0]), formatFloat(bound.Max[1]))
+
+	transformed, exists := transformed_bounds.Get(key)
+
+	if exists {
+		return transformed, nil
+	}
+
+	transformed, err := transformBound(ctx, db, bound, source_crs, target_crs)
+
+	if err != nil {
+		return bound, err
+	}
+
+	transformed_bounds.Set(key, transformed)
+	return transformed, nil
+}
+
+// isFiniteBound reports whether all the coordinates of 'bound' are finite numbers. Transforming bounding boxes which exceed the
+// area of use of a CRS may yield infinite (or NaN) coordinates.
+func isFiniteBound(bound orb.Bound) bool {
+
+	for _, v := range []float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]} {
+
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clampBound returns the intersection of 'bound' and 'extent' (padded by `extent_padding`) and true. If they do not
+// intersect then false is returned.
+func clampBound(bound orb.Bound, extent orb.Bound) (orb.Bound, bool) {
+
+	pad := max(extent.Max[0]-extent.Min[0], extent.Max[1]-extent.Min[1]) * extent_padding
+
+	// Ensure that extents with no area (for example a single point) are padded.
+
+	pad = max(pad, 1e-6)
+
+	extent = extent.Pad(pad)
+
+	if !bound.Intersects(extent) {
+		return bound, false
+	}
+
+	clamped := orb.Bound{
+		Min: orb.Point{max(bound.Min[0], extent.Min[0]), max(bound.Min[1], extent.Min[1])},
+		Max: orb.Point{min(bound.Max[0], extent.Max[0]), min(bound.Max[1], extent.Max[1])},
+	}
+
+	return clamped, true
+}
+
+// boundCache is a least-recently-used cache of bounding boxes, limited by the number of bounding boxes stored.
+type boundCache struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// boundCacheEntry is a bounding box, and the key it is stored for, in a `boundCache`.
+type boundCacheEntry struct {
+	key   string
+	bound orb.Bound
+}
+
+// newBoundCache returns a new `boundCache` instance which stores up to 'max' bounding boxes.
+func newBoundCache(max int) *boundCache {
+
+	c := &boundCache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+
+	return c
+}
+
+// Get returns the bounding box stored for 'key'.
+func (c *boundCache) Get(key string) (orb.Bound, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, exists := c.entries[key]
+
+	if !exists {
+		return orb.Bound{}, false
+	}
+
+	c.lru.MoveToFront(el)
+	return el.Value.(*boundCacheEntry).bound, true
+}
+
+// Set stores 'bound' for 'key', evicting the least recently used bounding box if the cache is full.
+func (c *boundCache) Set(key string, bound orb.Bound) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, exists := c.entries[key]
+
+	if exists {
+		el.Value.(*boundCacheEntry).bound = bound
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&boundCacheEntry{key: key, bound: bound})
+
+	for c.lru.Len() > c.max {
+
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*boundCacheEntry).key)
+	}
+}
+
+// densifyBound returns a closed ring tracing the edges of 'bound' with 'n' points along each edge.
+func densifyBound(bound orb.Bound, n int) orb.Ring {
+
+	corners := []orb.Point{
+		bound.Min,
+		orb.Point{bound.Max[0], bound.Min[1]},
+		bound.Max,
+		orb.Point{bound.Min[0], bound.Max[1]},
+	}
+
+	ring := make(orb.Ring, 0, (n*4)+1)
+
+	for i, start := range corners {
+
+		end := corners[(i+1)%len(corners)]
+
+		for j := 0; j < n; j++ {
+
+			f := float64(j) / float64(n)
+
+			pt := orb.Point{
+				start[0] + ((end[0] - start[0]) * f),
+				start[1] + ((end[1] - start[1]) * f),
+			}
+
+			ring = append(ring, pt)
+		}
+	}
+
+	ring = append(ring, ring[0])
+	return ring
+}
+
+// quoteString returns 's' as a single-quoted SQL string literal.
+func quoteString(s string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(s, "'", "''"))
+}
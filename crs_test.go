@@ -0,0 +1,206 @@
+package show
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestClampBound(t *testing.T) {
+
+	extent := orb.Bound{
+		Min: orb.Point{-122.5, 37.5},
+		Max: orb.Point{-122.25, 37.75},
+	}
+
+	world := orb.Bound{
+		Min: orb.Point{-180, -85.0511},
+		Max: orb.Point{180, 85.0511},
+	}
+
+	clamped, ok := clampBound(world, extent)
+
+	if !ok {
+		t.Fatalf("Expected world bound to intersect extent")
+	}
+
+	// The extent is padded by 1% of its size
+
+	expected := extent.Pad(0.0025)
+
+	if !clamped.Equal(expected) {
+		t.Fatalf("Unexpected clamped bound: %v", clamped)
+	}
+
+	outside := orb.Bound{
+		Min: orb.Point{0, 0},
+		Max: orb.Point{1, 1},
+	}
+
+	_, ok = clampBound(outside, extent)
+
+	if ok {
+		t.Fatalf("Expected bound outside extent not to intersect")
+	}
+
+	// Extents with no area are still padded
+
+	pt := orb.Point{-122.4, 37.6}
+
+	clamped, ok = clampBound(world, orb.Bound{Min: pt, Max: pt})
+
+	if !ok || clamped.Max[0] <= clamped.Min[0] || clamped.Max[1] <= clamped.Min[1] {
+		t.Fatalf("Expected point extent to be padded: %v", clamped)
+	}
+}
+
+func TestIsFiniteBound(t *testing.T) {
+
+	tests := map[float64]bool{
+		0:           true,
+		math.Inf(1): false,
+		math.NaN():  false,
+	}
+
+	for v, expected := range tests {
+
+		b := orb.Bound{
+			Min: orb.Point{-1, -1},
+			Max: orb.Point{1, v},
+		}
+
+		if isFiniteBound(b) != expected {
+			t.Fatalf("Unexpected result for %v", b)
+		}
+	}
+}
+
+func TestBoundCache(t *testing.T) {
+
+	c := newBoundCache(2)
+
+	bounds := map[string]orb.Bound{
+		"a": orb.Bound{Max: orb.Point{1, 1}},
+		"b": orb.Bound{Max: orb.Point{2, 2}},
+		"c": orb.Bound{Max: orb.Point{3, 3}},
+	}
+
+	c.Set("a", bounds["a"])
+	c.Set("b", bounds["b"])
+
+	// Touch "a" so that "b" is the least recently used bound
+
+	_, exists := c.Get("a")
+
+	if !exists {
+		t.Fatalf("Expected 'a' to be cached")
+	}
+
+	c.Set("c", bounds["c"])
+
+	_, exists = c.Get("b")
+
+	if exists {
+		t.Fatalf("Expected 'b' to be evicted")
+	}
+
+	for _, k := range []string{"a", "c"} {
+
+		b, exists := c.Get(k)
+
+		if !exists || !b.Equal(bounds[k]) {
+			t.Fatalf("Expected '%s' to be cached", k)
+		}
+	}
+}
+
+func TestTileConstraintsOutsideExtent(t *testing.T) {
+
+	extent := orb.Bound{
+		Min: orb.Point{-122.5, 37.5},
+		Max: orb.Point{-122.25, 37.75},
+	}
+
+	opts := &GetFeaturesForTileFuncOptions{
+		GeometryColumn:   "geometry",
+		GeometryEncoding: "WKB",
+		SourceCRS:        "EPSG:2227",
+		Extent:           &extent,
+	}
+
+	bound := orb.Bound{
+		Min: orb.Point{0, 0},
+		Max: orb.Point{1, 1},
+	}
+
+	// Tiles outside the extent are rejected before the database is queried to transform them
+
+	where, _, _, err := tileConstraints(context.Background(), opts, bound, 10)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tile constraints, %v", err)
+	}
+
+	if where != "false" {
+		t.Fatalf("Unexpected constraints: %s", where)
+	}
+}
+
+func TestGetFeaturesForTileSourceCRS(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+
+	datasource := countriesDerivedFixture(t, opts.Database, "countries-3857.parquet",
+		`SELECT id, name, ST_AsWKB(ST_Transform(geom, 'EPSG:4326', 'EPSG:3857', always_xy := true))::BLOB AS geometry FROM countries WHERE id IN ('CAN', 'MEX', 'USA')`, "")
+
+	crs_opts := *opts
+	crs_opts.Datasource = datasource
+	crs_opts.SourceCRS = "EPSG:3857"
+
+	// The tile containing the south-western United States and Mexico
+
+	tile := maptile.New(1, 3, 3)
+
+	collections, err := GetFeaturesForTileFunc(&crs_opts)(ctx, "countries", &tile)
+
+	if err != nil {
+		t.Fatalf("Failed to get features for tile, %v", err)
+	}
+
+	ids := make([]string, 0)
+
+	for _, f := range collections["countries"].Features {
+
+		ids = append(ids, f.Properties["id"].(string))
+
+		// Geometries must be transformed back to longitudes and latitudes
+
+		bound := f.Geometry.Bound()
+
+		if bound.Min[0] < -180 || bound.Max[0] > 180 || bound.Min[1] < -90 || bound.Max[1] > 90 {
+			t.Fatalf("Expected %s to be returned in EPSG:4326, got bound %v", f.Properties["id"], bound)
+		}
+
+		if !bound.Intersects(tile.Bound()) {
+			t.Fatalf("Expected %s to intersect tile, got bound %v", f.Properties["id"], bound)
+		}
+	}
+
+	slices.Sort(ids)
+
+	// The same features must be returned as for the data source in EPSG:4326
+
+	opts.Where = `"id" IN ('CAN', 'MEX', 'USA')`
+
+	expected := tileFeatureIds(t, GetFeaturesForTileFunc(opts), 3, 1, 3)
+
+	if !slices.Contains(expected, "USA") || !slices.Equal(ids, expected) {
+		t.Fatalf("Unexpected features: %v, expected %v", ids, expected)
+	}
+}
@@ -0,0 +1,91 @@
+package show
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Geometry encodings defined by the GeoParquet specification. The "native" encodings are GeoArrow (separated) encodings
+// where coordinates are stored as structs with "x" and "y" fields, nested in lists.
+// See also: https://github.com/opengeospatial/geoparquet/blob/main/format-specs/geoparquet.md#native-encodings-based-on-geoarrow
+const (
+	ENCODING_WKB             string = "wkb"
+	ENCODING_POINT           string = "point"
+	ENCODING_LINESTRING      string = "linestring"
+	ENCODING_POLYGON         string = "polygon"
+	ENCODING_MULTIPOINT      string = "multipoint"
+	ENCODING_MULTILINESTRING string = "multilinestring"
+	ENCODING_MULTIPOLYGON    string = "multipolygon"
+)
+
+// ENCODING_GEOMETRY is not part of the GeoParquet specification. It signals that a column already contains (DuckDB spatial)
+// GEOMETRY values, for example the results of a query using spatial functions.
+const ENCODING_GEOMETRY string = "geometry"
+
+// ENCODING_WKT is not part of the GeoParquet specification. It signals that a column contains Well-Known Text (WKT) strings,
+// for example the results of a query reading a CSV file.
+const ENCODING_WKT string = "wkt"
+
+// IsSupportedEncoding reports whether 'encoding' is a geometry encoding that can be decoded.
+func IsSupportedEncoding(encoding string) bool {
+
+	switch strings.ToLower(encoding) {
+	case "", ENCODING_WKB, ENCODING_WKT, ENCODING_GEOMETRY, ENCODING_POINT, ENCODING_LINESTRING, ENCODING_POLYGON, ENCODING_MULTIPOINT, ENCODING_MULTILINESTRING, ENCODING_MULTIPOLYGON:
+		return true
+	default:
+		return false
+	}
+}
+
+// decodeGeometryExpression returns the SQL expression used to decode the geometries, encoded as 'encoding', in the column
+// named 'col' in to (DuckDB spatial) GEOMETRY values. An empty encoding is assumed to mean "WKB". Unsupported encodings (see
+// `IsSupportedEncoding`) return an error.
+func decodeGeometryExpression(col string, encoding string) (string, error) {
+
+	quoted_col := fmt.Sprintf(`"%s"`, col)
+
+	switch strings.ToLower(encoding) {
+	case "", ENCODING_WKB:
+		return fmt.Sprintf(`ST_GeomFromWkb(%s::WKB_BLOB)`, quoted_col), nil
+	case ENCODING_WKT:
+		return fmt.Sprintf(`ST_GeomFromText(%s)`, quoted_col), nil
+	case ENCODING_GEOMETRY:
+		return quoted_col, nil
+	case ENCODING_POINT:
+		return geoarrowPoint(quoted_col), nil
+	case ENCODING_LINESTRING:
+		return geoarrowLineString(quoted_col, "pt"), nil
+	case ENCODING_POLYGON:
+		return geoarrowPolygon(quoted_col, "ring"), nil
+	case ENCODING_MULTIPOINT:
+		return fmt.Sprintf(`ST_Collect(list_transform(%s, pt -> %s))`, quoted_col, geoarrowPoint("pt")), nil
+	case ENCODING_MULTILINESTRING:
+		return fmt.Sprintf(`ST_Collect(list_transform(%s, line -> %s))`, quoted_col, geoarrowLineString("line", "pt")), nil
+	case ENCODING_MULTIPOLYGON:
+		return fmt.Sprintf(`ST_Collect(list_transform(%s, poly -> %s))`, quoted_col, geoarrowPolygon("poly", "ring")), nil
+	default:
+		return "", fmt.Errorf("Unsupported geometry encoding '%s'", encoding)
+	}
+}
+
+// geoarrowPoint returns the SQL expression to create a point from the struct 'expr'.
+func geoarrowPoint(expr string) string {
+	return fmt.Sprintf(`ST_Point(%s.x, %s.y)`, expr, expr)
+}
+
+// geoarrowLineString returns the SQL expression to create a linestring from the list of point structs 'expr'. The
+// name of the variable used for each point in the list is defined by 'pt'.
+func geoarrowLineString(expr string, pt string) string {
+	return fmt.Sprintf(`ST_MakeLine(list_transform(%s, %s -> %s))`, expr, pt, geoarrowPoint(pt))
+}
+
+// geoarrowPolygon returns the SQL expression to create a polygon from the list of rings (lists of point structs) 'expr'
+// where the first ring is the exterior ring and any others are interior rings. The name of the variable used for each
+// interior ring is defined by 'ring'.
+func geoarrowPolygon(expr string, ring string) string {
+
+	exterior := geoarrowLineString(fmt.Sprintf("%s[1]", expr), "pt")
+	interior := fmt.Sprintf(`list_transform(%s[2:], %s -> %s)`, expr, ring, geoarrowLineString(ring, "pt"))
+
+	return fmt.Sprintf(`ST_MakePolygon(%s, %s)`, exterior, interior)
+}
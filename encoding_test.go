@@ -0,0 +1,92 @@
+package show
+
+import (
+	"testing"
+)
+
+func TestDecodeGeometryExpression(t *testing.T) {
+
+	tests := map[string]string{
+		"":                       `ST_GeomFromWkb("geom"::WKB_BLOB)`,
+		"WKB":                    `ST_GeomFromWkb("geom"::WKB_BLOB)`,
+		ENCODING_WKT:             `ST_GeomFromText("geom")`,
+		ENCODING_GEOMETRY:        `"geom"`,
+		ENCODING_POINT:           `ST_Point("geom".x, "geom".y)`,
+		ENCODING_LINESTRING:      `ST_MakeLine(list_transform("geom", pt -> ST_Point(pt.x, pt.y)))`,
+		ENCODING_POLYGON:         `ST_MakePolygon(ST_MakeLine(list_transform("geom"[1], pt -> ST_Point(pt.x, pt.y))), list_transform("geom"[2:], ring -> ST_MakeLine(list_transform(ring, pt -> ST_Point(pt.x, pt.y)))))`,
+		ENCODING_MULTIPOINT:      `ST_Collect(list_transform("geom", pt -> ST_Point(pt.x, pt.y)))`,
+		ENCODING_MULTILINESTRING: `ST_Collect(list_transform("geom", line -> ST_MakeLine(list_transform(line, pt -> ST_Point(pt.x, pt.y)))))`,
+		ENCODING_MULTIPOLYGON:    `ST_Collect(list_transform("geom", poly -> ST_MakePolygon(ST_MakeLine(list_transform(poly[1], pt -> ST_Point(pt.x, pt.y))), list_transform(poly[2:], ring -> ST_MakeLine(list_transform(ring, pt -> ST_Point(pt.x, pt.y)))))))`,
+	}
+
+	for encoding, expected := range tests {
+
+		if !IsSupportedEncoding(encoding) {
+			t.Fatalf("Expected encoding '%s' to be supported", encoding)
+		}
+
+		expr, err := decodeGeometryExpression("geom", encoding)
+
+		if err != nil {
+			t.Fatalf("Failed to derive expression for encoding '%s', %v", encoding, err)
+		}
+
+		if expr != expected {
+			t.Fatalf("Unexpected expression for encoding '%s': %s", encoding, expr)
+		}
+	}
+
+	if IsSupportedEncoding("geoarrow.box") {
+		t.Fatalf("Expected unknown encoding to be unsupported")
+	}
+
+	_, err := decodeGeometryExpression("geom", "geoarrow.box")
+
+	if err == nil {
+		t.Fatalf("Expected unknown encoding to fail")
+	}
+}
+
+func TestSourceGeometryExpression(t *testing.T) {
+
+	opts := &GetFeaturesForTileFuncOptions{
+		GeometryColumn:   "geom",
+		GeometryEncoding: ENCODING_POINT,
+		SourceCRS:        "EPSG:2227",
+	}
+
+	expr, err := geometryExpression(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to derive geometry expression, %v", err)
+	}
+
+	expected := `ST_Transform(ST_Point("geom".x, "geom".y), 'EPSG:2227', 'EPSG:4326', always_xy := true)`
+
+	if expr != expected {
+		t.Fatalf("Unexpected geometry expression: %s", expr)
+	}
+
+	// Materialized tables store decoded geometries so the encoding is ignored
+
+	opts.Table = "features"
+
+	expr, err = sourceGeometryExpression(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to derive source geometry expression, %v", err)
+	}
+
+	if expr != `"geom"` {
+		t.Fatalf("Unexpected source geometry expression for materialized table: %s", expr)
+	}
+
+	opts.Table = ""
+	opts.GeometryEncoding = "geoarrow.box"
+
+	_, err = geometryExpression(opts)
+
+	if err == nil {
+		t.Fatalf("Expected unknown encoding to fail")
+	}
+}
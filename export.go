@@ -0,0 +1,589 @@
+package show
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulmach/orb"
+	orb_mvt "github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/sfomuseum/go-http-mvt"
+)
+
+// Valid formats for exported tiles.
+const (
+	// Export tiles to a PMTiles (v3) archive.
+	EXPORT_FORMAT_PMTILES string = "pmtiles"
+	// Export tiles to an MBTiles (SQLite) database.
+	EXPORT_FORMAT_MBTILES string = "mbtiles"
+	// Export tiles to a directory tree of {z}/{x}/{y}.mvt files.
+	EXPORT_FORMAT_DIRECTORY string = "directory"
+)
+
+// The default maximum zoom level for exported tiles.
+const default_export_max_zoom int = 14
+
+// The interval at which the progress of an export is logged.
+const export_progress_interval time.Duration = 5 * time.Second
+
+// The name of the application recorded in the metadata for exported tiles.
+const export_generator string = "github.com/sfomuseum/go-geoparquet-show"
+
+// IsValidExportFormat reports whether 'format' is a valid format for exported tiles.
+func IsValidExportFormat(format string) bool {
+
+	switch format {
+	case EXPORT_FORMAT_PMTILES, EXPORT_FORMAT_MBTILES, EXPORT_FORMAT_DIRECTORY:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExportOptions defines options for exporting GeoParquet data as vector tiles.
+type ExportOptions struct {
+	// Configuration details for the layers to export. Options specific to serving tiles (for example 'Port' or 'Renderer') are ignored.
+	RunOptions *RunOptions
+	// The path to write tiles to.
+	Output string
+	// The format to write tiles in. Valid options are: pmtiles, mbtiles, directory. If empty the format is derived from the extension of 'Output'.
+	Format string
+	// The name of the tileset recorded in the metadata for exported tiles. If empty the name is derived from 'Output'.
+	Name string
+	// The minimum zoom level to export tiles for.
+	MinZoom int
+	// The maximum zoom level to export tiles for.
+	MaxZoom int
+	// The number of tiles to produce in parallel. If 0 the number of CPUs is used.
+	Workers int
+}
+
+// RunExport will export GeoParquet data as vector tiles using the default export flag set to parse 'args'.
+func RunExport(ctx context.Context, args []string) error {
+	fs := DefaultExportFlagSet()
+	return RunExportWithFlagSet(ctx, fs, args)
+}
+
+// RunExportWithFlagSet will export GeoParquet data as vector tiles using options derived from 'fs' after parsing 'args'.
+func RunExportWithFlagSet(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	opts, err := ExportOptionsFromFlagSet(ctx, fs, args)
+
+	if err != nil {
+		return err
+	}
+
+	return RunExportWithOptions(ctx, opts)
+}
+
+// RunExportWithOptions will export GeoParquet data as vector tiles using configuration details provided by 'opts'. Tiles are produced
+// for every zoom level in the range defined by 'opts', over the combined extent of all the layers, and encoded in a single tileset.
+// Empty tiles are not written.
+func RunExportWithOptions(ctx context.Context, opts *ExportOptions) error {
+
+	if opts.Output == "" {
+		return fmt.Errorf("Missing output")
+	}
+
+	format := opts.Format
+
+	if format == "" {
+		format = exportFormat(opts.Output)
+	}
+
+	if !IsValidExportFormat(format) {
+		return fmt.Errorf("Invalid export format '%s'", format)
+	}
+
+	if opts.MinZoom < 0 || opts.MaxZoom > max_zoom_level || opts.MinZoom > opts.MaxZoom {
+		return fmt.Errorf("Invalid zoom range (%d-%d)", opts.MinZoom, opts.MaxZoom)
+	}
+
+	workers := opts.Workers
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	name := opts.Name
+
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(opts.Output), filepath.Ext(opts.Output))
+	}
+
+	ts, err := setupTileset(ctx, opts.RunOptions)
+
+	if err != nil {
+		return err
+	}
+
+	defer ts.Close()
+
+	if len(ts.Archives) > 0 {
+		return fmt.Errorf("PMTiles data sources can not be exported")
+	}
+
+	bound := orb.Bound{
+		Min: orb.Point{ts.Config.MinX, ts.Config.MinY},
+		Max: orb.Point{ts.Config.MaxX, ts.Config.MaxY},
+	}
+
+	wr, err := newTileWriter(ctx, opts.RunOptions, format, opts.Output)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create tile writer, %w", err)
+	}
+
+	slog.Info("Export tiles", "output", opts.Output, "format", format, "min_zoom", opts.MinZoom, "max_zoom", opts.MaxZoom, "workers", workers)
+
+	t1 := time.Now()
+
+	layers := newVectorLayers()
+
+	written, err := exportTiles(ctx, ts.Callbacks, bound, opts.MinZoom, opts.MaxZoom, workers, layers, wr)
+
+	if err != nil {
+		wr.Abort()
+		return err
+	}
+
+	md := &exportMetadata{
+		TileJSON:     tilejson_version,
+		Name:         name,
+		Format:       "pbf",
+		Generator:    export_generator,
+		MinZoom:      opts.MinZoom,
+		MaxZoom:      opts.MaxZoom,
+		Bounds:       [4]float64{bound.Min[0], bound.Min[1], bound.Max[0], bound.Max[1]},
+		Center:       [3]float64{bound.Center()[0], bound.Center()[1], float64(opts.MinZoom)},
+		VectorLayers: layers.List(),
+	}
+
+	err = wr.Close(ctx, md)
+
+	if err != nil {
+		wr.Abort()
+		return fmt.Errorf("Failed to finalize export, %w", err)
+	}
+
+	slog.Info("Export complete", "output", opts.Output, "tiles", written, "time", time.Since(t1))
+	return nil
+}
+
+// exportFormat derives the format for exported tiles from the extension of 'path'. Paths without a known extension are
+// assumed to be directories.
+func exportFormat(path string) string {
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pmtiles":
+		return EXPORT_FORMAT_PMTILES
+	case ".mbtiles":
+		return EXPORT_FORMAT_MBTILES
+	default:
+		return EXPORT_FORMAT_DIRECTORY
+	}
+}
+
+// exportTiles produces the tiles for every zoom level between 'min_zoom' and 'max_zoom' which intersect 'bound', using
+// 'workers' goroutines, and writes those tiles which contain features to 'wr'. It returns the number of tiles written.
+func exportTiles(ctx context.Context, callbacks map[string]mvt.GetFeaturesCallbackFunc, bound orb.Bound, min_zoom int, max_zoom int, workers int, layers *vectorLayers, wr tileWriter) (int64, error) {
+
+	ranges := []*tileWalkRange{
+		&tileWalkRange{
+			Layer:   export_layer,
+			Bound:   bound,
+			MinZoom: min_zoom,
+			MaxZoom: max_zoom,
+		},
+	}
+
+	slog.Info("Tiles to export", "count", countTileRanges(ranges))
+
+	tiles_handler, err := newMVTHandler(exportFeaturesFunc(callbacks))
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to create tiles handler, %w", err)
+	}
+
+	var written atomic.Int64
+
+	// Tiles are encoded in parallel but written one at a time so tile writers do not need to be safe for concurrent use.
+
+	wr_mu := new(sync.Mutex)
+
+	walk_opts := &tileWalkOptions{
+		Ranges:           ranges,
+		Workers:          workers,
+		ProgressMessage:  "Export progress",
+		ProgressInterval: export_progress_interval,
+		ProgressAttrs: func() []any {
+			return []any{"written", written.Load()}
+		},
+	}
+
+	_, err = walkTilesParallel(ctx, walk_opts, func(ctx context.Context, layer string, t maptile.Tile) error {
+
+		body, err := encodeTile(ctx, tiles_handler, t, layers)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode tile %d/%d/%d, %w", t.Z, t.X, t.Y, err)
+		}
+
+		if len(body) == 0 {
+			return nil
+		}
+
+		wr_mu.Lock()
+		defer wr_mu.Unlock()
+
+		err = wr.WriteTile(ctx, t, body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to write tile %d/%d/%d, %w", t.Z, t.X, t.Y, err)
+		}
+
+		written.Add(1)
+		return nil
+	})
+
+	if err != nil {
+		return written.Load(), err
+	}
+
+	return written.Load(), nil
+}
+
+// The name of the (vector tile) layer requested from the tiles handler used to export tiles. Since every tile contains all
+// the layers being exported it is only used to construct the request path.
+const export_layer string = "export"
+
+// exportFeaturesFunc returns a `mvt.GetFeaturesCallbackFunc` callback function which ignores the requested layer name and derives
+// the features for a tile from all the functions in 'callbacks', so that a single request yields a tile containing every layer.
+func exportFeaturesFunc(callbacks map[string]mvt.GetFeaturesCallbackFunc) mvt.GetFeaturesCallbackFunc {
+
+	fn := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		collections := make(map[string]*geojson.FeatureCollection)
+
+		for name, cb := range callbacks {
+
+			layer_collections, err := cb(ctx, name, t)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to get features for layer '%s', %w", name, err)
+			}
+
+			for k, fc := range layer_collections {
+				collections[k] = fc
+			}
+		}
+
+		return collections, nil
+	}
+
+	return fn
+}
+
+// encodeTile requests tile 't' from 'tiles_handler', a tiles handler created by `newMVTHandler` using `exportFeaturesFunc`, and returns
+// the (uncompressed) MVT tile it produces, so that exported tiles are encoded exactly like the tiles which are served. The (vector tile)
+// layers in the tile are recorded in 'layers'. Tiles without any features yield an empty byte slice.
+func encodeTile(ctx context.Context, tiles_handler http.Handler, t maptile.Tile, layers *vectorLayers) ([]byte, error) {
+
+	path := fmt.Sprintf("/tiles/%s/%d/%d/%d.mvt", export_layer, t.Z, t.X, t.Y)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	rsp := newTileResponseWriter()
+	tiles_handler.ServeHTTP(rsp, req)
+
+	// The tile callbacks do not return an error when their context is cancelled so check
+	// explicitly to avoid writing incomplete tiles.
+
+	err = ctx.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.status != http.StatusOK {
+		return nil, fmt.Errorf("Unexpected status code %d (%s)", rsp.status, strings.TrimSpace(rsp.body.String()))
+	}
+
+	body := rsp.body.Bytes()
+
+	mvt_layers, err := orb_mvt.Unmarshal(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode tile, %w", err)
+	}
+
+	// Unlike tiles served on demand, layers without any features are not encoded
+	// so that empty tiles can be skipped entirely.
+
+	non_empty := make(orb_mvt.Layers, 0)
+
+	for _, l := range mvt_layers {
+
+		if len(l.Features) > 0 {
+			non_empty = append(non_empty, l)
+		}
+	}
+
+	if len(non_empty) == 0 {
+		return nil, nil
+	}
+
+	layers.Record(non_empty, int(t.Z))
+
+	if len(non_empty) == len(mvt_layers) {
+		return body, nil
+	}
+
+	return orb_mvt.Marshal(non_empty)
+}
+
+// gzipBytes returns the gzip-compressed version of 'body'.
+func gzipBytes(body []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	_, err := gz.Write(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = gz.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// The version of the TileJSON specification that `exportMetadata` conforms to.
+const tilejson_version string = "3.0.0"
+
+// exportMetadata defines TileJSON-style metadata describing a set of exported tiles.
+type exportMetadata struct {
+	TileJSON     string         `json:"tilejson"`
+	Name         string         `json:"name"`
+	Format       string         `json:"format"`
+	Generator    string         `json:"generator"`
+	Tiles        []string       `json:"tiles,omitempty"`
+	MinZoom      int            `json:"minzoom"`
+	MaxZoom      int            `json:"maxzoom"`
+	Bounds       [4]float64     `json:"bounds"`
+	Center       [3]float64     `json:"center"`
+	VectorLayers []*vectorLayer `json:"vector_layers"`
+}
+
+// vectorLayer describes a (vector tile) layer, and the properties of its features, in `exportMetadata`.
+type vectorLayer struct {
+	ID string `json:"id"`
+	// A dictionary of property names and their types. Valid types are: String, Number, Boolean, Mixed.
+	Fields  map[string]string `json:"fields"`
+	MinZoom int               `json:"minzoom"`
+	MaxZoom int               `json:"maxzoom"`
+}
+
+// vectorLayers records the (vector tile) layers, and the properties of their features, observed while exporting tiles.
+// It is safe for concurrent use.
+type vectorLayers struct {
+	mu     sync.Mutex
+	layers map[string]*vectorLayer
+}
+
+// newVectorLayers returns a new (empty) `vectorLayers` instance.
+func newVectorLayers() *vectorLayers {
+
+	l := &vectorLayers{
+		layers: make(map[string]*vectorLayer),
+	}
+
+	return l
+}
+
+// Record records the names, and the types of the properties, of 'mvt_layers' encoded in a tile at zoom level 'zoom'.
+func (l *vectorLayers) Record(mvt_layers orb_mvt.Layers, zoom int) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, mvt_l := range mvt_layers {
+
+		v, exists := l.layers[mvt_l.Name]
+
+		if !exists {
+
+			v = &vectorLayer{
+				ID:      mvt_l.Name,
+				Fields:  make(map[string]string),
+				MinZoom: zoom,
+				MaxZoom: zoom,
+			}
+
+			l.layers[mvt_l.Name] = v
+		}
+
+		v.MinZoom = min(v.MinZoom, zoom)
+		v.MaxZoom = max(v.MaxZoom, zoom)
+
+		for _, f := range mvt_l.Features {
+
+			for k, value := range f.Properties {
+
+				field_type := fieldType(value)
+
+				if field_type == "" {
+					continue
+				}
+
+				current, exists := v.Fields[k]
+
+				if exists && current != field_type {
+					field_type = "Mixed"
+				}
+
+				v.Fields[k] = field_type
+			}
+		}
+	}
+}
+
+// List returns the list of layers recorded, sorted by name.
+func (l *vectorLayers) List() []*vectorLayer {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	list := make([]*vectorLayer, 0)
+
+	for _, v := range l.layers {
+		list = append(list, v)
+	}
+
+	slices.SortFunc(list, func(a, b *vectorLayer) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return list
+}
+
+// fieldType returns the (TileJSON) type of the property value 'v'. NULL values return an empty string.
+func fieldType(v any) string {
+
+	switch v.(type) {
+	case nil:
+		return ""
+	case string:
+		return "String"
+	case bool:
+		return "Boolean"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return "Number"
+	default:
+		return "Mixed"
+	}
+}
+
+// tileWriter defines an interface for writing exported tiles. Implementations do not need to be safe for concurrent use.
+type tileWriter interface {
+	// WriteTile writes 'body', an (uncompressed) MVT tile, for tile 't'.
+	WriteTile(context.Context, maptile.Tile, []byte) error
+	// Close writes 'md' and finalizes the export.
+	Close(context.Context, *exportMetadata) error
+	// Abort discards any temporary files created during an export which did not complete.
+	Abort()
+}
+
+// newTileWriter returns a new `tileWriter` instance for writing tiles in 'format' to 'path'. 'opts' is used to
+// access the (DuckDB) database used to write MBTiles databases.
+func newTileWriter(ctx context.Context, opts *RunOptions, format string, path string) (tileWriter, error) {
+
+	switch format {
+	case EXPORT_FORMAT_PMTILES:
+		return newPMTilesWriter(path)
+	case EXPORT_FORMAT_MBTILES:
+		return newMBTilesWriter(ctx, opts.Database, path, extensionOptions(opts))
+	case EXPORT_FORMAT_DIRECTORY:
+		return newDirectoryWriter(path)
+	default:
+		return nil, fmt.Errorf("Invalid export format '%s'", format)
+	}
+}
+
+// directoryWriter implements the `tileWriter` interface for writing tiles to a directory tree of {z}/{x}/{y}.mvt files.
+type directoryWriter struct {
+	root string
+}
+
+// newDirectoryWriter returns a new `directoryWriter` instance for writing tiles to 'root', creating it if necessary.
+func newDirectoryWriter(root string) (*directoryWriter, error) {
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create directory, %w", err)
+	}
+
+	wr := &directoryWriter{
+		root: root,
+	}
+
+	return wr, nil
+}
+
+func (wr *directoryWriter) WriteTile(ctx context.Context, t maptile.Tile, body []byte) error {
+
+	dir := filepath.Join(wr.root, fmt.Sprintf("%d", t.Z), fmt.Sprintf("%d", t.X))
+
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.mvt", t.Y)), body, 0644)
+}
+
+func (wr *directoryWriter) Close(ctx context.Context, md *exportMetadata) error {
+
+	md.Tiles = []string{
+		"{z}/{x}/{y}.mvt",
+	}
+
+	enc_md, err := json.MarshalIndent(md, "", "  ")
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal metadata, %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(wr.root, "metadata.json"), enc_md, 0644)
+}
+
+func (wr *directoryWriter) Abort() {
+	// Tiles which have already been written are left in place.
+}
@@ -0,0 +1,244 @@
+package show
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/sfomuseum/go-http-mvt"
+)
+
+func TestExportFormat(t *testing.T) {
+
+	tests := map[string]string{
+		"example.pmtiles":  EXPORT_FORMAT_PMTILES,
+		"example.MBTILES":  EXPORT_FORMAT_MBTILES,
+		"/usr/local/tiles": EXPORT_FORMAT_DIRECTORY,
+	}
+
+	for path, expected := range tests {
+
+		if exportFormat(path) != expected {
+			t.Fatalf("Unexpected format for %s: %s", path, exportFormat(path))
+		}
+	}
+}
+
+func TestTileRange(t *testing.T) {
+
+	world := orb.Bound{
+		Min: orb.Point{-180, -90},
+		Max: orb.Point{180, 90},
+	}
+
+	min_t, max_t := tileRange(world, 2)
+
+	if min_t.X != 0 || min_t.Y != 0 || max_t.X != 3 || max_t.Y != 3 {
+		t.Fatalf("Unexpected tile range for world: %v %v", min_t, max_t)
+	}
+
+	sfo := orb.Bound{
+		Min: orb.Point{-122.40, 37.60},
+		Max: orb.Point{-122.35, 37.63},
+	}
+
+	min_t, max_t = tileRange(sfo, 0)
+
+	if min_t != max_t || min_t.X != 0 || min_t.Y != 0 {
+		t.Fatalf("Unexpected tile range for SFO: %v %v", min_t, max_t)
+	}
+}
+
+func TestExportTiles(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Yield a single point, in the north-west quadrant of the world, for every tile
+
+	cb := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		fc := geojson.NewFeatureCollection()
+
+		if t.Bound().Contains(orb.Point{-100, 40}) {
+			f := geojson.NewFeature(orb.Point{-100, 40})
+			f.Properties["name"] = "example"
+			fc.Append(f)
+		}
+
+		return map[string]*geojson.FeatureCollection{
+			layer: fc,
+		}, nil
+	}
+
+	callbacks := map[string]mvt.GetFeaturesCallbackFunc{
+		"points": cb,
+	}
+
+	world := orb.Bound{
+		Min: orb.Point{-180, -85},
+		Max: orb.Point{180, 85},
+	}
+
+	path := filepath.Join(t.TempDir(), "example.pmtiles")
+
+	wr, err := newPMTilesWriter(path)
+
+	if err != nil {
+		t.Fatalf("Failed to create writer, %v", err)
+	}
+
+	layers := newVectorLayers()
+
+	written, err := exportTiles(ctx, callbacks, world, 0, 3, 4, layers, wr)
+
+	if err != nil {
+		t.Fatalf("Failed to export tiles, %v", err)
+	}
+
+	// One tile per zoom level contains the point
+
+	if written != 4 {
+		t.Fatalf("Expected 4 tiles to be written, got %d", written)
+	}
+
+	err = wr.Close(ctx, &exportMetadata{Name: "example", VectorLayers: layers.List()})
+
+	if err != nil {
+		t.Fatalf("Failed to close writer, %v", err)
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatalf("Failed to read archive, %v", err)
+	}
+
+	if string(body[0:7]) != "PMTiles" || body[7] != 3 {
+		t.Fatalf("Invalid PMTiles header")
+	}
+
+	addressed := binary.LittleEndian.Uint64(body[72:])
+	data_offset := binary.LittleEndian.Uint64(body[56:])
+	data_length := binary.LittleEndian.Uint64(body[64:])
+
+	if addressed != 4 || body[100] != 0 || body[101] != 3 {
+		t.Fatalf("Unexpected header values")
+	}
+
+	if data_offset+data_length != uint64(len(body)) {
+		t.Fatalf("Unexpected tile data length")
+	}
+
+	list := layers.List()
+
+	if len(list) != 1 || list[0].ID != "points" || list[0].Fields["name"] != "String" || list[0].MaxZoom != 3 {
+		t.Fatalf("Unexpected vector layers: %v", list)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+
+	if len(matches) != 0 {
+		t.Fatalf("Temporary files were not removed")
+	}
+}
+
+func TestEncodeTile(t *testing.T) {
+
+	ctx := context.Background()
+
+	point_cb := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		fc := geojson.NewFeatureCollection()
+
+		f := geojson.NewFeature(orb.Point{-100, 40})
+		f.Properties["name"] = "example"
+		fc.Append(f)
+
+		return map[string]*geojson.FeatureCollection{
+			layer: fc,
+		}, nil
+	}
+
+	empty_cb := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		return map[string]*geojson.FeatureCollection{
+			layer: geojson.NewFeatureCollection(),
+		}, nil
+	}
+
+	tile := maptile.New(0, 0, 0)
+
+	// Exported tiles are encoded exactly like the tiles which are served
+
+	served_handler, err := newMVTHandler(GetFeaturesForLayersFunc(map[string]mvt.GetFeaturesCallbackFunc{
+		"points": point_cb,
+	}))
+
+	if err != nil {
+		t.Fatalf("Failed to create tiles handler, %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tiles/points/0/0/0.mvt", nil)
+	rsp := httptest.NewRecorder()
+
+	served_handler.ServeHTTP(rsp, req)
+
+	// Layers without any features are not encoded in exported tiles
+
+	callbacks := map[string]mvt.GetFeaturesCallbackFunc{
+		"points": point_cb,
+		"empty":  empty_cb,
+	}
+
+	tiles_handler, err := newMVTHandler(exportFeaturesFunc(callbacks))
+
+	if err != nil {
+		t.Fatalf("Failed to create tiles handler, %v", err)
+	}
+
+	layers := newVectorLayers()
+
+	body, err := encodeTile(ctx, tiles_handler, tile, layers)
+
+	if err != nil {
+		t.Fatalf("Failed to encode tile, %v", err)
+	}
+
+	if !bytes.Equal(body, rsp.Body.Bytes()) {
+		t.Fatalf("Expected exported tile to match served tile")
+	}
+
+	list := layers.List()
+
+	if len(list) != 1 || list[0].ID != "points" {
+		t.Fatalf("Unexpected vector layers: %v", list)
+	}
+
+	// Tiles without any features yield an empty byte slice
+
+	tiles_handler, err = newMVTHandler(exportFeaturesFunc(map[string]mvt.GetFeaturesCallbackFunc{
+		"empty": empty_cb,
+	}))
+
+	if err != nil {
+		t.Fatalf("Failed to create tiles handler, %v", err)
+	}
+
+	body, err = encodeTile(ctx, tiles_handler, tile, layers)
+
+	if err != nil {
+		t.Fatalf("Failed to encode tile, %v", err)
+	}
+
+	if len(body) != 0 {
+		t.Fatalf("Expected empty tile to yield no bytes")
+	}
+}
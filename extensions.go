@@ -0,0 +1,186 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The name of the DuckDB extension providing spatial types and functions.
+const spatial_extension string = "spatial"
+
+// The name of the DuckDB extension used to read and write SQLite databases.
+const sqlite_extension string = "sqlite"
+
+// The name of the files the DuckDB sqlite extension is distributed, and installed, as.
+const sqlite_extension_file string = "sqlite_scanner"
+
+// ExtensionOptions defines configuration details for loading DuckDB extensions without network access.
+type ExtensionOptions struct {
+	// An optional path to a directory where DuckDB extensions are installed, and installed to. If empty the DuckDB
+	// default ("~/.duckdb/extensions") is used.
+	Directory string
+	// An optional path to a spatial extension (".duckdb_extension") file to load. If set the extension is never installed.
+	SpatialExtension string
+	// An optional path to a sqlite extension (".duckdb_extension") file to load. If set the extension is never installed.
+	SQLiteExtension string
+}
+
+// extensionDB is the subset of methods, implemented by both `sql.DB` and `sql.Conn`, used to install and load extensions.
+type extensionDB interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+// setExtensionDirectory configures 'db' to install, and load, DuckDB extensions from 'path'.
+func setExtensionDirectory(ctx context.Context, db extensionDB, path string) error {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to derive absolute path for extension directory, %w", err)
+	}
+
+	q := fmt.Sprintf(`SET GLOBAL extension_directory = '%s'`, strings.ReplaceAll(abs_path, "'", "''"))
+
+	_, err = db.ExecContext(ctx, q)
+
+	if err != nil {
+		return fmt.Errorf("Failed to set extension directory, %w", err)
+	}
+
+	slog.Debug("Use extension directory", "path", abs_path)
+	return nil
+}
+
+// loadExtension loads the DuckDB extension 'name', installing it first only if it is not already installed (or built in).
+// Installing an extension requires network access.
+func loadExtension(ctx context.Context, db extensionDB, name string) error {
+
+	var installed bool
+	var loaded bool
+
+	q := `SELECT installed, loaded FROM duckdb_extensions() WHERE extension_name = ?`
+
+	err := db.QueryRowContext(ctx, q, name).Scan(&installed, &loaded)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("Failed to determine whether the %s extension is installed, %w", name, err)
+	}
+
+	if loaded {
+		return nil
+	}
+
+	if !installed {
+
+		slog.Debug("Install extension", "name", name)
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf("INSTALL %s", name))
+
+		if err != nil {
+			return fmt.Errorf("Failed to install the %s extension, %w", name, err)
+		}
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("LOAD %s", name))
+
+	if err != nil {
+		return fmt.Errorf("Failed to load the %s extension, %w", name, err)
+	}
+
+	return nil
+}
+
+// loadSpatialExtension loads the DuckDB spatial extension using the configuration details in 'opts', which may be nil. If the
+// extension can not be loaded the error describes how to load it on machines without network access.
+func loadSpatialExtension(ctx context.Context, db extensionDB, opts *ExtensionOptions) error {
+
+	if opts == nil {
+		opts = &ExtensionOptions{}
+	}
+
+	return loadOfflineExtension(ctx, db, spatial_extension, spatial_extension, opts.Directory, opts.SpatialExtension)
+}
+
+// loadSQLiteExtension loads the DuckDB sqlite extension, used to write MBTiles databases, using the configuration details in 'opts',
+// which may be nil. If the extension can not be loaded the error describes how to load it on machines without network access.
+func loadSQLiteExtension(ctx context.Context, db extensionDB, opts *ExtensionOptions) error {
+
+	if opts == nil {
+		opts = &ExtensionOptions{}
+	}
+
+	return loadOfflineExtension(ctx, db, sqlite_extension, sqlite_extension_file, opts.Directory, opts.SQLiteExtension)
+}
+
+// loadOfflineExtension loads the DuckDB extension 'name', whose files are named 'file_name', from 'path' if not empty or
+// otherwise from 'directory' (or the DuckDB default directory, if empty) installing it first if necessary. If the extension
+// can not be loaded the error describes how to load it on machines without network access.
+func loadOfflineExtension(ctx context.Context, db extensionDB, name string, file_name string, directory string, path string) error {
+
+	err := func() error {
+
+		if directory != "" {
+
+			err := setExtensionDirectory(ctx, db, directory)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if path == "" {
+			return loadExtension(ctx, db, name)
+		}
+
+		_, err := os.Stat(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to stat %s extension file, %w", name, err)
+		}
+
+		q := fmt.Sprintf(`LOAD '%s'`, strings.ReplaceAll(path, "'", "''"))
+
+		_, err = db.ExecContext(ctx, q)
+
+		if err != nil {
+			return fmt.Errorf("Failed to load the %s extension from %s, %w", name, path, err)
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		return fmt.Errorf("%w. %s", err, extensionHelp(ctx, db, name, file_name))
+	}
+
+	return nil
+}
+
+// extensionHelp returns a description of the ways the DuckDB extension 'name', whose files are named 'file_name', can be loaded
+// without network access, including the DuckDB version and platform that the extension must be built for.
+func extensionHelp(ctx context.Context, db extensionDB, name string, file_name string) string {
+
+	var version string
+	var platform string
+
+	err := db.QueryRowContext(ctx, `SELECT version()`).Scan(&version)
+
+	if err != nil {
+		version = "unknown"
+	}
+
+	err = db.QueryRowContext(ctx, `PRAGMA platform`).Scan(&platform)
+
+	if err != nil {
+		platform = "unknown"
+	}
+
+	return fmt.Sprintf("Installing the %s extension requires network access. On machines without network access download the %s extension for DuckDB %s (%s), from http://extensions.duckdb.org/%s/%s/%s.duckdb_extension.gz, decompress it and either pass its path to the -%s-extension flag or copy it to {DIRECTORY}/%s/%s/%s.duckdb_extension and pass {DIRECTORY} to the -extension-directory flag", name, name, version, platform, version, platform, file_name, name, version, platform, file_name)
+}
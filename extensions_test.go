@@ -0,0 +1,118 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+func TestLoadExtension(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	// The parquet extension is built in so it must be loaded without being installed
+
+	err = loadExtension(ctx, db, "parquet")
+
+	if err != nil {
+		t.Fatalf("Failed to load parquet extension, %v", err)
+	}
+}
+
+func TestLoadSpatialExtensionOffline(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	root := t.TempDir()
+
+	ext_opts := &ExtensionOptions{
+		Directory:        root,
+		SpatialExtension: filepath.Join(root, "spatial.duckdb_extension"),
+	}
+
+	err = loadSpatialExtension(ctx, db, ext_opts)
+
+	if err == nil {
+		t.Fatalf("Expected missing spatial extension file to fail")
+	}
+
+	for _, str := range []string{"-spatial-extension", "-extension-directory", "spatial.duckdb_extension"} {
+
+		if !strings.Contains(err.Error(), str) {
+			t.Fatalf("Expected error to describe offline options (%s), got: %v", str, err)
+		}
+	}
+
+	var dir string
+
+	err = db.QueryRowContext(ctx, `SELECT current_setting('extension_directory')`).Scan(&dir)
+
+	if err != nil {
+		t.Fatalf("Failed to read extension directory, %v", err)
+	}
+
+	if dir != root {
+		t.Fatalf("Unexpected extension directory: %s", dir)
+	}
+}
+
+func TestLoadSQLiteExtensionOffline(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	root := t.TempDir()
+
+	ext_opts := &ExtensionOptions{
+		Directory:       root,
+		SQLiteExtension: filepath.Join(root, "sqlite_scanner.duckdb_extension"),
+	}
+
+	err = loadSQLiteExtension(ctx, db, ext_opts)
+
+	if err == nil {
+		t.Fatalf("Expected missing sqlite extension file to fail")
+	}
+
+	for _, str := range []string{"-sqlite-extension", "-extension-directory", "sqlite_scanner.duckdb_extension"} {
+
+		if !strings.Contains(err.Error(), str) {
+			t.Fatalf("Expected error to describe offline options (%s), got: %v", str, err)
+		}
+	}
+
+	// Writing MBTiles databases fails with the same description
+
+	_, err = newMBTilesWriter(ctx, db, filepath.Join(root, "example.mbtiles"), ext_opts)
+
+	if err == nil || !strings.Contains(err.Error(), "-sqlite-extension") {
+		t.Fatalf("Expected MBTiles writer to describe offline options, got: %v", err)
+	}
+}
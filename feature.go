@@ -0,0 +1,276 @@
+package show
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/paulmach/orb/encoding/wkb"
+	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/geojson"
+)
+
+// Valid formats for features retrieved by ID.
+const (
+	// Encode features as GeoJSON Features, with all their properties.
+	FEATURE_FORMAT_GEOJSON string = "geojson"
+	// Encode the geometry of features as Well-Known Text (WKT).
+	FEATURE_FORMAT_WKT string = "wkt"
+	// Encode the geometry of features as Well-Known Binary (WKB).
+	FEATURE_FORMAT_WKB string = "wkb"
+)
+
+// The URL path prefix for retrieving complete features by ID.
+const features_path string = "/features/"
+
+// ErrFeatureNotFound is returned by `GetFeature` when there is no feature with a given ID.
+var ErrFeatureNotFound = errors.New("Feature not found")
+
+// featureLookup defines a layer whose features can be retrieved by ID.
+type featureLookup struct {
+	// The name of the layer.
+	Layer string
+	// The options used to query the layer's features.
+	Options *GetFeaturesForTileFuncOptions
+}
+
+// IsValidFeatureFormat reports whether 'format' is a valid format for features retrieved by ID.
+func IsValidFeatureFormat(format string) bool {
+
+	switch format {
+	case FEATURE_FORMAT_GEOJSON, FEATURE_FORMAT_WKT, FEATURE_FORMAT_WKB:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetFeature returns the feature in the data defined by 'opts' whose 'opts.IdColumn' value is 'id'. Unlike the features in tiles the
+// feature's geometry (in WGS84) is neither clipped nor simplified and all of its columns are assigned as properties, regardless of
+// 'opts.Properties'. Features excluded by 'opts.Where' are not returned. If there is no matching feature `ErrFeatureNotFound` is returned.
+func GetFeature(ctx context.Context, opts *GetFeaturesForTileFuncOptions, id string) (*geojson.Feature, error) {
+
+	if opts.IdColumn == "" {
+		return nil, fmt.Errorf("Data source does not define an ID column")
+	}
+
+	q, cols, err := featureQuery(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := opts.Database.QueryContext(ctx, q, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query database, %w", err)
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+
+		err := rows.Err()
+
+		if err != nil {
+			return nil, fmt.Errorf("There was a problem scanning rows, %w", err)
+		}
+
+		return nil, ErrFeatureNotFound
+	}
+
+	// See the notes about pointer_cols in GetFeaturesForTileFunc
+
+	values := make([]any, len(cols))
+	pointers := make([]any, len(cols))
+
+	for idx, _ := range cols {
+		pointers[idx] = &values[idx]
+	}
+
+	err = rows.Scan(pointers...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to scan row, %w", err)
+	}
+
+	var wkb_geom []byte
+	props := geojson.Properties{}
+
+	for idx, k := range cols {
+
+		switch k {
+		case opts.GeometryColumn:
+			wkb_geom, _ = values[idx].([]byte)
+		default:
+			props[k] = values[idx]
+		}
+	}
+
+	if len(wkb_geom) == 0 {
+		return nil, fmt.Errorf("Feature has an empty geometry")
+	}
+
+	orb_geom, err := wkb.Unmarshal(wkb_geom)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal geometry, %w", err)
+	}
+
+	f := geojson.NewFeature(orb_geom)
+	f.ID = props[opts.IdColumn]
+	f.Properties = props
+
+	return f, nil
+}
+
+// featureQuery returns the SQL query used by `GetFeature` to retrieve a feature, whose ID is passed as the query's only argument, from the
+// data defined by 'opts' and the list of columns it selects. The geometry column is always the last column.
+func featureQuery(opts *GetFeaturesForTileFuncOptions) (string, []string, error) {
+
+	geom, err := geometryExpression(opts)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to derive geometry expression, %w", err)
+	}
+
+	cols := make([]string, 0)
+	select_cols := make([]string, 0)
+
+	for _, c := range opts.TableColumns {
+
+		if c == opts.GeometryColumn {
+			continue
+		}
+
+		cols = append(cols, c)
+		select_cols = append(select_cols, fmt.Sprintf(`"%s"`, c))
+	}
+
+	cols = append(cols, opts.GeometryColumn)
+	select_cols = append(select_cols, fmt.Sprintf(`ST_AsWKB(%s) AS "%s"`, geom, opts.GeometryColumn))
+
+	// IDs are always passed as strings so they are cast to the type of the ID column, rather than casting
+	// the column, which allows DuckDB to use column statistics to skip row groups.
+
+	where := []string{
+		fmt.Sprintf(`CAST("%s" AS VARCHAR) = ?`, opts.IdColumn),
+	}
+
+	if opts.IdColumnType != "" {
+		where[0] = fmt.Sprintf(`"%s" = TRY_CAST(? AS %s)`, opts.IdColumn, opts.IdColumnType)
+	}
+
+	if opts.Where != "" {
+		where = append(where, fmt.Sprintf("(%s)", opts.Where))
+	}
+
+	q := fmt.Sprintf(`SELECT %s FROM %s WHERE %s LIMIT 1`, strings.Join(select_cols, ","), fromClause(opts), strings.Join(where, " AND "))
+	return q, cols, nil
+}
+
+// featuresHandler returns an `http.Handler` for retrieving complete features by ID, using `GetFeature`, from the layers in 'lookups'. URLs
+// take the form of "/features/{ID}" with an optional "layer" query parameter limiting the layer searched (otherwise the first layer with a
+// matching feature is used) and an optional "format" query parameter. Valid formats are: geojson (default), wkt, wkb.
+func featuresHandler(lookups []*featureLookup) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		ctx := req.Context()
+
+		id := strings.TrimPrefix(req.URL.Path, features_path)
+
+		if id == "" {
+			http.Error(rsp, "Missing feature ID", http.StatusBadRequest)
+			return
+		}
+
+		format := req.URL.Query().Get("format")
+
+		if format == "" {
+			format = FEATURE_FORMAT_GEOJSON
+		}
+
+		if !IsValidFeatureFormat(format) {
+			http.Error(rsp, "Invalid format", http.StatusBadRequest)
+			return
+		}
+
+		layer := req.URL.Query().Get("layer")
+
+		candidates := make([]*featureLookup, 0)
+
+		for _, l := range lookups {
+
+			if layer == "" || l.Layer == layer {
+				candidates = append(candidates, l)
+			}
+		}
+
+		if len(candidates) == 0 {
+			http.Error(rsp, "Layer not found", http.StatusNotFound)
+			return
+		}
+
+		logger := slog.Default()
+		logger = logger.With("id", id)
+
+		var f *geojson.Feature
+
+		for _, l := range candidates {
+
+			l_f, err := GetFeature(ctx, l.Options, id)
+
+			if err != nil {
+
+				if errors.Is(err, ErrFeatureNotFound) {
+					continue
+				}
+
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+
+				logger.Error("Failed to retrieve feature", "layer", l.Layer, "error", err)
+				http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+
+			f = l_f
+			break
+		}
+
+		if f == nil {
+			http.Error(rsp, "Feature not found", http.StatusNotFound)
+			return
+		}
+
+		var body []byte
+		var err error
+
+		switch format {
+		case FEATURE_FORMAT_WKT:
+			rsp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			body = wkt.Marshal(f.Geometry)
+		case FEATURE_FORMAT_WKB:
+			rsp.Header().Set("Content-Type", "application/octet-stream")
+			body, err = wkb.Marshal(f.Geometry)
+		default:
+			rsp.Header().Set("Content-Type", "application/geo+json")
+			body, err = f.MarshalJSON()
+		}
+
+		if err != nil {
+			logger.Error("Failed to encode feature", "format", format, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		rsp.Write(body)
+	}
+
+	return http.HandlerFunc(fn)
+}
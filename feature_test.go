@@ -0,0 +1,192 @@
+package show
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeatureQuery(t *testing.T) {
+
+	opts := &GetFeaturesForTileFuncOptions{
+		Datasource:       "example.parquet",
+		GeometryColumn:   "geometry",
+		GeometryEncoding: "WKB",
+		Where:            `"placetype" = 'region'`,
+		TableColumns:     []string{"id", "geometry", "name"},
+		Properties: &PropertyRules{
+			Exclude: []string{"name"},
+		},
+		IdColumn:     "id",
+		IdColumnType: "BIGINT",
+	}
+
+	q, cols, err := featureQuery(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to derive feature query, %v", err)
+	}
+
+	// Properties rules are ignored when retrieving complete features
+
+	if strings.Join(cols, ",") != "id,name,geometry" {
+		t.Fatalf("Unexpected columns: %v", cols)
+	}
+
+	expected := []string{
+		`"id" = TRY_CAST(? AS BIGINT)`,
+		`("placetype" = 'region')`,
+		`ST_AsWKB(`,
+		` LIMIT 1`,
+	}
+
+	for _, str := range expected {
+
+		if !strings.Contains(q, str) {
+			t.Fatalf("Expected query to contain '%s': %s", str, q)
+		}
+	}
+
+	opts.IdColumnType = ""
+
+	q, _, err = featureQuery(opts)
+
+	if err != nil {
+		t.Fatalf("Failed to derive feature query, %v", err)
+	}
+
+	if !strings.Contains(q, `CAST("id" AS VARCHAR) = ?`) {
+		t.Fatalf("Expected query to cast ID column when its type is unknown: %s", q)
+	}
+}
+
+func TestTileColumnsIdColumn(t *testing.T) {
+
+	opts := &GetFeaturesForTileFuncOptions{
+		GeometryColumn: "geometry",
+		TableColumns:   []string{"id", "geometry", "name"},
+		Properties: &PropertyRules{
+			Exclude: []string{"id"},
+		},
+		IdColumn: "id",
+	}
+
+	cols, hidden := tileColumns(opts, 10)
+
+	if strings.Join(cols, ",") != "name,id" {
+		t.Fatalf("Unexpected columns: %v", cols)
+	}
+
+	if hidden["id"] {
+		t.Fatalf("Expected ID column to be assigned as a property")
+	}
+}
+
+func TestFeaturesHandler(t *testing.T) {
+
+	lookups := []*featureLookup{
+		&featureLookup{
+			Layer:   "example",
+			Options: &GetFeaturesForTileFuncOptions{IdColumn: "id"},
+		},
+	}
+
+	handler := featuresHandler(lookups)
+
+	tests := map[string]int{
+		"/features/":                   http.StatusBadRequest,
+		"/features/1234?format=geobuf": http.StatusBadRequest,
+		"/features/1234?layer=unknown": http.StatusNotFound,
+	}
+
+	for uri, expected := range tests {
+
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		if rsp.Code != expected {
+			t.Fatalf("Unexpected status code for %s: %d", uri, rsp.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/features/1234", nil)
+	rsp := httptest.NewRecorder()
+
+	featuresHandler(nil).ServeHTTP(rsp, req)
+
+	if rsp.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status code for handler without layers: %d", rsp.Code)
+	}
+}
+
+func TestGetFeature(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+	opts.IdColumn = "id"
+
+	f, err := GetFeature(ctx, opts, "USA")
+
+	if err != nil {
+		t.Fatalf("Failed to get feature, %v", err)
+	}
+
+	if f.Properties["name"] != "United States of America" {
+		t.Fatalf("Unexpected name: %v", f.Properties["name"])
+	}
+
+	if f.Geometry.GeoJSONType() != "MultiPolygon" {
+		t.Fatalf("Unexpected geometry type: %s", f.Geometry.GeoJSONType())
+	}
+
+	_, err = GetFeature(ctx, opts, "XYZ")
+
+	if !errors.Is(err, ErrFeatureNotFound) {
+		t.Fatalf("Expected feature not found error, got %v", err)
+	}
+
+	// Features excluded by the layer's filter are not returned
+
+	filtered_opts := *opts
+	filtered_opts.Where = `"id" != 'USA'`
+
+	_, err = GetFeature(ctx, &filtered_opts, "USA")
+
+	if !errors.Is(err, ErrFeatureNotFound) {
+		t.Fatalf("Expected filtered feature not found error, got %v", err)
+	}
+
+	lookups := []*featureLookup{
+		&featureLookup{
+			Layer:   "countries",
+			Options: opts,
+		},
+	}
+
+	handler := featuresHandler(lookups)
+
+	tests := map[string]int{
+		"/features/USA":                 http.StatusOK,
+		"/features/USA?format=wkt":      http.StatusOK,
+		"/features/USA?layer=countries": http.StatusOK,
+		"/features/XYZ":                 http.StatusNotFound,
+	}
+
+	for uri, expected := range tests {
+
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		if rsp.Code != expected {
+			t.Fatalf("Unexpected status code for %s: %d", uri, rsp.Code)
+		}
+	}
+}
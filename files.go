@@ -0,0 +1,456 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/paulmach/orb"
+)
+
+// SourceFile defines an individual file in a data source, the bounding box of the geometries it contains and its row groups.
+type SourceFile struct {
+	// The path (or URI) of the file as reported by DuckDB.
+	Path string `json:"path"`
+	// The bounding box of the geometries in the file, in the data source's CRS. If nil the bounding box is unknown and
+	// the file is never pruned.
+	Bound *orb.Bound `json:"bound,omitempty"`
+	// The row groups in the file, in the order they are stored.
+	RowGroups []*RowGroup `json:"row_groups,omitempty"`
+}
+
+// RowGroup defines an individual row group in a Parquet file and the bounding box of the geometries it contains.
+type RowGroup struct {
+	// The (zero-based) index of the row group in its file.
+	Id int64 `json:"id"`
+	// The number of rows in the file preceding the row group, which is the `file_row_number` of its first row.
+	Offset int64 `json:"offset"`
+	// The number of rows in the row group.
+	Rows int64 `json:"rows"`
+	// The bounding box of the geometries in the row group, in the data source's CRS, derived from column statistics. If nil
+	// the bounding box is unknown and the row group is never pruned.
+	Bound *orb.Bound `json:"bound,omitempty"`
+}
+
+// DeriveSourceFiles returns the list of files matching 'datasource', the bounding box of the geometries in each file and the index of
+// their row groups. Row group bounding boxes are derived from the statistics for the columns defined by 'bbox_cols', as reported by the
+// DuckDB `parquet_metadata` function. File bounding boxes are read from the "bbox" property of the GeoParquet metadata for 'geom_col' or,
+// if absent, derived from their row groups. Files with no rows are not included.
+func DeriveSourceFiles(ctx context.Context, db *sql.DB, datasource string, geom_col string, bbox_cols *BboxColumns) ([]*SourceFile, error) {
+
+	files := make([]*SourceFile, 0)
+	lookup := make(map[string]*SourceFile)
+
+	// START OF bounding boxes from column statistics
+
+	has_stats := bbox_cols != nil && bbox_cols.MinX != "" && bbox_cols.MinY != "" && bbox_cols.MaxX != "" && bbox_cols.MaxY != ""
+
+	var q string
+	args := make([]any, 0)
+
+	if has_stats {
+
+		// A row group's bounding box is only known if it has statistics for all of the bounding box columns.
+
+		q = fmt.Sprintf(`SELECT file_name, row_group_id, ANY_VALUE(row_group_num_rows),
+			MIN(TRY_CAST(stats_min_value AS DOUBLE)) FILTER (WHERE path_in_schema = ?),
+			MIN(TRY_CAST(stats_min_value AS DOUBLE)) FILTER (WHERE path_in_schema = ?),
+			MAX(TRY_CAST(stats_max_value AS DOUBLE)) FILTER (WHERE path_in_schema = ?),
+			MAX(TRY_CAST(stats_max_value AS DOUBLE)) FILTER (WHERE path_in_schema = ?),
+			COUNT(*) FILTER (WHERE path_in_schema IN (?, ?, ?, ?) AND (TRY_CAST(stats_min_value AS DOUBLE) IS NULL OR TRY_CAST(stats_max_value AS DOUBLE) IS NULL))
+			FROM parquet_metadata(%s) GROUP BY file_name, row_group_id ORDER BY file_name, row_group_id`, parquetFilesArgument(datasource))
+
+		paths := []string{
+			statsColumnPath(bbox_cols.MinX),
+			statsColumnPath(bbox_cols.MinY),
+			statsColumnPath(bbox_cols.MaxX),
+			statsColumnPath(bbox_cols.MaxY),
+		}
+
+		for _, p := range append(paths, paths...) {
+			args = append(args, p)
+		}
+
+	} else {
+		q = fmt.Sprintf(`SELECT file_name, row_group_id, ANY_VALUE(row_group_num_rows) FROM parquet_metadata(%s) GROUP BY file_name, row_group_id ORDER BY file_name, row_group_id`, parquetFilesArgument(datasource))
+	}
+
+	rows, err := db.QueryContext(ctx, q, args...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query Parquet metadata, %w", err)
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var path string
+		rg := &RowGroup{}
+
+		if !has_stats {
+
+			err := rows.Scan(&path, &rg.Id, &rg.Rows)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to scan row, %w", err)
+			}
+
+		} else {
+
+			var minx, miny, maxx, maxy sql.NullFloat64
+			var missing int64
+
+			err := rows.Scan(&path, &rg.Id, &rg.Rows, &minx, &miny, &maxx, &maxy, &missing)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to scan row, %w", err)
+			}
+
+			if missing == 0 && minx.Valid && miny.Valid && maxx.Valid && maxy.Valid {
+
+				rg.Bound = &orb.Bound{
+					Min: orb.Point{minx.Float64, miny.Float64},
+					Max: orb.Point{maxx.Float64, maxy.Float64},
+				}
+			}
+		}
+
+		f, exists := lookup[path]
+
+		if !exists {
+			f = &SourceFile{
+				Path:      path,
+				RowGroups: make([]*RowGroup, 0),
+			}
+
+			files = append(files, f)
+			lookup[path] = f
+		}
+
+		if len(f.RowGroups) > 0 {
+			last := f.RowGroups[len(f.RowGroups)-1]
+			rg.Offset = last.Offset + last.Rows
+		}
+
+		f.RowGroups = append(f.RowGroups, rg)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("There was a problem scanning rows, %w", err)
+	}
+
+	// A file's bounding box is only known if every row group has a bounding box
+
+	for _, f := range files {
+
+		var bound *orb.Bound
+
+		for _, rg := range f.RowGroups {
+
+			if rg.Bound == nil {
+				bound = nil
+				break
+			}
+
+			if bound == nil {
+				b := *rg.Bound
+				bound = &b
+			} else {
+				b := bound.Union(*rg.Bound)
+				bound = &b
+			}
+		}
+
+		f.Bound = bound
+	}
+
+	// END OF bounding boxes from column statistics
+
+	// START OF bounding boxes from GeoParquet metadata
+
+	md_q := fmt.Sprintf(`SELECT file_name, decode(value) FROM parquet_kv_metadata(%s) WHERE decode(key) = 'geo'`, parquetFilesArgument(datasource))
+
+	md_rows, err := db.QueryContext(ctx, md_q)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query GeoParquet metadata, %w", err)
+	}
+
+	defer md_rows.Close()
+
+	for md_rows.Next() {
+
+		var path string
+		var str_md string
+
+		err := md_rows.Scan(&path, &str_md)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan row, %w", err)
+		}
+
+		f, exists := lookup[path]
+
+		if !exists {
+			continue
+		}
+
+		var md *GeoMetadata
+
+		err = json.Unmarshal([]byte(str_md), &md)
+
+		if err != nil {
+			slog.Warn("Failed to unmarshal GeoParquet metadata", "path", path, "error", err)
+			continue
+		}
+
+		col_md, exists := md.Columns[geom_col]
+
+		if !exists {
+			continue
+		}
+
+		// The bbox may also include Z values, in which case it is expressed as [minx, miny, minz, maxx, maxy, maxz]
+
+		switch len(col_md.Bbox) {
+		case 4:
+			f.Bound = &orb.Bound{
+				Min: orb.Point{col_md.Bbox[0], col_md.Bbox[1]},
+				Max: orb.Point{col_md.Bbox[2], col_md.Bbox[3]},
+			}
+		case 6:
+			f.Bound = &orb.Bound{
+				Min: orb.Point{col_md.Bbox[0], col_md.Bbox[1]},
+				Max: orb.Point{col_md.Bbox[3], col_md.Bbox[4]},
+			}
+		}
+	}
+
+	err = md_rows.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("There was a problem scanning rows, %w", err)
+	}
+
+	// END OF bounding boxes from GeoParquet metadata
+
+	return files, nil
+}
+
+// prunedRowGroups records the number of row groups in a data source which are skipped when querying the features in a tile.
+type prunedRowGroups struct {
+	// The total number of row groups in the data source.
+	Total int
+	// The number of row groups which are not read.
+	Pruned int
+}
+
+// rowRange is an inclusive range of row numbers in a Parquet file.
+type rowRange [2]int64
+
+// filesPredicate returns a SQL boolean expression, and its arguments, limiting the files and row groups read from a data source
+// to those in 'files' whose bounding box intersects 'bound', and the number of row groups which are skipped as a result.
+//
+// If 'read_opts.Filename' is true files are excluded by filtering on the "filename" column added by the DuckDB `read_parquet`
+// function. If 'read_opts.FileRowNumber' is true rows are limited to the row groups which intersect 'bound', in each file, by
+// filtering on the "file_row_number" column.
+//
+// DuckDB only skips files, and row groups, when filters on those columns can be pushed down to the Parquet reader which is only the
+// case for a single list of file names and a single range of row numbers combined with AND. Filters combined with OR are evaluated
+// for every row read. The expression therefore consists of a list of the files to read and the range of row numbers spanning every
+// row group, in all of those files, which intersects 'bound', which are used to skip files and row groups, and a list of (file, row
+// range) pairs for each run of consecutive row groups which intersect 'bound', which excludes the rows in any other row groups within
+// that range but does not prevent them from being read. Only the files and row groups which are skipped are counted as pruned. If
+// nothing is excluded an empty expression is returned.
+func filesPredicate(files []*SourceFile, bound orb.Bound, read_opts *ParquetReadOptions) (string, []any, *prunedRowGroups) {
+
+	pruned := &prunedRowGroups{}
+
+	matches := make([]*SourceFile, 0)
+
+	// The (inclusive) ranges of row numbers, keyed by file path, of each run of consecutive row groups which intersect 'bound'
+	ranges := make(map[string][]rowRange)
+
+	for _, f := range files {
+
+		pruned.Total += len(f.RowGroups)
+
+		if f.Bound != nil && !f.Bound.Intersects(bound) {
+			continue
+		}
+
+		f_ranges := make([]rowRange, 0)
+
+		for _, rg := range f.RowGroups {
+
+			if rg.Bound != nil && !rg.Bound.Intersects(bound) {
+				continue
+			}
+
+			if rg.Rows == 0 {
+				continue
+			}
+
+			first := rg.Offset
+			last := rg.Offset + rg.Rows - 1
+
+			if len(f_ranges) > 0 && f_ranges[len(f_ranges)-1][1]+1 == first {
+				f_ranges[len(f_ranges)-1][1] = last
+				continue
+			}
+
+			f_ranges = append(f_ranges, rowRange{first, last})
+		}
+
+		if len(f.RowGroups) > 0 && len(f_ranges) == 0 {
+			continue
+		}
+
+		matches = append(matches, f)
+		ranges[f.Path] = f_ranges
+	}
+
+	if len(matches) == 0 {
+		pruned.Pruned = pruned.Total
+		return "false", nil, pruned
+	}
+
+	where := make([]string, 0)
+	args := make([]any, 0)
+
+	read := files
+	filter_files := len(matches) < len(files) && read_opts != nil && read_opts.Filename
+
+	if filter_files {
+
+		placeholders := make([]string, len(matches))
+
+		for idx, f := range matches {
+			placeholders[idx] = "?"
+			args = append(args, f.Path)
+		}
+
+		where = append(where, fmt.Sprintf(`"%s" IN (%s)`, filename_column, strings.Join(placeholders, ",")))
+		read = matches
+	}
+
+	scanned := 0
+
+	for _, f := range read {
+		scanned += len(f.RowGroups)
+	}
+
+	if read_opts == nil || !read_opts.FileRowNumber {
+		pruned.Pruned = pruned.Total - scanned
+		return strings.Join(where, " AND "), args, pruned
+	}
+
+	// START OF row number constraints
+
+	// The range of row numbers spanning every row group which intersects 'bound' in all the files being
+	// read, and the last row number in any of those files. Row numbers are only constrained if the row
+	// groups of every file being read are known.
+
+	envelope := rowRange{-1, -1}
+	var last_row int64 = -1
+
+	known := true
+
+	for _, f := range read {
+
+		if len(f.RowGroups) == 0 {
+			known = false
+			break
+		}
+
+		last_rg := f.RowGroups[len(f.RowGroups)-1]
+		last_row = max(last_row, last_rg.Offset+last_rg.Rows-1)
+
+		for _, r := range ranges[f.Path] {
+
+			if envelope[0] == -1 || r[0] < envelope[0] {
+				envelope[0] = r[0]
+			}
+
+			envelope[1] = max(envelope[1], r[1])
+		}
+	}
+
+	if known && envelope[0] != -1 && (envelope[0] > 0 || envelope[1] < last_row) {
+
+		// Row numbers are integers derived from the file metadata so they are inlined, rather than passed as
+		// query arguments, to ensure that DuckDB is able to compare them with row group offsets when planning.
+
+		where = append(where, fmt.Sprintf(`"%s" BETWEEN %d AND %d`, file_row_number_column, envelope[0], envelope[1]))
+
+		scanned = 0
+
+		for _, f := range read {
+
+			for _, rg := range f.RowGroups {
+
+				if rg.Offset <= envelope[1] && rg.Offset+rg.Rows-1 >= envelope[0] {
+					scanned += 1
+				}
+			}
+		}
+	}
+
+	// The (file, row range) pairs are only added if they exclude rows which the constraints above do not. They
+	// are only specific to each file if files are being filtered by name or a single file is being read.
+
+	if known && envelope[0] != -1 && (len(read) == 1 || read_opts.Filename) {
+
+		var envelope_rows int64
+		var range_rows int64
+
+		for _, f := range read {
+
+			last_rg := f.RowGroups[len(f.RowGroups)-1]
+			envelope_rows += max(0, min(envelope[1], last_rg.Offset+last_rg.Rows-1)-envelope[0]+1)
+
+			for _, r := range ranges[f.Path] {
+				range_rows += r[1] - r[0] + 1
+			}
+		}
+
+		if range_rows < envelope_rows {
+
+			or_where := make([]string, 0)
+
+			for _, f := range read {
+
+				for _, r := range ranges[f.Path] {
+
+					if len(read) == 1 {
+						or_where = append(or_where, fmt.Sprintf(`"%s" BETWEEN %d AND %d`, file_row_number_column, r[0], r[1]))
+						continue
+					}
+
+					or_where = append(or_where, fmt.Sprintf(`("%s" = ? AND "%s" BETWEEN %d AND %d)`, filename_column, file_row_number_column, r[0], r[1]))
+					args = append(args, f.Path)
+				}
+			}
+
+			where = append(where, fmt.Sprintf("(%s)", strings.Join(or_where, " OR ")))
+		}
+	}
+
+	// END OF row number constraints
+
+	pruned.Pruned = pruned.Total - scanned
+
+	return strings.Join(where, " AND "), args, pruned
+}
+
+// statsColumnPath returns the path used to identify the column 'name', which may reference a struct field using dot notation
+// (for example "bbox.xmin"), in the results of the DuckDB `parquet_metadata` function.
+func statsColumnPath(name string) string {
+	return strings.Join(strings.Split(name, "."), ", ")
+}
@@ -0,0 +1,433 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestDeriveSourceFiles(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	root := t.TempDir()
+
+	for _, region := range []string{"east", "west", "north"} {
+
+		err := os.MkdirAll(filepath.Join(root, "region="+region), 0755)
+
+		if err != nil {
+			t.Fatalf("Failed to create directory, %v", err)
+		}
+	}
+
+	east := filepath.Join(root, "region=east", "part-0.parquet")
+	west := filepath.Join(root, "region=west", "part-0.parquet")
+	north := filepath.Join(root, "region=north", "part-0.parquet")
+
+	// The "east" file has a bbox in its GeoParquet metadata, the "west" file only has bbox column statistics (two
+	// row groups) and the "north" file has a column the other files do not.
+
+	setup := []string{
+		fmt.Sprintf(`COPY (SELECT 1 AS id, {'xmin': 10.0, 'ymin': 10.0, 'xmax': 20.0, 'ymax': 20.0} AS bbox) TO '%s' (FORMAT PARQUET, KV_METADATA {geo: '{"version":"1.1.0","primary_column":"geometry","columns":{"geometry":{"encoding":"WKB","bbox":[10,10,20,20]}}}'})`, east),
+		fmt.Sprintf(`COPY (SELECT 2 AS id, {'xmin': -20.0, 'ymin': 10.0, 'xmax': -15.0, 'ymax': 15.0} AS bbox UNION ALL SELECT 3, {'xmin': -12.0, 'ymin': 12.0, 'xmax': -10.0, 'ymax': 20.0}) TO '%s' (FORMAT PARQUET, ROW_GROUP_SIZE 1)`, west),
+		fmt.Sprintf(`COPY (SELECT 4 AS id, 'extra' AS extra, {'xmin': 0.0, 'ymin': 50.0, 'xmax': 5.0, 'ymax': 55.0} AS bbox) TO '%s' (FORMAT PARQUET)`, north),
+	}
+
+	for _, q := range setup {
+
+		_, err := db.ExecContext(ctx, q)
+
+		if err != nil {
+			t.Fatalf("Failed to write test data (%s), %v", q, err)
+		}
+	}
+
+	datasource := filepath.Join(root, "region=*", "part-*.parquet")
+
+	bbox_cols := &BboxColumns{
+		MinX: "bbox.xmin",
+		MinY: "bbox.ymin",
+		MaxX: "bbox.xmax",
+		MaxY: "bbox.ymax",
+	}
+
+	files, err := DeriveSourceFiles(ctx, db, datasource, "geometry", bbox_cols)
+
+	if err != nil {
+		t.Fatalf("Failed to derive source files, %v", err)
+	}
+
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(files))
+	}
+
+	expected := map[string]orb.Bound{
+		east:  {Min: orb.Point{10, 10}, Max: orb.Point{20, 20}},
+		west:  {Min: orb.Point{-20, 10}, Max: orb.Point{-10, 20}},
+		north: {Min: orb.Point{0, 50}, Max: orb.Point{5, 55}},
+	}
+
+	for _, f := range files {
+
+		if f.Bound == nil {
+			t.Fatalf("Missing bound for %s", f.Path)
+		}
+
+		if !f.Bound.Equal(expected[f.Path]) {
+			t.Fatalf("Unexpected bound for %s: %v", f.Path, f.Bound)
+		}
+	}
+
+	// Without bbox columns only the "east" file has a bound
+
+	files, err = DeriveSourceFiles(ctx, db, datasource, "geometry", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to derive source files without bbox columns, %v", err)
+	}
+
+	for _, f := range files {
+
+		if (f.Path == east) != (f.Bound != nil) {
+			t.Fatalf("Unexpected bound for %s without bbox columns: %v", f.Path, f.Bound)
+		}
+	}
+
+	// Now corrupt the "north" file, after its bounding box has been derived, to ensure that files which
+	// do not intersect a tile are never read.
+
+	err = os.WriteFile(north, []byte("not a parquet file"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to overwrite file, %v", err)
+	}
+
+	files, err = DeriveSourceFiles(ctx, db, fmt.Sprintf("%s,%s", east, west), "geometry", bbox_cols)
+
+	if err != nil {
+		t.Fatalf("Failed to derive source files for list, %v", err)
+	}
+
+	files = append(files, &SourceFile{Path: north, Bound: &orb.Bound{Min: orb.Point{0, 50}, Max: orb.Point{5, 55}}})
+
+	// Note that 'UnionByName' is not set since that requires reading the schema of every file.
+
+	read_opts := &ParquetReadOptions{
+		HivePartitioning: true,
+		Filename:         true,
+	}
+
+	tests := map[string]struct {
+		Bound orb.Bound
+		Count int
+	}{
+		"east":    {Bound: orb.Bound{Min: orb.Point{12, 12}, Max: orb.Point{14, 14}}, Count: 1},
+		"both":    {Bound: orb.Bound{Min: orb.Point{-30, 0}, Max: orb.Point{30, 30}}, Count: 3},
+		"nothing": {Bound: orb.Bound{Min: orb.Point{100, 0}, Max: orb.Point{110, 10}}, Count: 0},
+	}
+
+	for label, test := range tests {
+
+		where, args, _ := filesPredicate(files, test.Bound, read_opts)
+
+		if where == "" {
+			t.Fatalf("Expected files predicate for %s", label)
+		}
+
+		q := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s`, readParquetClause(datasource, read_opts), where)
+
+		var count int
+
+		err := db.QueryRowContext(ctx, q, args...).Scan(&count)
+
+		if err != nil {
+			t.Fatalf("Failed to query pruned files for %s (%s), %v", label, q, err)
+		}
+
+		if count != test.Count {
+			t.Fatalf("Expected %d rows for %s, got %d", test.Count, label, count)
+		}
+	}
+
+	where, _, _ := filesPredicate(files, orb.Bound{Min: orb.Point{-180, -90}, Max: orb.Point{180, 90}}, read_opts)
+
+	if where != "" {
+		t.Fatalf("Expected empty files predicate when all files intersect, got %s", where)
+	}
+}
+
+func TestPruneRowGroups(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	root := t.TempDir()
+
+	path := filepath.Join(root, "row-groups.parquet")
+	other_path := filepath.Join(root, "other.parquet")
+
+	// Rows are written to five row groups (of 2048 rows, the smallest size DuckDB supports), in order of increasing longitude,
+	// alternating between latitudes 0 and 10. The rows in the "other" file are a long way east of the rows in the first file.
+
+	setup := []string{
+		fmt.Sprintf(`COPY (SELECT i AS id, {'xmin': (i // 2048) * 50.0, 'ymin': ((i // 2048) %% 2) * 10.0, 'xmax': (i // 2048) * 50.0 + 1, 'ymax': ((i // 2048) %% 2) * 10.0 + 1} AS bbox FROM range(5 * 2048) t(i) ORDER BY i) TO '%s' (FORMAT PARQUET, ROW_GROUP_SIZE 2048)`, path),
+		fmt.Sprintf(`COPY (SELECT i AS id, {'xmin': 1000.0, 'ymin': 0.0, 'xmax': 1001.0, 'ymax': 1.0} AS bbox FROM range(2048) t(i)) TO '%s' (FORMAT PARQUET, ROW_GROUP_SIZE 2048)`, other_path),
+	}
+
+	for _, q := range setup {
+
+		_, err := db.ExecContext(ctx, q)
+
+		if err != nil {
+			t.Fatalf("Failed to write test data (%s), %v", q, err)
+		}
+	}
+
+	bbox_cols := &BboxColumns{
+		MinX: "bbox.xmin",
+		MinY: "bbox.ymin",
+		MaxX: "bbox.xmax",
+		MaxY: "bbox.ymax",
+	}
+
+	files, err := DeriveSourceFiles(ctx, db, path, "geometry", bbox_cols)
+
+	if err != nil {
+		t.Fatalf("Failed to derive source files, %v", err)
+	}
+
+	if len(files) != 1 || len(files[0].RowGroups) != 5 {
+		t.Fatalf("Expected 1 file with 5 row groups, got %d files", len(files))
+	}
+
+	for idx, rg := range files[0].RowGroups {
+
+		if rg.Offset != int64(idx)*2048 || rg.Rows != 2048 {
+			t.Fatalf("Unexpected offset (%d) or rows (%d) for row group %d", rg.Offset, rg.Rows, idx)
+		}
+
+		y := float64(idx%2) * 10
+		expected := orb.Bound{Min: orb.Point{float64(idx) * 50, y}, Max: orb.Point{float64(idx)*50 + 1, y + 1}}
+
+		if rg.Bound == nil || !rg.Bound.Equal(expected) {
+			t.Fatalf("Unexpected bound for row group %d: %v", idx, rg.Bound)
+		}
+	}
+
+	other_files, err := DeriveSourceFiles(ctx, db, other_path, "geometry", bbox_cols)
+
+	if err != nil {
+		t.Fatalf("Failed to derive source files, %v", err)
+	}
+
+	// Now corrupt the pages of the first and last row groups, after their bounding boxes have been derived, and
+	// the entire "other" file, to ensure that the files and row groups counted as pruned are never read.
+
+	pages_q := fmt.Sprintf(`SELECT COALESCE(LEAST(dictionary_page_offset, data_page_offset), data_page_offset), total_compressed_size FROM parquet_metadata('%s') WHERE row_group_id IN (0, 4)`, path)
+
+	rows, err := db.QueryContext(ctx, pages_q)
+
+	if err != nil {
+		t.Fatalf("Failed to query page offsets, %v", err)
+	}
+
+	defer rows.Close()
+
+	fh, err := os.OpenFile(path, os.O_RDWR, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to open %s, %v", path, err)
+	}
+
+	defer fh.Close()
+
+	for rows.Next() {
+
+		var offset int64
+		var size int64
+
+		err := rows.Scan(&offset, &size)
+
+		if err != nil {
+			t.Fatalf("Failed to scan page offsets, %v", err)
+		}
+
+		_, err = fh.WriteAt(make([]byte, size), offset)
+
+		if err != nil {
+			t.Fatalf("Failed to corrupt row group, %v", err)
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		t.Fatalf("Failed to read page offsets, %v", err)
+	}
+
+	err = os.WriteFile(other_path, []byte("not a parquet file"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to overwrite file, %v", err)
+	}
+
+	tests := map[string]struct {
+		Bound  orb.Bound
+		Count  int
+		Pruned int
+	}{
+		"second": {Bound: orb.Bound{Min: orb.Point{45, 0}, Max: orb.Point{55, 11}}, Count: 2048, Pruned: 4},
+		"middle": {Bound: orb.Bound{Min: orb.Point{45, 0}, Max: orb.Point{105, 11}}, Count: 4096, Pruned: 3},
+		// The third row group is within the range of rows which are read, and so is not pruned, but its rows are excluded
+		"gap":     {Bound: orb.Bound{Min: orb.Point{45, 9}, Max: orb.Point{155, 12}}, Count: 4096, Pruned: 2},
+		"nothing": {Bound: orb.Bound{Min: orb.Point{10, 20}, Max: orb.Point{20, 30}}, Count: 0, Pruned: 5},
+	}
+
+	// Test a single file, whose row groups are pruned by their row numbers, and a list of files which are also pruned by name
+
+	sources := []struct {
+		Datasource string
+		Files      []*SourceFile
+		ReadOpts   *ParquetReadOptions
+	}{
+		{path, files, &ParquetReadOptions{FileRowNumber: true}},
+		{path + "," + other_path, append(slices.Clone(files), other_files...), &ParquetReadOptions{FileRowNumber: true, Filename: true}},
+	}
+
+	for _, src := range sources {
+
+		total := 0
+
+		for _, f := range src.Files {
+			total += len(f.RowGroups)
+		}
+
+		for label, test := range tests {
+
+			where, args, pruned := filesPredicate(src.Files, test.Bound, src.ReadOpts)
+
+			expected_pruned := test.Pruned + (total - 5)
+
+			if test.Pruned == 5 {
+				expected_pruned = total
+			}
+
+			if pruned.Total != total || pruned.Pruned != expected_pruned {
+				t.Fatalf("Expected %d of %d row groups to be pruned for %s (%s), got %d of %d", expected_pruned, total, label, src.Datasource, pruned.Pruned, pruned.Total)
+			}
+
+			q := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s`, readParquetClause(src.Datasource, src.ReadOpts), where)
+
+			var count int
+
+			err := db.QueryRowContext(ctx, q, args...).Scan(&count)
+
+			if err != nil {
+				t.Fatalf("Failed to query pruned row groups for %s (%s), %v", label, q, err)
+			}
+
+			if count != test.Count {
+				t.Fatalf("Expected %d rows for %s (%s), got %d", test.Count, label, src.Datasource, count)
+			}
+		}
+	}
+
+	where, _, pruned := filesPredicate(files, orb.Bound{Min: orb.Point{-180, -90}, Max: orb.Point{300, 90}}, &ParquetReadOptions{FileRowNumber: true})
+
+	if where != "" || pruned.Pruned != 0 {
+		t.Fatalf("Expected empty predicate when all row groups intersect, got '%s' (%d pruned)", where, pruned.Pruned)
+	}
+}
+
+func TestSetupLayerPruneFiles(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+
+	// The countries fixture split in to files of countries west of the prime meridian, east of it and those which span it, each
+	// with a bbox column from whose statistics the bounds of each file (which has a single row group) are derived
+
+	bbox := `{'xmin': ST_XMin(geom), 'ymin': ST_YMin(geom), 'xmax': ST_XMax(geom), 'ymax': ST_YMax(geom)} AS bbox`
+
+	paths := []string{
+		countriesDerivedFixture(t, opts.Database, "west.parquet", fmt.Sprintf(`SELECT id, name, geometry, %s FROM countries WHERE ST_XMax(geom) < 0`, bbox), ""),
+		countriesDerivedFixture(t, opts.Database, "east.parquet", fmt.Sprintf(`SELECT id, name, geometry, %s FROM countries WHERE ST_XMin(geom) >= 0`, bbox), ""),
+		countriesDerivedFixture(t, opts.Database, "both.parquet", fmt.Sprintf(`SELECT id, name, geometry, %s FROM countries WHERE ST_XMin(geom) < 0 AND ST_XMax(geom) >= 0`, bbox), ""),
+	}
+
+	run_opts := &RunOptions{
+		Database: opts.Database,
+	}
+
+	layer := &Layer{
+		Name:       "countries",
+		Datasource: strings.Join(paths, ","),
+	}
+
+	features_opts, _, err := setupLayer(ctx, run_opts, 1, 0, layer)
+
+	if err != nil {
+		t.Fatalf("Failed to set up layer, %v", err)
+	}
+
+	if len(features_opts.Files) != 3 || !features_opts.ReadOptions.Filename {
+		t.Fatalf("Expected files to be pruned, got %d files", len(features_opts.Files))
+	}
+
+	cb := GetFeaturesForTileFunc(features_opts)
+
+	// Tiles in the western (the south-western United States and Mexico) and eastern (China) hemispheres
+
+	tests := [][3]uint32{
+		{3, 1, 3},
+		{3, 6, 3},
+	}
+
+	for _, z_x_y := range tests {
+
+		tile := maptile.New(z_x_y[1], z_x_y[2], maptile.Zoom(z_x_y[0]))
+
+		_, _, pruned, err := tileConstraints(ctx, features_opts, tile.Bound(), int(tile.Z))
+
+		if err != nil {
+			t.Fatalf("Failed to derive tile constraints, %v", err)
+		}
+
+		if pruned == nil || pruned.Total != 3 || pruned.Pruned != 1 {
+			t.Fatalf("Expected 1 of 3 row groups to be pruned for tile %d/%d/%d, got %v", tile.Z, tile.X, tile.Y, pruned)
+		}
+
+		expected := tileFeatureIds(t, GetFeaturesForTileFunc(opts), z_x_y[0], z_x_y[1], z_x_y[2])
+		ids := tileFeatureIds(t, cb, z_x_y[0], z_x_y[1], z_x_y[2])
+
+		if len(expected) == 0 || !slices.Equal(ids, expected) {
+			t.Fatalf("Unexpected features for tile %d/%d/%d: %v, expected %v", tile.Z, tile.X, tile.Y, ids, expected)
+		}
+	}
+}
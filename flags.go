@@ -12,7 +12,30 @@ import (
 )
 
 var data_source string
+var query string
+var layer_uris multi.MultiString
+var layer_by string
+
+var where string
+var layer_where multi.MultiString
+var layer_min_zoom multi.MultiString
+var layer_max_zoom multi.MultiString
+var minzoom_column string
+var cluster_max_zoom int
+var cluster_grid_size int
+var cluster_aggregate multi.MultiString
+var bin_shape string
+var bin_size int
+var bin_aggregate multi.MultiString
+var max_features_per_tile int
+var order_by string
 var db_engine string
+var hive_partitioning bool
+var union_by_name bool
+var filename_property bool
+var geometry_column string
+var source_crs string
+var geometry_collection_strategy string
 var port int
 
 var browser_uri string
@@ -21,40 +44,184 @@ var renderer string
 
 var label_properties multi.MultiString
 
+var include_property multi.MultiString
+var exclude_property multi.MultiString
+var zoom_property multi.MultiString
+var drop_null_properties bool
+var max_string_length int
+
+var min_x_column string
+var min_y_column string
 var max_x_column string
 var max_y_column string
 
+var tile_cache_size int
+var tile_cache_directory string
+var tile_cache_directory_size int
+
+var seed_zoom_range string
+var seed_bbox string
+var seed_workers int
+
+var materialize bool
+var materialize_cache string
+
+var id_column string
+
+var config_file string
+var reload bool
+
+var extension_directory string
+var spatial_extension_path string
+var sqlite_extension_path string
+
 var verbose bool
 
+var export_output string
+var export_format string
+var export_name string
+var export_min_zoom int
+var export_max_zoom int
+var export_workers int
+
 func DefaultFlagSet() *flag.FlagSet {
 
 	fs := flagset.NewFlagSet("show")
 
+	appendLayerFlags(fs)
+
 	browser_schemes := show.BrowserSchemes()
 	str_schemes := strings.Join(browser_schemes, ",")
 
 	browser_desc := fmt.Sprintf("A valid sfomuseum/go-www-show/v2.Browser URI. Valid options are: %s", str_schemes)
 
 	fs.StringVar(&browser_uri, "browser-uri", "web://", browser_desc)
-
 	fs.IntVar(&port, "port", 0, "The port number to listen for requests on (on localhost). If 0 then a random port number will be chosen.")
-	fs.StringVar(&data_source, "data-source", "", "The URI of the GeoParquet data. Specifically, the value passed to the DuckDB read_parquet() function.")
-	fs.StringVar(&db_engine, "database-engine", "duckdb", "The database/sql engine (driver) to use.")
 
 	fs.StringVar(&renderer, "renderer", "leaflet", "Which rendering library to use to draw vector tiles. Valid options are: leaflet, maplibre.")
 	fs.Var(&label_properties, "label", "Zero or more (GeoJSON Feature) properties to use to construct a label for a feature's popup menu when it is clicked on.")
 
-	fs.StringVar(&max_x_column, "max-x-column", "", "An option column name to use for a initial bounding box constraint. This columns is expected to contain the maximum X (longitude) value of the geometry it is associated with. This will only work if the -max-y-column flag is also set.")
-	fs.StringVar(&max_y_column, "max-y-column", "", "An option column name to use for a initial bounding box constraint. This columns is expected to contain the maximum Y (latitude) value of the geometry it is associated with. This will only work if the -max-x-column flag is also set.")
+	fs.BoolVar(&reload, "reload", false, "Watch local data source files and, when they change, rebuild the layers, clear any cached tiles and refresh the tiles displayed in the browser without changing the current view. Layers are also rebuilt, and the -config file re-read, when the process receives a SIGHUP signal.")
 
-	fs.BoolVar(&verbose, "verbose", false, "Enable vebose (debug) logging.")
+	appendTileCacheFlags(fs)
+	appendSeedFlags(fs)
 
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Command-line tool for serving GeoParquet features as vector tiles from an on-demand web server.\n")
-		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s [options]\n\t %s export [options]\n\t %s seed [options]\n", os.Args[0], os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// DefaultExportFlagSet returns a new `flag.FlagSet` instance with flags for exporting GeoParquet data as vector tiles.
+func DefaultExportFlagSet() *flag.FlagSet {
+
+	fs := flagset.NewFlagSet("export")
+
+	appendLayerFlags(fs)
+
+	fs.StringVar(&export_output, "output", "", "The path to write tiles to. Required.")
+	fs.StringVar(&export_format, "format", "", "The format to write tiles in. Valid options are: pmtiles, mbtiles, directory. If empty the format is derived from the extension of the -output flag (.pmtiles or .mbtiles), otherwise tiles are written to a directory tree of {z}/{x}/{y}.mvt files.")
+	fs.StringVar(&export_name, "name", "", "The name of the tileset recorded in the metadata for exported tiles. If empty the name is derived from the -output flag.")
+	fs.IntVar(&export_min_zoom, "min-zoom", 0, "The minimum zoom level to export tiles for.")
+	fs.IntVar(&export_max_zoom, "max-zoom", default_export_max_zoom, "The maximum zoom level to export tiles for.")
+	fs.IntVar(&export_workers, "workers", 0, "The number of tiles to produce in parallel. If 0 the number of CPUs is used.")
+	fs.StringVar(&sqlite_extension_path, "sqlite-extension", "", "An optional path to a DuckDB sqlite extension (\"sqlite_scanner.duckdb_extension\") file to load, when writing MBTiles databases, instead of installing the extension, which requires network access. The file must match the version and platform of the DuckDB library this tool was built with.")
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command-line tool for exporting GeoParquet features as vector tiles to a PMTiles archive, an MBTiles database or a directory.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s export [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Valid options are:\n")
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// DefaultSeedFlagSet returns a new `flag.FlagSet` instance with flags for filling the (on-disk) tile cache ahead of time.
+func DefaultSeedFlagSet() *flag.FlagSet {
+
+	fs := flagset.NewFlagSet("seed")
+
+	appendLayerFlags(fs)
+	appendTileCacheFlags(fs)
+	appendSeedFlags(fs)
+
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Command-line tool for filling the (on-disk) tile cache with GeoParquet features encoded as vector tiles, for use by a later instance of the on-demand web server with the same options.\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\t %s seed [options]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Valid options are:\n")
 		fs.PrintDefaults()
 	}
 
 	return fs
 }
+
+// appendTileCacheFlags assigns the flags used to configure the tile cache to 'fs'.
+func appendTileCacheFlags(fs *flag.FlagSet) {
+
+	fs.IntVar(&tile_cache_size, "tile-cache-size", 0, "The maximum size, in megabytes, of (encoded) tiles to cache in memory. Cached tiles are cleared when a (local) data source changes. If 0 tiles are not cached in memory.")
+	fs.StringVar(&tile_cache_directory, "tile-cache-directory", "", "An optional path to a directory where (encoded) tiles will be cached on disk, and reused between restarts, in addition to any in-memory cache. Tiles are stored in sub-directories prefixed with \"geoparquet-show-\" and no other files in the directory are modified.")
+	fs.IntVar(&tile_cache_directory_size, "tile-cache-directory-size", 1024, "The maximum size, in megabytes, of (encoded) tiles to cache in the -tile-cache-directory directory. When exceeded the least recently used tiles are removed. If 0 there is no limit.")
+}
+
+// appendSeedFlags assigns the flags used to fill the tile cache ahead of time to 'fs'.
+func appendSeedFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&seed_zoom_range, "seed-zooms", "", "An optional range of zoom levels, in the form of {MIN_ZOOM}-{MAX_ZOOM} or {ZOOM}, for which tiles are produced and stored in the tile cache before any requests are served. Requires the -tile-cache-size or -tile-cache-directory flag.")
+	fs.StringVar(&seed_bbox, "seed-bbox", "", "An optional bounding box, in the form of {MINX},{MINY},{MAXX},{MAXY}, to limit the tiles produced by the -seed-zooms flag to. If empty the extent of each layer is used.")
+	fs.IntVar(&seed_workers, "seed-workers", 0, "The number of tiles to produce in parallel when seeding the tile cache. If 0 the number of CPUs is used.")
+}
+
+// appendLayerFlags assigns the flags used to define, filter and encode the layers being served (or exported) to 'fs'.
+func appendLayerFlags(fs *flag.FlagSet) {
+
+	fs.StringVar(&data_source, "data-source", "", "The URI of the GeoParquet data. Specifically, the value passed to the DuckDB read_parquet() function. Multiple files may be read using a glob pattern (for example \"data/region=*/part-*.parquet\") or a comma-separated list of URIs. Local files ending in \".pmtiles\" are served directly from the PMTiles archive, without any filtering or encoding options applied.")
+	fs.BoolVar(&hive_partitioning, "hive-partitioning", false, "Read Hive-style partition values (for example \"region=us\") from the paths of multi-file data sources as columns.")
+	fs.BoolVar(&union_by_name, "union-by-name", false, "Combine the columns of multi-file data sources by name, rather than by position, allowing files to have different schemas.")
+	fs.BoolVar(&filename_property, "filename-property", false, "Assign the path of the file each feature was read from to a \"filename\" property.")
+	fs.StringVar(&geometry_column, "geometry-column", "", "The name of the column containing geometries. If empty the primary column defined in the GeoParquet (\"geo\") metadata will be used, or \"geometry\" if there is no metadata.")
+	fs.StringVar(&source_crs, "source-crs", "", "The optional CRS of the geometries in the data source, expressed in a form understood by the DuckDB ST_Transform function (for example \"EPSG:2227\"). If empty the CRS defined in the GeoParquet (\"geo\") metadata will be used. Geometries that are not already in WGS84 (EPSG:4326) will be transformed on the fly.")
+	fs.StringVar(&geometry_collection_strategy, "geometry-collection", "explode", "The strategy for handling GEOMETRYCOLLECTION geometries, which can not be encoded in vector tiles. Valid options are: explode (create one feature, with the same properties, for each member of the collection), merge (create one MultiPoint, MultiLineString or MultiPolygon feature for each type of geometry in the collection).")
+	fs.Var(&layer_uris, "layer", "Zero or more named data sources to serve as separate vector tile layers, in the form of {NAME}={DATASOURCE}. If {DATASOURCE} starts with \"SELECT\" or \"WITH\" it will be treated as a SQL query. If {DATASOURCE} is a local file ending in \".pmtiles\" tiles will be served directly from the PMTiles archive. If the -data-source flag is also set it will be served as a layer named \"all\".")
+	fs.StringVar(&layer_by, "layer-by", "", "The optional name of a column whose values will be used to partition the features in each layer in to separate (vector tile) layers, for example \"wof:placetype\". Features with a NULL value are assigned to the parent layer.")
+	fs.StringVar(&where, "where", "", "An optional SQL boolean expression used to filter the features in each layer, for example: \"wof:placetype\" = 'locality' AND \"mz:is_current\" = 1. Layers with their own -layer-where flag will use that instead.")
+	fs.Var(&layer_where, "layer-where", "Zero or more SQL boolean expressions used to filter the features in a specific layer, in the form of {LAYER_NAME}={EXPRESSION}. The \"all\" layer refers to the -data-source flag.")
+	fs.Var(&layer_min_zoom, "layer-min-zoom", "Zero or more minimum zoom levels for a specific layer, in the form of {LAYER_NAME}={ZOOM}. Requests for tiles below this zoom level return an empty tile without querying the database. The \"all\" layer refers to the -data-source flag.")
+	fs.Var(&layer_max_zoom, "layer-max-zoom", "Zero or more maximum zoom levels for a specific layer, in the form of {LAYER_NAME}={ZOOM}. Requests for tiles above this zoom level return an empty tile without querying the database. The \"all\" layer refers to the -data-source flag.")
+	fs.StringVar(&minzoom_column, "minzoom-column", "", "The optional name of a numeric column containing the minimum zoom level at which each feature should be displayed, for example \"lbl:min_zoom\" or \"mz:min_zoom\". Features with a NULL value are displayed at all zoom levels.")
+	fs.StringVar(&id_column, "id-column", "", "The optional name of a column uniquely identifying each feature, for example \"wof:id\". If set it is always included in tiles, regardless of the -include-property and -exclude-property flags, and the complete (unclipped) feature, with all its properties, can be retrieved from the /features/{ID} endpoint as GeoJSON, WKT or WKB.")
+	fs.IntVar(&cluster_max_zoom, "cluster-max-zoom", 0, "Features in tiles below this zoom level are grouped in to clusters, each encoded as a single point with a \"point_count\" property. If 0 features are not clustered.")
+	fs.IntVar(&cluster_grid_size, "cluster-grid-size", default_cluster_grid_size, "The size, in pixels, of the grid cells used to cluster features. Must evenly divide the tile size (256 pixels), for example 32, 64 or 128.")
+	fs.Var(&cluster_aggregate, "cluster-aggregate", "Zero or more aggregate functions to apply to the features in each cluster, in the form of {FUNCTION}={COLUMN}. Valid functions are: sum, min, max, avg. Aggregate values are assigned to a property named {FUNCTION}_{COLUMN}.")
+	fs.StringVar(&bin_shape, "bins", "", "If set, serve an additional \"{LAYER_NAME}-bins\" layer for each layer which aggregates its features in to cells of this shape, encoded as polygons with a \"count\" property. Valid options are: square, hex.")
+	fs.IntVar(&bin_size, "bin-size", default_bin_size, "The width, in pixels, of the cells used to aggregate features in to bins.")
+	fs.Var(&bin_aggregate, "bin-aggregate", "Zero or more aggregate functions to apply to the features in each bin, in the form of {FUNCTION}={COLUMN}. Valid functions are: sum, min, max, avg. Aggregate values are assigned to a property named {FUNCTION}_{COLUMN}.")
+	fs.IntVar(&max_features_per_tile, "max-features-per-tile", 0, "The maximum number of features to include in each tile. Truncated tiles are logged and reported using the \"X-Features-Truncated\" response header. If 0 there is no limit.")
+	fs.StringVar(&order_by, "order-by", "", "An optional SQL ORDER BY expression used to sort the features in each tile before the -max-features-per-tile limit is applied, for example: ST_Area(geometry) DESC or \"wof:priority\" DESC, \"wof:id\". Features which are equal according to this expression, or all features if it is empty, are ordered by the -id-column column (if set) or their position in the data source so that truncated tiles always contain the same features.")
+	fs.StringVar(&query, "query", "", "An optional SQL SELECT statement to use as the data source instead of the -data-source flag, for example \"SELECT id, ST_Buffer(geometry, 0.001) AS geometry FROM read_parquet('example.parquet')\". Geometries are read from the column named by the -geometry-column flag (default \"geometry\").")
+	fs.StringVar(&config_file, "config", "", "An optional path to a JSON file defining layers to serve in addition to those defined by flags, in the form of {\"layers\": [{\"name\": \"{NAME}\", \"datasource\": \"{DATASOURCE}\", \"query\": \"{QUERY}\", \"where\": \"{EXPRESSION}\", \"min_zoom\": {ZOOM}, \"max_zoom\": {ZOOM}}]}. Each layer must define either a datasource or a query. When serving tiles the file is re-read when the process receives a SIGHUP signal.")
+	fs.StringVar(&db_engine, "database-engine", "duckdb", "The database/sql engine (driver) to use.")
+
+	fs.Var(&include_property, "include-property", "Zero or more glob patterns (for example \"wof:*\") that column names must match in order to be assigned as (GeoJSON Feature) properties. If empty all columns are included.")
+	fs.Var(&exclude_property, "exclude-property", "Zero or more glob patterns (for example \"src:*\") for column names to exclude from (GeoJSON Feature) properties. Exclusions take precedence over the -include-property flag.")
+	fs.Var(&zoom_property, "zoom-property", "Zero or more rules limiting the properties included in tiles below a given zoom level, in the form of {MAX_ZOOM}={PATTERN},{PATTERN}... For example \"10=wof:id\" will only include the \"wof:id\" property in tiles below zoom level 10. If more than one rule applies the rule with the lowest zoom level is used.")
+	fs.BoolVar(&drop_null_properties, "drop-null-properties", false, "Remove properties whose value is NULL from features.")
+	fs.IntVar(&max_string_length, "max-string-length", 0, "If greater than zero, string property values longer than this number of characters are truncated.")
+
+	fs.StringVar(&min_x_column, "min-x-column", "", "An optional column name to use for a initial bounding box constraint. This columns is expected to contain the minimum X (longitude) value of the geometry it is associated with. Struct fields may be specified using dot notation (for example \"bbox.xmin\"). If set this will override any column derived from the GeoParquet \"covering\" metadata.")
+	fs.StringVar(&min_y_column, "min-y-column", "", "An optional column name to use for a initial bounding box constraint. This columns is expected to contain the minimum Y (latitude) value of the geometry it is associated with. Struct fields may be specified using dot notation (for example \"bbox.ymin\"). If set this will override any column derived from the GeoParquet \"covering\" metadata.")
+	fs.StringVar(&max_x_column, "max-x-column", "", "An optional column name to use for a initial bounding box constraint. This columns is expected to contain the maximum X (longitude) value of the geometry it is associated with. Struct fields may be specified using dot notation (for example \"bbox.xmax\"). If set this will override any column derived from the GeoParquet \"covering\" metadata.")
+	fs.StringVar(&max_y_column, "max-y-column", "", "An optional column name to use for a initial bounding box constraint. This columns is expected to contain the maximum Y (latitude) value of the geometry it is associated with. Struct fields may be specified using dot notation (for example \"bbox.ymax\"). If set this will override any column derived from the GeoParquet \"covering\" metadata.")
+
+	fs.BoolVar(&materialize, "materialize", false, "Load the GeoParquet data in to a native DuckDB table, with an R-tree index, at startup. This makes startup slower but tile requests much faster.")
+	fs.StringVar(&materialize_cache, "materialize-cache", "", "An optional path to a directory where materialized tables will be persisted, keyed by the data source's path and modification time, between restarts. Only used if the -materialize flag is set.")
+
+	fs.StringVar(&extension_directory, "extension-directory", "", "An optional path to a directory where DuckDB extensions are installed, for example a copy of \"~/.duckdb/extensions\" from another machine. Extensions which are already installed in this directory are loaded without network access. If empty the DuckDB default is used.")
+	fs.StringVar(&spatial_extension_path, "spatial-extension", "", "An optional path to a DuckDB spatial extension (\"spatial.duckdb_extension\") file to load instead of installing the extension, which requires network access. The file must match the version and platform of the DuckDB library this tool was built with.")
+
+	fs.BoolVar(&verbose, "verbose", false, "Enable vebose (debug) logging.")
+}
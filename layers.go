@@ -0,0 +1,261 @@
+package show
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/sfomuseum/go-http-mvt"
+)
+
+// The name of the layer used for the data source defined by the -data-source flag.
+const default_layer_name string = "all"
+
+// The maximum zoom level that can be assigned to a layer.
+const max_zoom_level int = 24
+
+// Layer defines a named data source to serve as a vector tile layer.
+type Layer struct {
+	// The name of the layer. This is the name used in tile URLs and for the layer encoded in vector tiles.
+	Name string `json:"name"`
+	// The URI of the GeoParquet data. Specifically, the value passed to the DuckDB read_parquet() function. Multiple files may be read using
+	// a glob pattern or a comma-separated list of URIs. Local files ending in ".pmtiles" are served directly from the PMTiles archive.
+	Datasource string `json:"datasource,omitempty"`
+	// An optional SQL SELECT statement to use as the layer's data source instead of 'Datasource'.
+	Query string `json:"query,omitempty"`
+	// An optional SQL boolean expression used to filter the features in the layer.
+	Where string `json:"where,omitempty"`
+	// The minimum zoom level at which features in the layer are displayed.
+	MinZoom int `json:"min_zoom,omitempty"`
+	// The maximum zoom level at which features in the layer are displayed. If 0 there is no maximum zoom level.
+	MaxZoom int `json:"max_zoom,omitempty"`
+}
+
+// ParseLayer derives a new `Layer` instance from a string in the form of "{NAME}={DATASOURCE}". If {DATASOURCE} starts with
+// "SELECT" or "WITH" it is treated as a SQL query rather than a URI to pass to the DuckDB read_parquet() function.
+func ParseLayer(str_layer string) (*Layer, error) {
+
+	name, datasource, ok := strings.Cut(str_layer, "=")
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid layer definition, expected {NAME}={DATASOURCE}")
+	}
+
+	name = strings.TrimSpace(name)
+	datasource = strings.TrimSpace(datasource)
+
+	if !IsValidLayerName(name) {
+		return nil, fmt.Errorf("Invalid layer name '%s'", name)
+	}
+
+	if datasource == "" {
+		return nil, fmt.Errorf("Missing data source for layer '%s'", name)
+	}
+
+	l := &Layer{
+		Name: name,
+	}
+
+	if IsQuery(datasource) {
+		l.Query = datasource
+	} else {
+		l.Datasource = datasource
+	}
+
+	return l, nil
+}
+
+// LayersConfig defines the (JSON) configuration file used to define layers in addition to those defined by flags.
+type LayersConfig struct {
+	// The list of layers to serve.
+	Layers []*Layer `json:"layers"`
+}
+
+// ReadLayersConfig reads and validates the list of layers defined in the (JSON) configuration file at 'path'. For example:
+//
+//	{"layers": [{"name": "gates", "datasource": "gates.parquet", "min_zoom": 12}]}
+func ReadLayersConfig(path string) ([]*Layer, error) {
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file, %w", err)
+	}
+
+	var cfg *LayersConfig
+
+	err = json.Unmarshal(body, &cfg)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal config file, %w", err)
+	}
+
+	if cfg == nil {
+		return nil, fmt.Errorf("Config file is empty")
+	}
+
+	for idx, l := range cfg.Layers {
+
+		if l == nil {
+			return nil, fmt.Errorf("Layer at offset %d is empty", idx)
+		}
+
+		if !IsValidLayerName(l.Name) {
+			return nil, fmt.Errorf("Invalid layer name '%s' at offset %d", l.Name, idx)
+		}
+
+		if (l.Datasource == "") == (l.Query == "") {
+			return nil, fmt.Errorf("Layer '%s' must define exactly one of a data source or a query", l.Name)
+		}
+
+		for _, zoom := range []int{l.MinZoom, l.MaxZoom} {
+
+			if zoom < 0 || zoom > max_zoom_level {
+				return nil, fmt.Errorf("Invalid zoom level %d for layer '%s', must be between 0 and %d", zoom, l.Name, max_zoom_level)
+			}
+		}
+	}
+
+	return cfg.Layers, nil
+}
+
+// IsQuery reports whether 'str' looks like a SQL SELECT statement (starts with "SELECT" or "WITH").
+func IsQuery(str string) bool {
+
+	fields := strings.Fields(str)
+
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH":
+		return true
+	default:
+		return false
+	}
+}
+
+// lookupLayerOption parses 'str_opt', in the form of "{NAME}={VALUE}", and returns the layer in 'layers' matching {NAME} and {VALUE}.
+func lookupLayerOption(layers []*Layer, str_opt string) (*Layer, string, error) {
+
+	name, value, ok := strings.Cut(str_opt, "=")
+
+	if !ok {
+		return nil, "", fmt.Errorf("Invalid layer option, expected {NAME}={VALUE}")
+	}
+
+	name = strings.TrimSpace(name)
+
+	for _, l := range layers {
+
+		if l.Name == name {
+			return l, strings.TrimSpace(value), nil
+		}
+	}
+
+	return nil, "", fmt.Errorf("Unknown layer '%s'", name)
+}
+
+// parseZoomLevel parses 'str_zoom' as a zoom level between 0 and 'max_zoom_level'.
+func parseZoomLevel(str_zoom string) (int, error) {
+
+	zoom, err := strconv.Atoi(str_zoom)
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid zoom level '%s', %w", str_zoom, err)
+	}
+
+	if zoom < 0 || zoom > max_zoom_level {
+		return 0, fmt.Errorf("Invalid zoom level %d, must be between 0 and %d", zoom, max_zoom_level)
+	}
+
+	return zoom, nil
+}
+
+// parseTile parses 'str_z', 'str_x' and 'str_y' as the zoom level, column and row of a tile. The zoom level must be between
+// 0 and 'max_zoom_level' and the column and row must be between 0 and (2 ^ zoom level) - 1.
+func parseTile(str_z string, str_x string, str_y string) (maptile.Tile, error) {
+
+	z, err := parseZoomLevel(str_z)
+
+	if err != nil {
+		return maptile.Tile{}, err
+	}
+
+	max_xy := uint64(1) << z
+
+	x, err := strconv.ParseUint(str_x, 10, 32)
+
+	if err != nil || x >= max_xy {
+		return maptile.Tile{}, fmt.Errorf("Invalid column '%s' for zoom level %d, must be between 0 and %d", str_x, z, max_xy-1)
+	}
+
+	y, err := strconv.ParseUint(str_y, 10, 32)
+
+	if err != nil || y >= max_xy {
+		return maptile.Tile{}, fmt.Errorf("Invalid row '%s' for zoom level %d, must be between 0 and %d", str_y, z, max_xy-1)
+	}
+
+	return maptile.New(uint32(x), uint32(y), maptile.Zoom(z)), nil
+}
+
+// inZoomRange reports whether 'zoom' is between 'min_zoom' and 'max_zoom' (inclusive). If 'max_zoom' is 0 there is no maximum zoom level.
+func inZoomRange(zoom int, min_zoom int, max_zoom int) bool {
+
+	if zoom < min_zoom {
+		return false
+	}
+
+	if max_zoom > 0 && zoom > max_zoom {
+		return false
+	}
+
+	return true
+}
+
+// IsValidLayerName reports whether 'name' can be used as a layer name. Layer names are used in tile URLs (and MapLibre
+// style identifiers) so they are limited to letters, numbers, "-", "_", "." and ":".
+func IsValidLayerName(name string) bool {
+
+	if name == "" {
+		return false
+	}
+
+	for _, r := range name {
+
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			// pass
+		case r == '-', r == '_', r == '.', r == ':':
+			// pass
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// GetFeaturesForLayersFunc returns a `mvt.GetFeaturesCallbackFunc` callback function which dispatches each request to the
+// callback function in 'callbacks' matching the requested layer name.
+func GetFeaturesForLayersFunc(callbacks map[string]mvt.GetFeaturesCallbackFunc) mvt.GetFeaturesCallbackFunc {
+
+	fn := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		cb, exists := callbacks[layer]
+
+		if !exists {
+			return nil, fmt.Errorf("Unknown layer '%s'", layer)
+		}
+
+		return cb(ctx, layer, t)
+	}
+
+	return fn
+}
@@ -0,0 +1,154 @@
+package show
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLayer(t *testing.T) {
+
+	l, err := ParseLayer("gates=/usr/local/data/gates.geoparquet")
+
+	if err != nil {
+		t.Fatalf("Failed to parse layer, %v", err)
+	}
+
+	if l.Name != "gates" || l.Datasource != "/usr/local/data/gates.geoparquet" || l.Query != "" {
+		t.Fatalf("Unexpected layer: %v", l)
+	}
+
+	l, err = ParseLayer("buffers= select id, ST_Buffer(geometry, 0.001) AS geometry FROM read_parquet('a=b.parquet')")
+
+	if err != nil {
+		t.Fatalf("Failed to parse query layer, %v", err)
+	}
+
+	if l.Name != "buffers" || l.Datasource != "" || l.Query != "select id, ST_Buffer(geometry, 0.001) AS geometry FROM read_parquet('a=b.parquet')" {
+		t.Fatalf("Unexpected query layer: %v", l)
+	}
+
+	_, err = ParseLayer("bad/name=example.parquet")
+
+	if err == nil {
+		t.Fatalf("Expected invalid layer name to fail")
+	}
+}
+
+func TestInZoomRange(t *testing.T) {
+
+	tests := []struct {
+		zoom     int
+		min_zoom int
+		max_zoom int
+		expected bool
+	}{
+		{0, 0, 0, true},
+		{20, 0, 0, true},
+		{4, 6, 0, false},
+		{6, 6, 12, true},
+		{12, 6, 12, true},
+		{13, 6, 12, false},
+	}
+
+	for _, test := range tests {
+
+		if inZoomRange(test.zoom, test.min_zoom, test.max_zoom) != test.expected {
+			t.Fatalf("Unexpected result for zoom %d (%d-%d)", test.zoom, test.min_zoom, test.max_zoom)
+		}
+	}
+
+	_, err := parseZoomLevel("25")
+
+	if err == nil {
+		t.Fatalf("Expected zoom level 25 to be invalid")
+	}
+}
+
+func TestParseTile(t *testing.T) {
+
+	tests := map[string]bool{
+		"0/0/0":          true,
+		"4/15/15":        true,
+		"0/1/0":          false,
+		"4/16/0":         false,
+		"4/0/16":         false,
+		"25/0/0":         false,
+		"4/-1/0":         false,
+		"4/4294967296/0": false,
+	}
+
+	for str_tile, expected := range tests {
+
+		parts := strings.Split(str_tile, "/")
+		tile, err := parseTile(parts[0], parts[1], parts[2])
+
+		if (err == nil) != expected {
+			t.Fatalf("Unexpected result for %s, %v", str_tile, err)
+		}
+
+		if expected && fmt.Sprintf("%d/%d/%d", tile.Z, tile.X, tile.Y) != str_tile {
+			t.Fatalf("Unexpected tile for %s: %v", str_tile, tile)
+		}
+	}
+}
+
+func TestReadLayersConfig(t *testing.T) {
+
+	root := t.TempDir()
+
+	valid := filepath.Join(root, "valid.json")
+
+	err := os.WriteFile(valid, []byte(`{"layers": [{"name": "gates", "datasource": "gates.parquet", "min_zoom": 12}, {"name": "sql", "query": "SELECT * FROM read_parquet('example.parquet')"}]}`), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write config file, %v", err)
+	}
+
+	layers, err := ReadLayersConfig(valid)
+
+	if err != nil {
+		t.Fatalf("Failed to read config file, %v", err)
+	}
+
+	if len(layers) != 2 {
+		t.Fatalf("Expected 2 layers, got %d", len(layers))
+	}
+
+	if layers[0].Name != "gates" || layers[0].Datasource != "gates.parquet" || layers[0].MinZoom != 12 {
+		t.Fatalf("Unexpected first layer: %v", layers[0])
+	}
+
+	if layers[1].Name != "sql" || layers[1].Query == "" {
+		t.Fatalf("Unexpected second layer: %v", layers[1])
+	}
+
+	invalid := []string{
+		`{"layers": [{"name": "bad name", "datasource": "gates.parquet"}]}`,
+		`{"layers": [{"name": "gates"}]}`,
+		`{"layers": [{"name": "gates", "datasource": "gates.parquet", "query": "SELECT 1"}]}`,
+		`{"layers": [{"name": "gates", "datasource": "gates.parquet", "max_zoom": 30}]}`,
+		`{"layers": [null]}`,
+		`null`,
+		`not json`,
+	}
+
+	for idx, body := range invalid {
+
+		path := filepath.Join(root, fmt.Sprintf("invalid-%d.json", idx))
+
+		err := os.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write config file, %v", err)
+		}
+
+		_, err = ReadLayersConfig(path)
+
+		if err == nil {
+			t.Fatalf("Expected config to fail: %s", body)
+		}
+	}
+}
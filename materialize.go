@@ -0,0 +1,334 @@
+package show
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The default name of the (DuckDB) table that GeoParquet data are materialized in to.
+const materialized_table string = "features"
+
+// The prefix for the names of the databases that on-disk materialized tables are attached as.
+const materialized_database string = "show_cache"
+
+// The file extension for on-disk materialized databases.
+const materialized_ext string = ".duckdb"
+
+// The file extension DuckDB appends to the write-ahead log for on-disk databases.
+const materialized_wal_ext string = ".wal"
+
+// The pattern for the state hash of on-disk materialized databases. See `materializeCachePath` for details.
+var re_materialized_hash = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// MaterializeOptions defines configuration details for loading GeoParquet data in to a native (DuckDB) table.
+type MaterializeOptions struct {
+	// A valid `sql.DB` (DuckDB) instance to use for querying data
+	Database *sql.DB
+	// A unique name for the materialized table. If empty then "features" will be used.
+	Name string
+	// The URI of the GeoParquet data. Specifically, the value passed to the DuckDB read_parquet() function.
+	Datasource string
+	// Optional options to pass to the DuckDB read_parquet() function.
+	ReadOptions *ParquetReadOptions
+	// An optional SQL SELECT statement to materialize instead of 'Datasource'. Query results are never persisted to 'CacheDirectory'.
+	Query string
+	// The name of the column containing geometries.
+	GeometryColumn string
+	// The encoding of the geometries in 'GeometryColumn'.
+	GeometryEncoding string
+	// An optional path to a directory where materialized tables will be persisted (as DuckDB databases) between restarts.
+	CacheDirectory string
+}
+
+// Materialize loads the data defined by 'opts' in to a native DuckDB table with a decoded GEOMETRY column and an R-tree
+// index and returns the name of that table. If 'opts.CacheDirectory' is set the table will be written to a DuckDB database in
+// that directory, keyed by the data source's path and modification time, and reused on subsequent invocations.
+func Materialize(ctx context.Context, opts *MaterializeOptions) (string, error) {
+
+	name := opts.Name
+
+	if name == "" {
+		name = materialized_table
+	}
+
+	table := name
+
+	// The path of the on-disk database the table is written to, if any.
+	var cache_path string
+
+	if opts.CacheDirectory != "" && opts.Query == "" {
+
+		path, err := materializeCachePath(opts.CacheDirectory, opts.Datasource, opts.GeometryColumn, opts.ReadOptions)
+
+		if err != nil {
+			slog.Warn("Unable to derive materialized cache path, table will be created in memory", "datasource", opts.Datasource, "error", err)
+		} else {
+
+			cache_path = path
+
+			// Each materialized table is stored in its own database, attached using a name derived
+			// from the table name, and stored in that database as "features". A database can only be
+			// attached once so if it is already attached (by a previous tileset, which may still be
+			// serving requests, whose data source has not changed) it is reused.
+
+			db_name, err := attachedDatabase(ctx, opts.Database, cache_path)
+
+			if err != nil {
+				return "", err
+			}
+
+			if db_name == "" {
+
+				db_name = fmt.Sprintf("%s_%s", materialized_database, name)
+
+				q := fmt.Sprintf(`ATTACH '%s' AS %s`, strings.ReplaceAll(cache_path, "'", "''"), db_name)
+
+				_, err = opts.Database.ExecContext(ctx, q)
+
+				if err != nil {
+					return "", fmt.Errorf("Failed to attach materialized cache database, %w", err)
+				}
+			}
+
+			table = fmt.Sprintf("%s.%s", db_name, materialized_table)
+
+			exists, err := materializedTableExists(ctx, opts.Database, db_name)
+
+			if err != nil {
+				return "", err
+			}
+
+			if exists {
+				slog.Debug("Reuse materialized table", "path", cache_path)
+				return table, nil
+			}
+		}
+	}
+
+	t1 := time.Now()
+
+	defer func() {
+		slog.Debug("Time to materialize data source", "table", table, "time", time.Since(t1))
+	}()
+
+	tx, err := opts.Database.BeginTx(ctx, nil)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to start transaction, %w", err)
+	}
+
+	defer tx.Rollback()
+
+	geom, err := decodeGeometryExpression(opts.GeometryColumn, opts.GeometryEncoding)
+
+	if err != nil {
+		return "", err
+	}
+
+	create := []string{
+		fmt.Sprintf(`CREATE OR REPLACE TABLE %s AS SELECT * EXCLUDE ("%s"), %s AS "%s" FROM %s`, table, opts.GeometryColumn, geom, opts.GeometryColumn, sourceClause(opts.Datasource, opts.Query, opts.ReadOptions)),
+		fmt.Sprintf(`CREATE INDEX %s_geometry_idx ON %s USING RTREE ("%s")`, name, table, opts.GeometryColumn),
+	}
+
+	for _, q := range create {
+
+		_, err := tx.ExecContext(ctx, q)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to materialize data source (%s), %w", q, err)
+		}
+	}
+
+	err = tx.Commit()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to commit materialized table, %w", err)
+	}
+
+	if cache_path != "" {
+
+		err := removeSupersededCaches(cache_path)
+
+		if err != nil {
+			slog.Warn("Failed to remove superseded materialized cache databases", "path", cache_path, "error", err)
+		}
+	}
+
+	return table, nil
+}
+
+// attachedDatabase returns the name of the database that the DuckDB database file 'path' is attached as, or an empty string if it is not attached.
+func attachedDatabase(ctx context.Context, db *sql.DB, path string) (string, error) {
+
+	q := `SELECT database_name FROM duckdb_databases() WHERE path = ?`
+
+	var db_name string
+
+	err := db.QueryRowContext(ctx, q, path).Scan(&db_name)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to determine whether materialized cache database is attached, %w", err)
+	}
+
+	return db_name, nil
+}
+
+// releaseMaterializedTables drops each of the tables, returned by `Materialize`, in 'tables' which is not in 'keep'. Tables stored in
+// on-disk databases are not dropped, so they can be reused, but the databases they are stored in are detached.
+func releaseMaterializedTables(ctx context.Context, db *sql.DB, tables []string, keep []string) error {
+
+	for _, table := range tables {
+
+		if slices.Contains(keep, table) {
+			continue
+		}
+
+		q := fmt.Sprintf(`DROP TABLE IF EXISTS %s`, table)
+
+		db_name, _, is_attached := strings.Cut(table, ".")
+
+		if is_attached {
+			q = fmt.Sprintf(`DETACH DATABASE IF EXISTS %s`, db_name)
+		}
+
+		_, err := db.ExecContext(ctx, q)
+
+		if err != nil {
+			return fmt.Errorf("Failed to release materialized table %s, %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+func materializedTableExists(ctx context.Context, db *sql.DB, db_name string) (bool, error) {
+
+	q := `SELECT COUNT(*) FROM duckdb_tables() WHERE database_name = ? AND table_name = ?`
+
+	var count int
+
+	err := db.QueryRowContext(ctx, q, db_name, materialized_table).Scan(&count)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to determine whether materialized table exists, %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// materializeCachePath returns the path of the DuckDB database in 'root' for 'datasource'. The filename is derived from
+// the absolute path of 'datasource' (and the name of the geometry column being decoded and the options used to read it)
+// followed by its size and modification time so that changes to the data source will not be masked by stale caches and
+// so that the databases for previous versions of the data source can be identified by `removeSupersededCaches`.
+func materializeCachePath(root string, datasource string, geom_col string, read_opts *ParquetReadOptions) (string, error) {
+
+	abs_path, err := filepath.Abs(datasource)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to derive absolute path for data source, %w", err)
+	}
+
+	info, err := os.Stat(abs_path)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to stat data source, %w", err)
+	}
+
+	if info.IsDir() {
+		return "", fmt.Errorf("Data source is a directory")
+	}
+
+	err = os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to create cache directory, %w", err)
+	}
+
+	source_key := fmt.Sprintf("%s#%s", abs_path, geom_col)
+
+	if read_opts != nil {
+		source_key = fmt.Sprintf("%s#%t#%t#%t", source_key, read_opts.HivePartitioning, read_opts.UnionByName, read_opts.Filename)
+	}
+
+	state_key := fmt.Sprintf("%s#%d#%d", source_key, info.Size(), info.ModTime().UnixNano())
+
+	source_hash := fmt.Sprintf("%x", sha256.Sum256([]byte(source_key)))
+	state_hash := fmt.Sprintf("%x", sha256.Sum256([]byte(state_key)))
+
+	fname := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+	fname = fmt.Sprintf("%s-%s-%s%s", fname, source_hash[0:8], state_hash[0:16], materialized_ext)
+
+	return filepath.Join(root, fname), nil
+}
+
+// removeSupersededCaches removes the DuckDB databases (and their write-ahead logs), in the same directory as 'cache_path',
+// written for previous versions of the same data source. See `materializeCachePath` for details. Databases for other data
+// sources, and any other files, in that directory are left untouched.
+func removeSupersededCaches(cache_path string) error {
+
+	root := filepath.Dir(cache_path)
+	current := filepath.Base(cache_path)
+
+	// Strip the state hash, and extension, from the current filename leaving "{NAME}-{SOURCE_HASH}-"
+
+	idx := strings.LastIndex(current, "-")
+
+	if idx == -1 {
+		return fmt.Errorf("Invalid materialized cache path")
+	}
+
+	prefix := current[0 : idx+1]
+
+	entries, err := os.ReadDir(root)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read cache directory, %w", err)
+	}
+
+	for _, e := range entries {
+
+		fname := e.Name()
+
+		if e.IsDir() || !strings.HasPrefix(fname, prefix) {
+			continue
+		}
+
+		db_fname := strings.TrimSuffix(fname, materialized_wal_ext)
+
+		if !strings.HasSuffix(db_fname, materialized_ext) || db_fname == current {
+			continue
+		}
+
+		// Make sure that what follows the prefix is only a state hash so that the databases for data sources
+		// whose names happen to start with the same prefix are not removed.
+
+		state_hash := strings.TrimSuffix(strings.TrimPrefix(db_fname, prefix), materialized_ext)
+
+		if !re_materialized_hash.MatchString(state_hash) {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(root, fname))
+
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove %s, %w", fname, err)
+		}
+
+		slog.Debug("Removed superseded materialized cache database", "path", filepath.Join(root, fname))
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemoveSupersededCaches(t *testing.T) {
+
+	data_dir := t.TempDir()
+	cache_dir := t.TempDir()
+
+	datasource := filepath.Join(data_dir, "example.parquet")
+
+	err := os.WriteFile(datasource, []byte("v1"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write data source, %v", err)
+	}
+
+	old_path, err := materializeCachePath(cache_dir, datasource, "geometry", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to derive cache path, %v", err)
+	}
+
+	other_path, err := materializeCachePath(cache_dir, datasource, "geom", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to derive cache path, %v", err)
+	}
+
+	// Change the data source so that it is written to a new cache path
+
+	err = os.WriteFile(datasource, []byte("v2"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to update data source, %v", err)
+	}
+
+	mtime := time.Now().Add(time.Hour)
+	os.Chtimes(datasource, mtime, mtime)
+
+	new_path, err := materializeCachePath(cache_dir, datasource, "geometry", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to derive cache path, %v", err)
+	}
+
+	if new_path == old_path {
+		t.Fatalf("Expected cache path to change when data source changes")
+	}
+
+	unrelated_path := filepath.Join(cache_dir, "notes.txt")
+
+	for _, path := range []string{old_path, old_path + materialized_wal_ext, other_path, new_path, unrelated_path} {
+
+		err := os.WriteFile(path, []byte("test"), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+	}
+
+	err = removeSupersededCaches(new_path)
+
+	if err != nil {
+		t.Fatalf("Failed to remove superseded caches, %v", err)
+	}
+
+	tests := map[string]bool{
+		old_path:                        false,
+		old_path + materialized_wal_ext: false,
+		other_path:                      true,
+		new_path:                        true,
+		unrelated_path:                  true,
+	}
+
+	for path, expected := range tests {
+
+		_, err := os.Stat(path)
+
+		if (err == nil) != expected {
+			t.Fatalf("Unexpected state for %s, expected to exist: %t", strings.TrimPrefix(path, cache_dir), expected)
+		}
+	}
+}
+
+func TestReleaseMaterializedTables(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	// Only use a single connection so that the in-memory tables are visible to every query
+
+	db.SetMaxOpenConns(1)
+
+	cache_path := filepath.Join(t.TempDir(), "example.db")
+
+	setup := []string{
+		`CREATE TABLE features_1_0 AS SELECT 1 AS id`,
+		`CREATE TABLE features_2_0 AS SELECT 2 AS id`,
+		fmt.Sprintf(`ATTACH '%s' AS materialized_features_1_1`, cache_path),
+		`CREATE TABLE materialized_features_1_1.features AS SELECT 3 AS id`,
+	}
+
+	for _, q := range setup {
+
+		_, err := db.ExecContext(ctx, q)
+
+		if err != nil {
+			t.Fatalf("Failed to execute '%s', %v", q, err)
+		}
+	}
+
+	db_name, err := attachedDatabase(ctx, db, cache_path)
+
+	if err != nil {
+		t.Fatalf("Failed to determine attached database, %v", err)
+	}
+
+	if db_name != "materialized_features_1_1" {
+		t.Fatalf("Unexpected attached database: '%s'", db_name)
+	}
+
+	tables := []string{"features_1_0", "features_2_0", "materialized_features_1_1.features"}
+
+	err = releaseMaterializedTables(ctx, db, tables, []string{"features_2_0"})
+
+	if err != nil {
+		t.Fatalf("Failed to release materialized tables, %v", err)
+	}
+
+	var count int
+
+	err = db.QueryRowContext(ctx, `SELECT COUNT(*) FROM duckdb_tables() WHERE table_name IN ('features_1_0', 'features_2_0')`).Scan(&count)
+
+	if err != nil {
+		t.Fatalf("Failed to count tables, %v", err)
+	}
+
+	if count != 1 {
+		t.Fatalf("Expected only the kept table to remain, got %d tables", count)
+	}
+
+	db_name, err = attachedDatabase(ctx, db, cache_path)
+
+	if err != nil {
+		t.Fatalf("Failed to determine attached database, %v", err)
+	}
+
+	if db_name != "" {
+		t.Fatalf("Expected cache database to be detached")
+	}
+
+	// Databases are detached, rather than their tables being dropped, so that they can be reused
+
+	_, err = os.Stat(cache_path)
+
+	if err != nil {
+		t.Fatalf("Expected cache database to be kept, %v", err)
+	}
+}
+
+func TestMaterializeCountries(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+
+	// The tile containing the south-western United States and Mexico
+	expected := tileFeatureIds(t, GetFeaturesForTileFunc(opts), 3, 1, 3)
+
+	if !slices.Contains(expected, "USA") || !slices.Contains(expected, "MEX") {
+		t.Fatalf("Expected tile to contain the United States and Mexico: %v", expected)
+	}
+
+	cache_dir := t.TempDir()
+
+	for _, dir := range []string{"", cache_dir, cache_dir} {
+
+		materialize_opts := &MaterializeOptions{
+			Database:         opts.Database,
+			Name:             "countries",
+			Datasource:       opts.Datasource,
+			GeometryColumn:   opts.GeometryColumn,
+			GeometryEncoding: opts.GeometryEncoding,
+			CacheDirectory:   dir,
+		}
+
+		table, err := Materialize(ctx, materialize_opts)
+
+		if err != nil {
+			t.Fatalf("Failed to materialize data source (cache directory '%s'), %v", dir, err)
+		}
+
+		var count int64
+
+		// Tables in cache databases are qualified by the name of the database they are attached as
+		table_name := table[strings.LastIndex(table, ".")+1:]
+
+		err = opts.Database.QueryRowContext(ctx, `SELECT COUNT(*) FROM duckdb_indexes() WHERE index_name = 'countries_geometry_idx' AND table_name = ?`, table_name).Scan(&count)
+
+		if err != nil {
+			t.Fatalf("Failed to query indexes, %v", err)
+		}
+
+		if count == 0 {
+			t.Fatalf("Expected materialized table %s to have a spatial index", table)
+		}
+
+		table_opts := *opts
+		table_opts.Table = table
+
+		ids := tileFeatureIds(t, GetFeaturesForTileFunc(&table_opts), 3, 1, 3)
+
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("Unexpected features for materialized table %s: %v, expected %v", table, ids, expected)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package show
+
+// https://github.com/mapbox/mbtiles-spec/blob/master/1.3/spec.md
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+// The name of the (DuckDB) alias for the MBTiles database being written.
+const mbtiles_alias string = "mbtiles"
+
+// mbtilesWriter implements the `tileWriter` interface for writing tiles to an MBTiles database. The database is written
+// using the DuckDB "sqlite" extension, which is loaded (and installed, if necessary and not loaded from a file) when the writer is created.
+type mbtilesWriter struct {
+	path string
+	conn *sql.Conn
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+// newMBTilesWriter returns a new `mbtilesWriter` instance for writing tiles to 'path', which must not already exist, using 'db'. The sqlite
+// extension is loaded using the configuration details in 'ext_opts', which may be nil.
+func newMBTilesWriter(ctx context.Context, db *sql.DB, path string, ext_opts *ExtensionOptions) (*mbtilesWriter, error) {
+
+	_, err := os.Stat(path)
+
+	if err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	// Tiles are written inside a single transaction, which requires a dedicated connection
+	// rather than the connection pool.
+
+	conn, err := db.Conn(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create database connection, %w", err)
+	}
+
+	err = loadSQLiteExtension(ctx, conn, ext_opts)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	setup := []string{
+		fmt.Sprintf(`ATTACH '%s' AS %s (TYPE SQLITE)`, strings.ReplaceAll(path, "'", "''"), mbtiles_alias),
+		fmt.Sprintf(`CREATE TABLE %s.metadata (name TEXT, value TEXT)`, mbtiles_alias),
+		fmt.Sprintf(`CREATE TABLE %s.tiles (zoom_level INTEGER, tile_column INTEGER, tile_row INTEGER, tile_data BLOB)`, mbtiles_alias),
+	}
+
+	for _, q := range setup {
+
+		_, err := conn.ExecContext(ctx, q)
+
+		if err != nil {
+			conn.Close()
+			os.Remove(path)
+			return nil, fmt.Errorf("MBTiles setup command (%s) failed, %w", q, err)
+		}
+	}
+
+	wr := &mbtilesWriter{
+		path: path,
+		conn: conn,
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+
+	if err != nil {
+		wr.Abort()
+		return nil, fmt.Errorf("Failed to start transaction, %w", err)
+	}
+
+	wr.tx = tx
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`INSERT INTO %s.tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)`, mbtiles_alias))
+
+	if err != nil {
+		wr.Abort()
+		return nil, fmt.Errorf("Failed to prepare statement, %w", err)
+	}
+
+	wr.stmt = stmt
+	return wr, nil
+}
+
+func (wr *mbtilesWriter) WriteTile(ctx context.Context, t maptile.Tile, body []byte) error {
+
+	gz_body, err := gzipBytes(body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to compress tile, %w", err)
+	}
+
+	// MBTiles uses the TMS tiling scheme where row numbers increase northwards.
+
+	row := (uint32(1) << t.Z) - 1 - t.Y
+
+	_, err = wr.stmt.ExecContext(ctx, int(t.Z), int(t.X), int(row), gz_body)
+	return err
+}
+
+func (wr *mbtilesWriter) Close(ctx context.Context, md *exportMetadata) error {
+
+	enc_layers, err := json.Marshal(map[string]any{
+		"vector_layers": md.VectorLayers,
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal vector layers, %w", err)
+	}
+
+	metadata := [][2]string{
+		{"name", md.Name},
+		{"format", md.Format},
+		{"type", "overlay"},
+		{"generator", md.Generator},
+		{"minzoom", strconv.Itoa(md.MinZoom)},
+		{"maxzoom", strconv.Itoa(md.MaxZoom)},
+		{"bounds", fmt.Sprintf("%s,%s,%s,%s", formatFloat(md.Bounds[0]), formatFloat(md.Bounds[1]), formatFloat(md.Bounds[2]), formatFloat(md.Bounds[3]))},
+		{"center", fmt.Sprintf("%s,%s,%d", formatFloat(md.Center[0]), formatFloat(md.Center[1]), int(md.Center[2]))},
+		{"json", string(enc_layers)},
+	}
+
+	q := fmt.Sprintf(`INSERT INTO %s.metadata (name, value) VALUES (?, ?)`, mbtiles_alias)
+
+	for _, kv := range metadata {
+
+		_, err := wr.tx.ExecContext(ctx, q, kv[0], kv[1])
+
+		if err != nil {
+			return fmt.Errorf("Failed to write metadata, %w", err)
+		}
+	}
+
+	wr.stmt.Close()
+
+	err = wr.tx.Commit()
+
+	if err != nil {
+		return fmt.Errorf("Failed to commit transaction, %w", err)
+	}
+
+	wr.tx = nil
+
+	// The index is created after all the tiles have been written since that is much faster than
+	// updating it for every tile. The MBTiles specification recommends, but does not require, it.
+
+	index_q := fmt.Sprintf(`CREATE UNIQUE INDEX tile_index ON %s.tiles (zoom_level, tile_column, tile_row)`, mbtiles_alias)
+
+	_, err = wr.conn.ExecContext(ctx, index_q)
+
+	if err != nil {
+		slog.Warn("Failed to create MBTiles tile index", "error", err)
+	}
+
+	_, err = wr.conn.ExecContext(ctx, fmt.Sprintf(`DETACH %s`, mbtiles_alias))
+
+	if err != nil {
+		return fmt.Errorf("Failed to detach MBTiles database, %w", err)
+	}
+
+	return wr.conn.Close()
+}
+
+func (wr *mbtilesWriter) Abort() {
+
+	if wr.stmt != nil {
+		wr.stmt.Close()
+	}
+
+	if wr.tx != nil {
+		wr.tx.Rollback()
+	}
+
+	// DETACH will fail if the database has already been detached which is fine.
+
+	wr.conn.ExecContext(context.Background(), fmt.Sprintf(`DETACH %s`, mbtiles_alias))
+	wr.conn.Close()
+
+	os.Remove(wr.path)
+}
@@ -0,0 +1,118 @@
+package show
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+)
+
+// The size, in pixels, of a (Web Mercator) tile used to calculate pixel coordinates.
+const tile_size float64 = 256
+
+// The maximum latitude of the Web Mercator projection.
+const max_mercator_lat float64 = 85.0511
+
+// worldSize returns the width (and height), in pixels, of the world at zoom level 'zoom'.
+func worldSize(zoom int) float64 {
+	return math.Pow(2, float64(zoom)) * tile_size
+}
+
+// mercatorPixelExpressions returns the SQL expressions used to derive the (Web Mercator) pixel coordinates, at zoom level 'zoom',
+// of the longitude 'x' and latitude 'y'. Pixel coordinates are measured from the top-left (north-west) corner of the world.
+func mercatorPixelExpressions(x string, y string, zoom int) (string, string) {
+
+	size := formatFloat(worldSize(zoom))
+
+	// Latitudes are clamped to the bounds of the Web Mercator projection.
+	lat := fmt.Sprintf(`radians(greatest(least(%s, %s), -%s))`, y, formatFloat(max_mercator_lat), formatFloat(max_mercator_lat))
+
+	px := fmt.Sprintf(`((%s + 180) / 360 * %s)`, x, size)
+	py := fmt.Sprintf(`((1 - ln(tan(%s) + 1 / cos(%s)) / pi()) / 2 * %s)`, lat, lat, size)
+
+	return px, py
+}
+
+// pixelToLonLat returns the longitude and latitude of the (Web Mercator) pixel coordinates 'px' and 'py' at zoom level 'zoom'.
+func pixelToLonLat(px float64, py float64, zoom int) orb.Point {
+
+	size := worldSize(zoom)
+
+	lon := px/size*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*py/size))) * 180 / math.Pi
+
+	return orb.Point{lon, lat}
+}
+
+// pixelBound returns the bounds, in longitude and latitude, of the tile 't' expanded by 'pad' pixels on each side.
+func pixelBound(t *maptile.Tile, pad float64) orb.Bound {
+
+	zoom := int(t.Z)
+	size := worldSize(zoom)
+
+	min_px := math.Max(float64(t.X)*tile_size-pad, 0)
+	max_px := math.Min(float64(t.X+1)*tile_size+pad, size)
+	min_py := math.Max(float64(t.Y)*tile_size-pad, 0)
+	max_py := math.Min(float64(t.Y+1)*tile_size+pad, size)
+
+	// Note that pixel Y coordinates increase southwards.
+
+	return orb.Bound{
+		Min: pixelToLonLat(min_px, max_py, zoom),
+		Max: pixelToLonLat(max_px, min_py, zoom),
+	}
+}
+
+// tileRange returns the minimum and maximum (inclusive) tiles containing 'bound' at zoom level 'zoom'.
+func tileRange(bound orb.Bound, zoom int) (maptile.Tile, maptile.Tile) {
+
+	z := maptile.Zoom(zoom)
+	max_xy := uint32(1<<zoom) - 1
+
+	// Note that tile Y coordinates increase southwards. Points outside the bounds of the Web Mercator
+	// projection are snapped to the outermost tiles.
+
+	min_t := maptile.At(orb.Point{bound.Min[0], bound.Max[1]}, z)
+	max_t := maptile.At(orb.Point{bound.Max[0], bound.Min[1]}, z)
+
+	min_t.X = min(min_t.X, max_xy)
+	min_t.Y = min(min_t.Y, max_xy)
+	max_t.X = min(max_t.X, max_xy)
+	max_t.Y = min(max_t.Y, max_xy)
+
+	return min_t, max_t
+}
+
+// countTiles returns the number of tiles for every zoom level between 'min_zoom' and 'max_zoom' (inclusive) which intersect 'bound'.
+func countTiles(bound orb.Bound, min_zoom int, max_zoom int) int64 {
+
+	var count int64
+
+	for z := min_zoom; z <= max_zoom; z++ {
+		min_t, max_t := tileRange(bound, z)
+		count += int64(max_t.X-min_t.X+1) * int64(max_t.Y-min_t.Y+1)
+	}
+
+	return count
+}
+
+// walkTiles calls 'fn' for each tile, for every zoom level between 'min_zoom' and 'max_zoom' (inclusive), which intersects 'bound'
+// in order of zoom level, column and then row. If 'fn' returns false no more tiles are visited.
+func walkTiles(bound orb.Bound, min_zoom int, max_zoom int, fn func(maptile.Tile) bool) {
+
+	for z := min_zoom; z <= max_zoom; z++ {
+
+		min_t, max_t := tileRange(bound, z)
+
+		for x := min_t.X; x <= max_t.X; x++ {
+
+			for y := min_t.Y; y <= max_t.Y; y++ {
+
+				if !fn(maptile.New(x, y, maptile.Zoom(z))) {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// The name of the geometry column to use when there is no GeoParquet metadata.
+const default_geometry_column string = "geometry"
+
+// The geometry encoding to assume when there is no GeoParquet metadata.
+const default_geometry_encoding string = "WKB"
+
+// GeoMetadata defines (a subset of) the GeoParquet file metadata stored in the "geo" key of a Parquet file's key-value metadata.
+// See also: https://github.com/opengeospatial/geoparquet/blob/main/format-specs/geoparquet.md#file-metadata
+type GeoMetadata struct {
+	// The version of the GeoParquet specification the file was written with.
+	Version string `json:"version"`
+	// The name of the "primary" geometry column.
+	PrimaryColumn string `json:"primary_column"`
+	// Metadata about geometry columns, keyed by column name.
+	Columns map[string]*GeoColumnMetadata `json:"columns"`
+}
+
+// GeoColumnMetadata defines (a subset of) the GeoParquet metadata for an individual geometry column.
+type GeoColumnMetadata struct {
+	// The name of the geometry encoding (for example "WKB").
+	Encoding string `json:"encoding"`
+	// The list of geometry types (for example "Polygon" or "MultiPoint Z") contained by the column. An empty list means that any geometry type may be present.
+	GeometryTypes []string `json:"geometry_types"`
+	// The (PROJJSON) coordinate reference system of the geometries in the column. If absent geometries are assumed to be OGC:CRS84 (WGS84 longitude, latitude).
+	CRS json.RawMessage `json:"crs,omitempty"`
+	// The optional bounding box of all the geometries in the column, expressed as [minx, miny, maxx, maxy].
+	Bbox []float64 `json:"bbox,omitempty"`
+	// An optional description of columns containing bounding box information for each geometry.
+	Covering *GeoCovering `json:"covering,omitempty"`
+}
+
+// GeoCovering defines columns that contain "covering" data for each geometry, introduced in GeoParquet 1.1.
+type GeoCovering struct {
+	// The columns containing the bounding box of each geometry.
+	Bbox *GeoCoveringBbox `json:"bbox,omitempty"`
+}
+
+// GeoCoveringBbox defines the paths to the columns containing the bounding box of each geometry. Each path is a list
+// of column (and struct field) names, for example ["bbox", "xmin"].
+type GeoCoveringBbox struct {
+	XMin []string `json:"xmin"`
+	YMin []string `json:"ymin"`
+	XMax []string `json:"xmax"`
+	YMax []string `json:"ymax"`
+}
+
+// ReadGeoMetadata reads and parses the GeoParquet "geo" metadata for 'datasource' using the DuckDB `parquet_kv_metadata` function.
+// If 'datasource' contains multiple files the metadata for the first file is returned. If there is no "geo" metadata then
+// a nil value (and no error) is returned.
+func ReadGeoMetadata(ctx context.Context, db *sql.DB, datasource string) (*GeoMetadata, error) {
+
+	q := fmt.Sprintf(`SELECT decode(value) FROM parquet_kv_metadata(%s) WHERE decode(key) = 'geo' LIMIT 1`, parquetFilesArgument(datasource))
+
+	var str_md string
+
+	err := db.QueryRowContext(ctx, q).Scan(&str_md)
+
+	if err != nil {
+
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("Failed to read GeoParquet metadata, %w", err)
+	}
+
+	var md *GeoMetadata
+
+	err = json.Unmarshal([]byte(str_md), &md)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal GeoParquet metadata, %w", err)
+	}
+
+	return md, nil
+}
+
+// GeometryColumn returns the name and metadata for the geometry column 'name'. If 'name' is empty then the metadata's primary
+// column will be used. If 'md' is nil (the data source has no GeoParquet metadata) then 'name', or "geometry" if empty, is
+// returned with metadata assuming WKB-encoded geometries. Likewise, if 'name' is not defined by the metadata it is assumed
+// to contain WKB-encoded geometries with an unknown CRS.
+func (md *GeoMetadata) GeometryColumn(name string) (string, *GeoColumnMetadata, error) {
+
+	if md == nil {
+
+		if name == "" {
+			name = default_geometry_column
+		}
+
+		col_md := &GeoColumnMetadata{
+			Encoding: default_geometry_encoding,
+		}
+
+		return name, col_md, nil
+	}
+
+	if name == "" {
+		name = md.PrimaryColumn
+	}
+
+	if name == "" {
+		return "", nil, fmt.Errorf("GeoParquet metadata does not define a primary column")
+	}
+
+	col_md, exists := md.Columns[name]
+
+	if !exists {
+
+		// Columns which are not declared in the metadata are assumed to contain WKB-encoded geometries, as they are for
+		// data sources without GeoParquet metadata, but with an undefined CRS since the metadata's CRS does not apply.
+
+		slog.Warn("GeoParquet metadata does not define geometry column, assuming WKB encoding with an unknown CRS", "column", name)
+
+		col_md = &GeoColumnMetadata{
+			Encoding: default_geometry_encoding,
+			CRS:      json.RawMessage("null"),
+		}
+	}
+
+	return name, col_md, nil
+}
+
+// quoteColumnPath returns a SQL identifier for 'path' with each element double-quoted and joined by ".".
+func quoteColumnPath(path []string) string {
+
+	quoted := make([]string, len(path))
+
+	for idx, p := range path {
+		quoted[idx] = fmt.Sprintf(`"%s"`, p)
+	}
+
+	return strings.Join(quoted, ".")
+}
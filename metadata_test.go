@@ -0,0 +1,135 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+func TestGeometryColumn(t *testing.T) {
+
+	str_md := `{"version":"1.1.0","primary_column":"geom","columns":{"geom":{"encoding":"WKB","geometry_types":["Polygon","MultiPolygon"]},"centroid":{"encoding":"WKB","geometry_types":["Point"]}}}`
+
+	var md *GeoMetadata
+
+	err := json.Unmarshal([]byte(str_md), &md)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal metadata, %v", err)
+	}
+
+	tests := map[string]string{
+		"":         "geom",
+		"centroid": "centroid",
+	}
+
+	for name, expected := range tests {
+
+		col, col_md, err := md.GeometryColumn(name)
+
+		if err != nil {
+			t.Fatalf("Failed to derive geometry column for '%s', %v", name, err)
+		}
+
+		if col != expected {
+			t.Fatalf("Unexpected geometry column for '%s': %s", name, col)
+		}
+
+		if col_md.Encoding != "WKB" {
+			t.Fatalf("Unexpected encoding for '%s': %s", name, col_md.Encoding)
+		}
+	}
+
+	// Columns not defined by the metadata are assumed to be WKB-encoded with an unknown CRS
+
+	col, col_md, err := md.GeometryColumn("wkb_geometry")
+
+	if err != nil {
+		t.Fatalf("Failed to derive undefined geometry column, %v", err)
+	}
+
+	if col != "wkb_geometry" || col_md.Encoding != "WKB" {
+		t.Fatalf("Unexpected geometry column for undefined column: %s (%s)", col, col_md.Encoding)
+	}
+
+	crs, err := col_md.SourceCRS()
+
+	if err != nil || crs != "" {
+		t.Fatalf("Expected undefined geometry column to have an unknown CRS: '%s' %v", crs, err)
+	}
+
+	var no_md *GeoMetadata
+
+	col, _, err = no_md.GeometryColumn("")
+
+	if err != nil {
+		t.Fatalf("Failed to derive default geometry column, %v", err)
+	}
+
+	if col != "geometry" {
+		t.Fatalf("Unexpected default geometry column: %s", col)
+	}
+}
+
+func TestReadGeoMetadata(t *testing.T) {
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	md, err := ReadGeoMetadata(context.Background(), db, countries_fixture)
+
+	if err != nil {
+		t.Fatalf("Failed to read GeoParquet metadata, %v", err)
+	}
+
+	col, col_md, err := md.GeometryColumn("")
+
+	if err != nil {
+		t.Fatalf("Failed to derive geometry column, %v", err)
+	}
+
+	if col != "geometry" || col_md.Encoding != "WKB" || len(col_md.Bbox) != 4 {
+		t.Fatalf("Unexpected metadata for geometry column '%s': %v", col, col_md)
+	}
+}
+
+func TestSourceCRS(t *testing.T) {
+
+	tests := map[string]string{
+		`{"encoding":"WKB"}`:            "",
+		`{"encoding":"WKB","crs":null}`: "",
+		`{"encoding":"WKB","crs":{"id":{"authority":"OGC","code":"CRS84"}}}`: "",
+		`{"encoding":"WKB","crs":{"id":{"authority":"EPSG","code":4326}}}`:   "",
+		`{"encoding":"WKB","crs":{"id":{"authority":"EPSG","code":2227}}}`:   "EPSG:2227",
+		`{"encoding":"WKB","crs":{"type":"ProjectedCRS","name":"Custom"}}`:   `{"type":"ProjectedCRS","name":"Custom"}`,
+	}
+
+	for str_md, expected := range tests {
+
+		var col_md *GeoColumnMetadata
+
+		err := json.Unmarshal([]byte(str_md), &col_md)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal '%s', %v", str_md, err)
+		}
+
+		crs, err := col_md.SourceCRS()
+
+		if err != nil {
+			t.Fatalf("Failed to derive source CRS for '%s', %v", str_md, err)
+		}
+
+		if crs != expected {
+			t.Fatalf("Unexpected source CRS for '%s': '%s'", str_md, crs)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 
+	"github.com/paulmach/orb"
 	"github.com/sfomuseum/go-flags/flagset"
 	www_show "github.com/sfomuseum/go-www-show/v2"
 )
@@ -14,8 +15,52 @@ import (
 type RunOptions struct {
 	// A valid `sql.DB` (DuckDB) instance to use for querying data
 	Database *sql.DB
-	// The URI of the GeoParquet data. Specifically, the value passed to the DuckDB read_parquet() function.
+	// The URI of the GeoParquet data. Specifically, the value passed to the DuckDB read_parquet() function. If set it will be served as a layer named "all".
+	// Multiple files may be read using a glob pattern or a comma-separated list of URIs. Local files ending in ".pmtiles" are served directly from the PMTiles archive.
+	// Note that `RunOptionsFromFlagSet` assigns the -data-source (or -query) flag to an "all" layer in 'Layers' rather than this property.
 	Datasource string
+	// An optional SQL SELECT statement to use as a data source instead of 'Datasource'. If set it will be served as a layer named "all".
+	Query string
+	// Zero or more additional named data sources to serve as separate vector tile layers.
+	Layers []*Layer
+	// Read Hive-style partition values (for example "region=us") from the paths of files as columns.
+	HivePartitioning bool
+	// Combine the columns of multiple files by name, rather than by position, allowing files to have different schemas.
+	UnionByName bool
+	// Assign the path of the file each feature was read from to a "filename" property.
+	FilenameProperty bool
+	// The name of the column containing geometries. If empty the primary column defined in the GeoParquet metadata will be used.
+	GeometryColumn string
+	// The optional CRS of the geometries in the data source (for example "EPSG:2227"). If empty the CRS defined in the GeoParquet metadata will be used.
+	// Geometries that are not already in WGS84 (EPSG:4326) will be transformed on the fly.
+	SourceCRS string
+	// The strategy for handling GEOMETRYCOLLECTION geometries. Valid options are: explode (one feature per member), merge (one Multi* feature per geometry type). If empty "explode" is assumed.
+	GeometryCollectionStrategy string
+	// The optional name of a column whose (distinct) values will be used to partition the features in each layer in to separate (vector tile) layers.
+	LayerBy string
+	// The optional name of a column uniquely identifying each feature, used to retrieve complete features by ID. It is always included in tiles.
+	IdColumn string
+	// The optional name of a numeric column containing the minimum zoom level at which each feature should be displayed.
+	MinZoomColumn string
+	// Features in tiles below this zoom level are grouped in to clusters. If 0 features are not clustered.
+	ClusterMaxZoom int
+	// The size, in pixels, of the grid cells used to cluster features. It must evenly divide the tile size (256 pixels). If 0 the default size (64 pixels) is used.
+	ClusterGridSize int
+	// Zero or more aggregate functions to apply to the features in each cluster.
+	ClusterAggregates []*Aggregate
+	// The shape of the cells used to aggregate features in an additional "{LAYER_NAME}-bins" layer for each layer. Valid options are: square, hex.
+	// If empty no bins layers are served.
+	BinShape string
+	// The width, in pixels, of the cells used to aggregate features in to bins. If 0 the default size (32 pixels) is used.
+	BinSize int
+	// Zero or more aggregate functions to apply to the features in each bin.
+	BinAggregates []*Aggregate
+	// The maximum number of features to include in each tile. If 0 there is no limit.
+	MaxFeaturesPerTile int
+	// An optional SQL ORDER BY expression used to sort features before 'MaxFeaturesPerTile' is applied, for example "ST_Area(geometry) DESC".
+	OrderBy string
+	// An optional SQL boolean expression used to filter the features in each layer. Layers which define their own 'Where' property will use that instead.
+	Where string
 	// The port number to listen for requests on (on localhost). If 0 then a random port number will be chosen.
 	Port int
 	// Enable verbose (debug) logging.
@@ -26,10 +71,63 @@ type RunOptions struct {
 	LabelProperties []string
 	// Which vector tile renderer to use. Valid options are: leaflet, maplibre.
 	Renderer string `json:"renderer"`
+	// An option column name to use for a initial bounding box constraint. This columns is expected to contain the minimum X (longitude) value of the geometry it is associated with.
+	MinXColumn string
+	// An option column name to use for a initial bounding box constraint. This columns is expected to contain the minimum Y (latitude) value of the geometry it is associated with.
+	MinYColumn string
 	// An option column name to use for a initial bounding box constraint. This columns is expected to contain the maximum X (longitude) value of the geometry it is associated with.
 	MaxXColumn string
 	// An option column name to use for a initial bounding box constraint. This columns is expected to contain the maximum Y (latitude) value of the geometry it is associated with.
 	MaxYColumn string
+	// Zero or more glob patterns that column names must match in order to be assigned as (GeoJSON Feature) properties. If empty all columns are included.
+	IncludeProperties []string
+	// Zero or more glob patterns for column names to exclude from (GeoJSON Feature) properties.
+	ExcludeProperties []string
+	// Zero or more rules further limiting the properties included for tiles below a given zoom level.
+	ZoomProperties []*ZoomPropertyRule
+	// Remove properties whose value is NULL from features.
+	DropNullProperties bool
+	// If greater than zero, string property values longer than this number of characters are truncated.
+	MaxStringLength int
+	// The maximum number of bytes of (encoded) tiles to cache in memory. If 0 tiles are not cached in memory.
+	TileCacheSize int64
+	// An optional path to a directory where (encoded) tiles will be cached on disk.
+	TileCacheDirectory string
+	// The maximum number of bytes of (encoded) tiles to cache in 'TileCacheDirectory'. If 0 there is no limit.
+	TileCacheDirectorySize int64
+	// An optional range of zoom levels for which tiles are produced, and stored in the tile cache, before serving requests. If nil
+	// the tile cache is not seeded.
+	SeedZooms *ZoomRange
+	// An optional bounding box to limit the tiles produced when seeding the tile cache to. If nil the extent of each layer is used.
+	SeedBound *orb.Bound
+	// The number of tiles to produce in parallel when seeding the tile cache. If 0 the number of CPUs is used.
+	SeedWorkers int
+	// Load the GeoParquet data in to a native (DuckDB) table, with an R-tree index, at startup rather than reading the GeoParquet data for every tile request.
+	Materialize bool
+	// An optional path to a directory where materialized tables will be persisted between restarts. Only used if 'Materialize' is true.
+	MaterializeCache string
+	// An optional path to a (JSON) file defining layers, in addition to 'Layers', which is read every time the tileset is set up. See `LayersConfig`.
+	ConfigFile string
+	// Watch (local) data source files and rebuild the tileset, clear any cached tiles and notify (browser) clients when they change.
+	Reload bool
+	// An optional path to a directory where DuckDB extensions are installed. If empty the DuckDB default is used.
+	ExtensionDirectory string
+	// An optional path to a DuckDB spatial extension (".duckdb_extension") file to load instead of installing the extension.
+	SpatialExtension string
+	// An optional path to a DuckDB sqlite extension (".duckdb_extension") file to load, when writing MBTiles databases, instead of installing the extension.
+	SQLiteExtension string
+}
+
+// extensionOptions returns the `ExtensionOptions` used to load DuckDB extensions for 'opts'.
+func extensionOptions(opts *RunOptions) *ExtensionOptions {
+
+	ext_opts := &ExtensionOptions{
+		Directory:        opts.ExtensionDirectory,
+		SpatialExtension: opts.SpatialExtension,
+		SQLiteExtension:  opts.SQLiteExtension,
+	}
+
+	return ext_opts
 }
 
 // Derive a new `RunOptions` instance from 'fs'.
@@ -37,10 +135,10 @@ func RunOptionsFromFlagSet(ctx context.Context, fs *flag.FlagSet) (*RunOptions,
 
 	flagset.Parse(fs)
 
-	db, err := sql.Open(db_engine, "")
+	opts, err := runOptionsFromFlags(ctx)
 
 	if err != nil {
-		return nil, fmt.Errorf("Failed to open database, %w", err)
+		return nil, err
 	}
 
 	browser, err := www_show.NewBrowser(ctx, browser_uri)
@@ -49,16 +147,237 @@ func RunOptionsFromFlagSet(ctx context.Context, fs *flag.FlagSet) (*RunOptions,
 		return nil, fmt.Errorf("Failed to create new browser, %w", err)
 	}
 
+	opts.Browser = browser
+	return opts, nil
+}
+
+// Derive a new `ExportOptions` instance from 'fs' after parsing 'args'.
+func ExportOptionsFromFlagSet(ctx context.Context, fs *flag.FlagSet, args []string) (*ExportOptions, error) {
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse flags, %w", err)
+	}
+
+	run_opts, err := runOptionsFromFlags(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &ExportOptions{
+		RunOptions: run_opts,
+		Output:     export_output,
+		Format:     export_format,
+		Name:       export_name,
+		MinZoom:    export_min_zoom,
+		MaxZoom:    export_max_zoom,
+		Workers:    export_workers,
+	}
+
+	return opts, nil
+}
+
+// runOptionsFromFlags derives a new `RunOptions` instance from the (package-level) variables assigned by a flag set that has
+// already been parsed. The 'Browser' property is not assigned.
+func runOptionsFromFlags(ctx context.Context) (*RunOptions, error) {
+
+	db, err := sql.Open(db_engine, "")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open database, %w", err)
+	}
+
+	layers := make([]*Layer, 0)
+
+	if data_source != "" || query != "" {
+
+		if data_source != "" && query != "" {
+			return nil, fmt.Errorf("-data-source and -query flags are mutually exclusive")
+		}
+
+		l := &Layer{
+			Name:       default_layer_name,
+			Datasource: data_source,
+			Query:      query,
+		}
+
+		layers = append(layers, l)
+	}
+
+	for _, str_layer := range layer_uris {
+
+		l, err := ParseLayer(str_layer)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse layer '%s', %w", str_layer, err)
+		}
+
+		layers = append(layers, l)
+	}
+
+	// START OF per-layer options
+
+	for _, str_where := range layer_where {
+
+		l, where, err := lookupLayerOption(layers, str_where)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -layer-where flag '%s', %w", str_where, err)
+		}
+
+		l.Where = where
+	}
+
+	for _, str_zoom := range layer_min_zoom {
+
+		l, value, err := lookupLayerOption(layers, str_zoom)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -layer-min-zoom flag '%s', %w", str_zoom, err)
+		}
+
+		zoom, err := parseZoomLevel(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -layer-min-zoom flag '%s', %w", str_zoom, err)
+		}
+
+		l.MinZoom = zoom
+	}
+
+	for _, str_zoom := range layer_max_zoom {
+
+		l, value, err := lookupLayerOption(layers, str_zoom)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -layer-max-zoom flag '%s', %w", str_zoom, err)
+		}
+
+		zoom, err := parseZoomLevel(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -layer-max-zoom flag '%s', %w", str_zoom, err)
+		}
+
+		l.MaxZoom = zoom
+	}
+
+	// END OF per-layer options
+
+	zoom_properties := make([]*ZoomPropertyRule, 0)
+
+	for _, str_rule := range zoom_property {
+
+		r, err := ParseZoomPropertyRule(str_rule)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -zoom-property flag '%s', %w", str_rule, err)
+		}
+
+		zoom_properties = append(zoom_properties, r)
+	}
+
+	cluster_aggregates := make([]*Aggregate, 0)
+
+	for _, str_agg := range cluster_aggregate {
+
+		a, err := ParseAggregate(str_agg)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -cluster-aggregate flag '%s', %w", str_agg, err)
+		}
+
+		cluster_aggregates = append(cluster_aggregates, a)
+	}
+
+	bin_aggregates := make([]*Aggregate, 0)
+
+	for _, str_agg := range bin_aggregate {
+
+		a, err := ParseAggregate(str_agg)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -bin-aggregate flag '%s', %w", str_agg, err)
+		}
+
+		bin_aggregates = append(bin_aggregates, a)
+	}
+
+	var seed_zooms *ZoomRange
+
+	if seed_zoom_range != "" {
+
+		r, err := ParseZoomRange(seed_zoom_range)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -seed-zooms flag '%s', %w", seed_zoom_range, err)
+		}
+
+		seed_zooms = r
+	}
+
+	var seed_bound *orb.Bound
+
+	if seed_bbox != "" {
+
+		b, err := ParseBound(seed_bbox)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid -seed-bbox flag '%s', %w", seed_bbox, err)
+		}
+
+		seed_bound = b
+	}
+
 	opts := &RunOptions{
-		Database:        db,
-		Datasource:      data_source,
-		Port:            port,
-		Verbose:         verbose,
-		Browser:         browser,
-		LabelProperties: label_properties,
-		Renderer:        renderer,
-		MaxXColumn:      max_x_column,
-		MaxYColumn:      max_y_column,
+		Database:                   db,
+		Layers:                     layers,
+		Where:                      where,
+		HivePartitioning:           hive_partitioning,
+		UnionByName:                union_by_name,
+		FilenameProperty:           filename_property,
+		GeometryColumn:             geometry_column,
+		SourceCRS:                  source_crs,
+		GeometryCollectionStrategy: geometry_collection_strategy,
+		LayerBy:                    layer_by,
+		MinZoomColumn:              minzoom_column,
+		IdColumn:                   id_column,
+		ClusterMaxZoom:             cluster_max_zoom,
+		ClusterGridSize:            cluster_grid_size,
+		ClusterAggregates:          cluster_aggregates,
+		BinShape:                   bin_shape,
+		BinSize:                    bin_size,
+		BinAggregates:              bin_aggregates,
+		MaxFeaturesPerTile:         max_features_per_tile,
+		OrderBy:                    order_by,
+		Port:                       port,
+		Verbose:                    verbose,
+		LabelProperties:            label_properties,
+		Renderer:                   renderer,
+		MinXColumn:                 min_x_column,
+		MinYColumn:                 min_y_column,
+		MaxXColumn:                 max_x_column,
+		MaxYColumn:                 max_y_column,
+		IncludeProperties:          include_property,
+		ExcludeProperties:          exclude_property,
+		ZoomProperties:             zoom_properties,
+		DropNullProperties:         drop_null_properties,
+		MaxStringLength:            max_string_length,
+		TileCacheSize:              int64(tile_cache_size) * 1024 * 1024,
+		TileCacheDirectory:         tile_cache_directory,
+		TileCacheDirectorySize:     int64(tile_cache_directory_size) * 1024 * 1024,
+		SeedZooms:                  seed_zooms,
+		SeedBound:                  seed_bound,
+		SeedWorkers:                seed_workers,
+		Materialize:                materialize,
+		MaterializeCache:           materialize_cache,
+		ConfigFile:                 config_file,
+		Reload:                     reload,
+		ExtensionDirectory:         extension_directory,
+		SpatialExtension:           spatial_extension_path,
+		SQLiteExtension:            sqlite_extension_path,
 	}
 
 	return opts, nil
@@ -0,0 +1,83 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ValidateOrderBy ensures that 'order_by' is a valid SQL ORDER BY expression (for example "ST_Area(geometry) DESC") for the data in 'from'.
+func ValidateOrderBy(ctx context.Context, db *sql.DB, from string, order_by string) error {
+
+	q := fmt.Sprintf(`DESCRIBE SELECT 1 FROM %s ORDER BY %s`, from, order_by)
+
+	rows, err := db.QueryContext(ctx, q)
+
+	if err != nil {
+		return fmt.Errorf("Invalid ORDER BY expression, %w", err)
+	}
+
+	return rows.Close()
+}
+
+// orderTiebreakers returns the expressions used to order the features in a tile which are equal according to 'opts.OrderBy', or all
+// the features if it is empty, so that tiles truncated to 'opts.MaxFeatures' always contain the same features. Features are ordered by
+// 'opts.IdColumn' if set, otherwise by their row ID in materialized tables or their position in the file they were read from. If none
+// of these are available features are ordered by each of the 'num_cols' columns they are queried with, by position.
+func orderTiebreakers(opts *GetFeaturesForTileFuncOptions, num_cols int) []string {
+
+	if opts.IdColumn != "" {
+		return []string{fmt.Sprintf(`"%s"`, opts.IdColumn)}
+	}
+
+	if opts.Table != "" {
+		return []string{"rowid"}
+	}
+
+	if opts.Query == "" && opts.ReadOptions != nil && opts.ReadOptions.FileRowNumber {
+
+		tiebreakers := []string{fmt.Sprintf(`"%s"`, file_row_number_column)}
+
+		if opts.ReadOptions.Filename {
+			tiebreakers = []string{fmt.Sprintf(`"%s"`, filename_column), tiebreakers[0]}
+		}
+
+		return tiebreakers
+	}
+
+	tiebreakers := make([]string, num_cols)
+
+	for i := 0; i < num_cols; i++ {
+		tiebreakers[i] = strconv.Itoa(i + 1)
+	}
+
+	return tiebreakers
+}
+
+// orderByClause returns a SQL ORDER BY clause (including the "ORDER BY" keyword) for 'order_by', followed by 'tiebreakers', and a
+// LIMIT clause for 'limit'. The ORDER BY clause is omitted if both 'order_by' and 'tiebreakers' are empty and the LIMIT clause is
+// omitted if 'limit' is less than 1.
+func orderByClause(order_by string, tiebreakers []string, limit int) string {
+
+	terms := make([]string, 0)
+
+	if order_by != "" {
+		terms = append(terms, order_by)
+	}
+
+	terms = append(terms, tiebreakers...)
+
+	clause := ""
+
+	if len(terms) > 0 {
+		clause = fmt.Sprintf(" ORDER BY %s", strings.Join(terms, ", "))
+	}
+
+	if limit > 0 {
+		clause = fmt.Sprintf("%s LIMIT %d", clause, limit)
+	}
+
+	return clause
+}
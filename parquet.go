@@ -0,0 +1,88 @@
+package show
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The name of the column, added by the DuckDB `read_parquet` function, containing the path of the file each row was read from.
+const filename_column string = "filename"
+
+// The name of the column, added by the DuckDB `read_parquet` function, containing the (zero-based) position of each row in its file.
+const file_row_number_column string = "file_row_number"
+
+// ParquetReadOptions defines options passed to the DuckDB `read_parquet` function when reading GeoParquet data.
+type ParquetReadOptions struct {
+	// Read Hive-style partition values (for example "region=us") from the paths of files as columns.
+	HivePartitioning bool `json:"hive_partitioning,omitempty"`
+	// Combine the columns of multiple files by name, rather than by position, allowing files to have different schemas.
+	UnionByName bool `json:"union_by_name,omitempty"`
+	// Add a "filename" column containing the path of the file each row was read from.
+	Filename bool `json:"filename,omitempty"`
+	// Add a "file_row_number" column containing the position of each row in the file it was read from.
+	FileRowNumber bool `json:"file_row_number,omitempty"`
+}
+
+// datasourcePaths returns the list of URIs (or glob patterns) in 'datasource' which may be a single URI or a comma-separated list of URIs.
+func datasourcePaths(datasource string) []string {
+
+	paths := make([]string, 0)
+
+	for _, p := range strings.Split(datasource, ",") {
+
+		p = strings.TrimSpace(p)
+
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+// parquetFilesArgument returns the SQL for the URI, or list of URIs, in 'datasource' to pass as the first argument to the
+// DuckDB `read_parquet`, `parquet_metadata` and `parquet_kv_metadata` functions.
+func parquetFilesArgument(datasource string) string {
+
+	paths := datasourcePaths(datasource)
+	quoted := make([]string, len(paths))
+
+	for idx, p := range paths {
+		quoted[idx] = fmt.Sprintf(`'%s'`, strings.ReplaceAll(p, "'", "''"))
+	}
+
+	if len(quoted) == 1 {
+		return quoted[0]
+	}
+
+	return fmt.Sprintf(`[%s]`, strings.Join(quoted, ", "))
+}
+
+// readParquetClause returns the SQL to read 'datasource' using the DuckDB `read_parquet` function with 'read_opts', which may be nil.
+func readParquetClause(datasource string, read_opts *ParquetReadOptions) string {
+
+	args := []string{
+		parquetFilesArgument(datasource),
+	}
+
+	if read_opts != nil {
+
+		if read_opts.HivePartitioning {
+			args = append(args, "hive_partitioning = true")
+		}
+
+		if read_opts.UnionByName {
+			args = append(args, "union_by_name = true")
+		}
+
+		if read_opts.Filename {
+			args = append(args, "filename = true")
+		}
+
+		if read_opts.FileRowNumber {
+			args = append(args, "file_row_number = true")
+		}
+	}
+
+	return fmt.Sprintf(`read_parquet(%s)`, strings.Join(args, ", "))
+}
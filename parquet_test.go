@@ -0,0 +1,162 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestReadParquetClause(t *testing.T) {
+
+	tests := map[string]string{
+		"example.parquet":              `read_parquet('example.parquet')`,
+		"data/region=*/part-*.parquet": `read_parquet('data/region=*/part-*.parquet')`,
+		`o'hare "sfo".parquet`:         `read_parquet('o''hare "sfo".parquet')`,
+		"a.parquet, b.parquet":         `read_parquet(['a.parquet', 'b.parquet'])`,
+		"a.parquet,o'hare.parquet,":    `read_parquet(['a.parquet', 'o''hare.parquet'])`,
+	}
+
+	for datasource, expected := range tests {
+
+		clause := readParquetClause(datasource, nil)
+
+		if clause != expected {
+			t.Fatalf("Unexpected clause for '%s': %s", datasource, clause)
+		}
+	}
+
+	read_opts := &ParquetReadOptions{
+		HivePartitioning: true,
+		UnionByName:      true,
+		Filename:         true,
+	}
+
+	clause := readParquetClause("data/*.parquet", read_opts)
+	expected := `read_parquet('data/*.parquet', hive_partitioning = true, union_by_name = true, filename = true)`
+
+	if clause != expected {
+		t.Fatalf("Unexpected clause with read options: %s", clause)
+	}
+}
+
+func TestParquetFilesArgument(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	fixture, err := os.ReadFile(countries_fixture)
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	// Paths containing quotes are read the same way whether they are a single path or part of a list
+
+	path := filepath.Join(t.TempDir(), `o'hare "sfo".parquet`)
+
+	err = os.WriteFile(path, fixture, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %v", path, err)
+	}
+
+	for datasource, expected := range map[string]int{path: 177, path + "," + path: 354} {
+
+		var count int
+
+		err := db.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %s`, readParquetClause(datasource, nil))).Scan(&count)
+
+		if err != nil {
+			t.Fatalf("Failed to read '%s', %v", datasource, err)
+		}
+
+		if count != expected {
+			t.Fatalf("Unexpected count for '%s': %d", datasource, count)
+		}
+	}
+}
+
+func TestSetupLayerHivePartitions(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+
+	// The countries fixture split in to one file for each hemisphere, in directories named "hemisphere=east" and "hemisphere=west"
+
+	root := countriesDerivedFixture(t, opts.Database, "countries",
+		`SELECT id, name, geometry, CASE WHEN ST_XMax(geom) < 0 THEN 'west' ELSE 'east' END AS hemisphere FROM countries`, ", PARTITION_BY (hemisphere)")
+
+	run_opts := &RunOptions{
+		Database:         opts.Database,
+		HivePartitioning: true,
+	}
+
+	layer := &Layer{
+		Name:       "countries",
+		Datasource: filepath.Join(root, "*", "*.parquet"),
+	}
+
+	features_opts, _, err := setupLayer(ctx, run_opts, 1, 0, layer)
+
+	if err != nil {
+		t.Fatalf("Failed to set up layer, %v", err)
+	}
+
+	cb := GetFeaturesForTileFunc(features_opts)
+
+	// The partitioned files must yield the same features as the fixture, with the partition as a property
+
+	for _, tile := range countriesTiles() {
+
+		expected := tileFeatureIds(t, GetFeaturesForTileFunc(opts), uint32(tile.Z), tile.X, tile.Y)
+		ids := tileFeatureIds(t, cb, uint32(tile.Z), tile.X, tile.Y)
+
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("Unexpected features for tile %d/%d/%d: %v, expected %v", tile.Z, tile.X, tile.Y, ids, expected)
+		}
+	}
+
+	// The tile containing the south-western United States and Mexico
+
+	tile := maptile.New(1, 3, 3)
+
+	collections, err := cb(ctx, "countries", &tile)
+
+	if err != nil {
+		t.Fatalf("Failed to get features for tile, %v", err)
+	}
+
+	found := false
+
+	for _, f := range collections["countries"].Features {
+
+		if f.Properties["id"] != "MEX" {
+			continue
+		}
+
+		found = true
+
+		if f.Properties["hemisphere"] != "west" {
+			t.Fatalf("Unexpected hemisphere for Mexico: %v", f.Properties["hemisphere"])
+		}
+	}
+
+	if !found {
+		t.Fatalf("Expected tile to contain Mexico")
+	}
+}
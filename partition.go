@@ -0,0 +1,80 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// The maximum number of distinct values a column may have in order to be used to partition features in to layers.
+const max_partitions int = 100
+
+// PartitionName returns the name of the (vector tile) layer that features whose partitioning column contains 'value'
+// are assigned to. If 'value' is nil then 'default_name' is returned.
+func PartitionName(value any, default_name string) string {
+
+	if value == nil {
+		return default_name
+	}
+
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// DerivePartitions returns the sorted list of (vector tile) layer names for the distinct values of 'col' in 'from', optionally
+// filtered by the SQL boolean expression 'where'.
+// Features with a NULL value for 'col' are assigned to 'default_name'. An error is returned if there are more than
+// 100 distinct values.
+func DerivePartitions(ctx context.Context, db *sql.DB, from string, where string, col string, default_name string) ([]string, error) {
+
+	q := fmt.Sprintf(`SELECT DISTINCT "%s" FROM %s%s LIMIT %d`, col, from, whereClause(where), max_partitions+1)
+
+	rows, err := db.QueryContext(ctx, q)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query distinct values for '%s', %w", col, err)
+	}
+
+	defer rows.Close()
+
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for rows.Next() {
+
+		var value any
+
+		err := rows.Scan(&value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to scan distinct value, %w", err)
+		}
+
+		name := PartitionName(value, default_name)
+
+		if seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return nil, fmt.Errorf("There was a problem scanning distinct values, %w", err)
+	}
+
+	if len(names) > max_partitions {
+		return nil, fmt.Errorf("Column '%s' has more than %d distinct values", col, max_partitions)
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
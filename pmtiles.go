@@ -0,0 +1,958 @@
+package show
+
+// https://github.com/protomaps/PMTiles/blob/main/spec/v3/spec.md
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+)
+
+// The length, in bytes, of a PMTiles (v3) header.
+const pmtiles_header_length int = 127
+
+// The maximum length, in bytes, of the header and root directory of a PMTiles archive.
+const pmtiles_root_length int = 16384
+
+// Compression types defined by the PMTiles specification.
+const (
+	pmtiles_compression_none uint8 = 1
+	pmtiles_compression_gzip uint8 = 2
+)
+
+// The PMTiles tile type for Mapbox Vector Tiles.
+const pmtiles_tile_type_mvt uint8 = 1
+
+// The maximum number of directories which are traversed to find a tile. The PMTiles specification allows at most
+// three (the root directory and two levels of leaf directories).
+const pmtiles_max_depth int = 3
+
+// The maximum number of (decoded) leaf directories to keep in memory for each PMTiles archive.
+const pmtiles_max_leaves int = 64
+
+// IsPMTiles reports whether 'datasource' is a (local) PMTiles archive, rather than data to be read by DuckDB, as determined by its extension.
+func IsPMTiles(datasource string) bool {
+	return strings.HasSuffix(strings.ToLower(datasource), ".pmtiles") && !strings.Contains(datasource, "://")
+}
+
+// pmtilesHeader defines the (fixed-length) header of a PMTiles (v3) archive.
+type pmtilesHeader struct {
+	RootOffset          uint64
+	RootLength          uint64
+	MetadataOffset      uint64
+	MetadataLength      uint64
+	LeafOffset          uint64
+	LeafLength          uint64
+	DataOffset          uint64
+	DataLength          uint64
+	AddressedTiles      uint64
+	TileEntries         uint64
+	TileContents        uint64
+	Clustered           bool
+	InternalCompression uint8
+	TileCompression     uint8
+	TileType            uint8
+	MinZoom             uint8
+	MaxZoom             uint8
+	MinLon              int32
+	MinLat              int32
+	MaxLon              int32
+	MaxLat              int32
+	CenterZoom          uint8
+	CenterLon           int32
+	CenterLat           int32
+}
+
+// Bytes returns the binary encoding of 'h'.
+func (h *pmtilesHeader) Bytes() []byte {
+
+	b := make([]byte, pmtiles_header_length)
+
+	copy(b[0:7], "PMTiles")
+	b[7] = 3
+
+	binary.LittleEndian.PutUint64(b[8:], h.RootOffset)
+	binary.LittleEndian.PutUint64(b[16:], h.RootLength)
+	binary.LittleEndian.PutUint64(b[24:], h.MetadataOffset)
+	binary.LittleEndian.PutUint64(b[32:], h.MetadataLength)
+	binary.LittleEndian.PutUint64(b[40:], h.LeafOffset)
+	binary.LittleEndian.PutUint64(b[48:], h.LeafLength)
+	binary.LittleEndian.PutUint64(b[56:], h.DataOffset)
+	binary.LittleEndian.PutUint64(b[64:], h.DataLength)
+	binary.LittleEndian.PutUint64(b[72:], h.AddressedTiles)
+	binary.LittleEndian.PutUint64(b[80:], h.TileEntries)
+	binary.LittleEndian.PutUint64(b[88:], h.TileContents)
+
+	if h.Clustered {
+		b[96] = 1
+	}
+
+	b[97] = h.InternalCompression
+	b[98] = h.TileCompression
+	b[99] = h.TileType
+	b[100] = h.MinZoom
+	b[101] = h.MaxZoom
+
+	binary.LittleEndian.PutUint32(b[102:], uint32(h.MinLon))
+	binary.LittleEndian.PutUint32(b[106:], uint32(h.MinLat))
+	binary.LittleEndian.PutUint32(b[110:], uint32(h.MaxLon))
+	binary.LittleEndian.PutUint32(b[114:], uint32(h.MaxLat))
+
+	b[118] = h.CenterZoom
+
+	binary.LittleEndian.PutUint32(b[119:], uint32(h.CenterLon))
+	binary.LittleEndian.PutUint32(b[123:], uint32(h.CenterLat))
+
+	return b
+}
+
+// parsePMTilesHeader parses 'b', the first 127 bytes of a PMTiles archive, in to a `pmtilesHeader` instance.
+func parsePMTilesHeader(b []byte) (*pmtilesHeader, error) {
+
+	if len(b) < pmtiles_header_length || string(b[0:7]) != "PMTiles" {
+		return nil, fmt.Errorf("Invalid PMTiles header")
+	}
+
+	if b[7] != 3 {
+		return nil, fmt.Errorf("Unsupported PMTiles version (%d)", b[7])
+	}
+
+	h := &pmtilesHeader{
+		RootOffset:          binary.LittleEndian.Uint64(b[8:]),
+		RootLength:          binary.LittleEndian.Uint64(b[16:]),
+		MetadataOffset:      binary.LittleEndian.Uint64(b[24:]),
+		MetadataLength:      binary.LittleEndian.Uint64(b[32:]),
+		LeafOffset:          binary.LittleEndian.Uint64(b[40:]),
+		LeafLength:          binary.LittleEndian.Uint64(b[48:]),
+		DataOffset:          binary.LittleEndian.Uint64(b[56:]),
+		DataLength:          binary.LittleEndian.Uint64(b[64:]),
+		AddressedTiles:      binary.LittleEndian.Uint64(b[72:]),
+		TileEntries:         binary.LittleEndian.Uint64(b[80:]),
+		TileContents:        binary.LittleEndian.Uint64(b[88:]),
+		Clustered:           b[96] == 1,
+		InternalCompression: b[97],
+		TileCompression:     b[98],
+		TileType:            b[99],
+		MinZoom:             b[100],
+		MaxZoom:             b[101],
+		MinLon:              int32(binary.LittleEndian.Uint32(b[102:])),
+		MinLat:              int32(binary.LittleEndian.Uint32(b[106:])),
+		MaxLon:              int32(binary.LittleEndian.Uint32(b[110:])),
+		MaxLat:              int32(binary.LittleEndian.Uint32(b[114:])),
+		CenterZoom:          b[118],
+		CenterLon:           int32(binary.LittleEndian.Uint32(b[119:])),
+		CenterLat:           int32(binary.LittleEndian.Uint32(b[123:])),
+	}
+
+	return h, nil
+}
+
+// Bound returns the bounds, in longitude and latitude, of the tiles described by 'h'.
+func (h *pmtilesHeader) Bound() orb.Bound {
+
+	return orb.Bound{
+		Min: orb.Point{float64(h.MinLon) / 10000000, float64(h.MinLat) / 10000000},
+		Max: orb.Point{float64(h.MaxLon) / 10000000, float64(h.MaxLat) / 10000000},
+	}
+}
+
+// pmtilesEntry defines an entry in a PMTiles directory. Entries with a 'RunLength' of 0 point to a leaf directory.
+type pmtilesEntry struct {
+	TileID    uint64
+	Offset    uint64
+	Length    uint32
+	RunLength uint32
+}
+
+// pmtilesTileID returns the PMTiles tile ID, the position of the tile on a Hilbert curve across all zoom levels, for tile 't'.
+func pmtilesTileID(t maptile.Tile) uint64 {
+
+	var id uint64
+
+	for z := uint64(0); z < uint64(t.Z); z++ {
+		id += 1 << (2 * z)
+	}
+
+	n := uint64(1) << t.Z
+
+	x := uint64(t.X)
+	y := uint64(t.Y)
+
+	var d uint64
+
+	for s := n / 2; s > 0; s /= 2 {
+
+		var rx uint64
+		var ry uint64
+
+		if x&s > 0 {
+			rx = 1
+		}
+
+		if y&s > 0 {
+			ry = 1
+		}
+
+		d += s * s * ((3 * rx) ^ ry)
+
+		// Rotate the quadrant
+
+		if ry == 0 {
+
+			if rx == 1 {
+				x = n - 1 - x
+				y = n - 1 - y
+			}
+
+			x, y = y, x
+		}
+	}
+
+	return id + d
+}
+
+// serializePMTilesDirectory returns the (gzip-compressed) binary encoding of 'entries', which are expected to be sorted by tile ID.
+func serializePMTilesDirectory(entries []pmtilesEntry) ([]byte, error) {
+
+	b := binary.AppendUvarint(nil, uint64(len(entries)))
+
+	var last_id uint64
+
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, e.TileID-last_id)
+		last_id = e.TileID
+	}
+
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, uint64(e.RunLength))
+	}
+
+	for _, e := range entries {
+		b = binary.AppendUvarint(b, uint64(e.Length))
+	}
+
+	// Offsets which immediately follow the previous entry are encoded as 0, otherwise as offset + 1.
+
+	for idx, e := range entries {
+
+		if idx > 0 && e.Offset == entries[idx-1].Offset+uint64(entries[idx-1].Length) {
+			b = binary.AppendUvarint(b, 0)
+		} else {
+			b = binary.AppendUvarint(b, e.Offset+1)
+		}
+	}
+
+	return gzipBytes(b)
+}
+
+// deserializePMTilesDirectory decodes 'b', an (uncompressed) PMTiles directory, in to a list of entries.
+func deserializePMTilesDirectory(b []byte) ([]pmtilesEntry, error) {
+
+	r := bytes.NewReader(b)
+
+	count, err := binary.ReadUvarint(r)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read number of entries, %w", err)
+	}
+
+	if count > uint64(len(b)) {
+		return nil, fmt.Errorf("Invalid number of entries (%d)", count)
+	}
+
+	entries := make([]pmtilesEntry, count)
+
+	var last_id uint64
+
+	for idx := range entries {
+
+		v, err := binary.ReadUvarint(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read tile ID, %w", err)
+		}
+
+		last_id += v
+		entries[idx].TileID = last_id
+	}
+
+	for idx := range entries {
+
+		v, err := binary.ReadUvarint(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read run length, %w", err)
+		}
+
+		entries[idx].RunLength = uint32(v)
+	}
+
+	for idx := range entries {
+
+		v, err := binary.ReadUvarint(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read length, %w", err)
+		}
+
+		entries[idx].Length = uint32(v)
+	}
+
+	for idx := range entries {
+
+		v, err := binary.ReadUvarint(r)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read offset, %w", err)
+		}
+
+		if v == 0 && idx > 0 {
+			entries[idx].Offset = entries[idx-1].Offset + uint64(entries[idx-1].Length)
+		} else {
+			entries[idx].Offset = v - 1
+		}
+	}
+
+	return entries, nil
+}
+
+// findPMTilesEntry returns the entry in 'entries', which are expected to be sorted by tile ID, for 'tile_id'. The entry will either
+// contain the tile or, if its 'RunLength' is 0, point to the leaf directory which may contain the tile.
+func findPMTilesEntry(entries []pmtilesEntry, tile_id uint64) (pmtilesEntry, bool) {
+
+	// Find the last entry whose tile ID is less than or equal to 'tile_id'
+
+	idx, found := slices.BinarySearchFunc(entries, tile_id, func(e pmtilesEntry, id uint64) int {
+		switch {
+		case e.TileID < id:
+			return -1
+		case e.TileID > id:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	if !found {
+
+		if idx == 0 {
+			return pmtilesEntry{}, false
+		}
+
+		idx -= 1
+	}
+
+	e := entries[idx]
+
+	if e.RunLength == 0 || tile_id < e.TileID+uint64(e.RunLength) {
+		return e, true
+	}
+
+	return pmtilesEntry{}, false
+}
+
+// buildPMTilesDirectories returns the (serialized) root directory for 'entries' and, if 'entries' will not fit in a
+// root directory, the (serialized) leaf directories that the root directory points to.
+func buildPMTilesDirectories(entries []pmtilesEntry) ([]byte, []byte, error) {
+
+	max_root := pmtiles_root_length - pmtiles_header_length
+
+	root, err := serializePMTilesDirectory(entries)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(root) <= max_root {
+		return root, nil, nil
+	}
+
+	leaf_size := max(float64(len(entries))/3500, 4096)
+
+	for {
+
+		root_entries := make([]pmtilesEntry, 0)
+		leaves := make([]byte, 0)
+
+		for i := 0; i < len(entries); i += int(leaf_size) {
+
+			leaf, err := serializePMTilesDirectory(entries[i:min(i+int(leaf_size), len(entries))])
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			root_entries = append(root_entries, pmtilesEntry{
+				TileID: entries[i].TileID,
+				Offset: uint64(len(leaves)),
+				Length: uint32(len(leaf)),
+			})
+
+			leaves = append(leaves, leaf...)
+		}
+
+		root, err := serializePMTilesDirectory(root_entries)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(root) <= max_root {
+			return root, leaves, nil
+		}
+
+		leaf_size *= 1.2
+	}
+}
+
+// pmtilesWriter implements the `tileWriter` interface for writing tiles to a PMTiles (v3) archive. Tiles are written to a
+// temporary file, in the order they are produced, and then copied to the archive, in tile ID order, when it is closed.
+// Tiles with identical contents are only stored once.
+type pmtilesWriter struct {
+	path     string
+	tmp      *os.File
+	offset   uint64
+	entries  []pmtilesEntry
+	contents map[[32]byte]uint64
+	min_zoom uint8
+	max_zoom uint8
+}
+
+// newPMTilesWriter returns a new `pmtilesWriter` instance for writing tiles to 'path', which must not already exist.
+func newPMTilesWriter(path string) (*pmtilesWriter, error) {
+
+	_, err := os.Stat(path)
+
+	if err == nil {
+		return nil, fmt.Errorf("%s already exists", path)
+	}
+
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary file, %w", err)
+	}
+
+	wr := &pmtilesWriter{
+		path:     path,
+		tmp:      tmp,
+		entries:  make([]pmtilesEntry, 0),
+		contents: make(map[[32]byte]uint64),
+		min_zoom: math.MaxUint8,
+	}
+
+	return wr, nil
+}
+
+func (wr *pmtilesWriter) WriteTile(ctx context.Context, t maptile.Tile, body []byte) error {
+
+	gz_body, err := gzipBytes(body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to compress tile, %w", err)
+	}
+
+	h := sha256.Sum256(gz_body)
+	offset, exists := wr.contents[h]
+
+	if !exists {
+
+		_, err := wr.tmp.Write(gz_body)
+
+		if err != nil {
+			return err
+		}
+
+		offset = wr.offset
+		wr.contents[h] = offset
+		wr.offset += uint64(len(gz_body))
+	}
+
+	wr.entries = append(wr.entries, pmtilesEntry{
+		TileID:    pmtilesTileID(t),
+		Offset:    offset,
+		Length:    uint32(len(gz_body)),
+		RunLength: 1,
+	})
+
+	wr.min_zoom = min(wr.min_zoom, uint8(t.Z))
+	wr.max_zoom = max(wr.max_zoom, uint8(t.Z))
+
+	return nil
+}
+
+func (wr *pmtilesWriter) Close(ctx context.Context, md *exportMetadata) error {
+
+	slices.SortFunc(wr.entries, func(a, b pmtilesEntry) int {
+		switch {
+		case a.TileID < b.TileID:
+			return -1
+		case a.TileID > b.TileID:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	// Assign the offset of each tile's contents in the archive, in the order they are first referenced, and
+	// merge consecutive tiles with the same contents in to a single (run-length encoded) entry.
+
+	entries := make([]pmtilesEntry, 0)
+	offsets := make(map[uint64]uint64)
+	order := make([]pmtilesEntry, 0)
+
+	var data_length uint64
+
+	for _, e := range wr.entries {
+
+		offset, exists := offsets[e.Offset]
+
+		if !exists {
+			offset = data_length
+			offsets[e.Offset] = offset
+			order = append(order, e)
+			data_length += uint64(e.Length)
+		}
+
+		if len(entries) > 0 {
+
+			last := &entries[len(entries)-1]
+
+			if last.Offset == offset && last.TileID+uint64(last.RunLength) == e.TileID {
+				last.RunLength += 1
+				continue
+			}
+		}
+
+		entries = append(entries, pmtilesEntry{
+			TileID:    e.TileID,
+			Offset:    offset,
+			Length:    e.Length,
+			RunLength: 1,
+		})
+	}
+
+	root, leaves, err := buildPMTilesDirectories(entries)
+
+	if err != nil {
+		return fmt.Errorf("Failed to build directories, %w", err)
+	}
+
+	enc_md, err := json.Marshal(md)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal metadata, %w", err)
+	}
+
+	gz_md, err := gzipBytes(enc_md)
+
+	if err != nil {
+		return fmt.Errorf("Failed to compress metadata, %w", err)
+	}
+
+	if len(wr.entries) == 0 {
+		wr.min_zoom = uint8(md.MinZoom)
+		wr.max_zoom = uint8(md.MaxZoom)
+	}
+
+	h := &pmtilesHeader{
+		RootOffset:          uint64(pmtiles_header_length),
+		RootLength:          uint64(len(root)),
+		MetadataOffset:      uint64(pmtiles_header_length + len(root)),
+		MetadataLength:      uint64(len(gz_md)),
+		AddressedTiles:      uint64(len(wr.entries)),
+		TileEntries:         uint64(len(entries)),
+		TileContents:        uint64(len(order)),
+		Clustered:           true,
+		InternalCompression: pmtiles_compression_gzip,
+		TileCompression:     pmtiles_compression_gzip,
+		TileType:            pmtiles_tile_type_mvt,
+		MinZoom:             wr.min_zoom,
+		MaxZoom:             wr.max_zoom,
+		MinLon:              e7(md.Bounds[0]),
+		MinLat:              e7(md.Bounds[1]),
+		MaxLon:              e7(md.Bounds[2]),
+		MaxLat:              e7(md.Bounds[3]),
+		CenterZoom:          uint8(md.Center[2]),
+		CenterLon:           e7(md.Center[0]),
+		CenterLat:           e7(md.Center[1]),
+	}
+
+	h.LeafOffset = h.MetadataOffset + h.MetadataLength
+	h.LeafLength = uint64(len(leaves))
+	h.DataOffset = h.LeafOffset + h.LeafLength
+	h.DataLength = data_length
+
+	out, err := os.Create(wr.path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create %s, %w", wr.path, err)
+	}
+
+	err = wr.writeArchive(out, h, root, gz_md, leaves, order)
+
+	if err != nil {
+		out.Close()
+		os.Remove(wr.path)
+		return err
+	}
+
+	err = out.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s, %w", wr.path, err)
+	}
+
+	wr.Abort()
+	return nil
+}
+
+// writeArchive writes the header 'h', the root directory, metadata and leaf directories followed by the contents of
+// the tiles in 'order' (read from the temporary file) to 'out'.
+func (wr *pmtilesWriter) writeArchive(out io.Writer, h *pmtilesHeader, root []byte, metadata []byte, leaves []byte, order []pmtilesEntry) error {
+
+	for _, b := range [][]byte{h.Bytes(), root, metadata, leaves} {
+
+		_, err := out.Write(b)
+
+		if err != nil {
+			return fmt.Errorf("Failed to write archive, %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+
+	for _, e := range order {
+
+		buf.Reset()
+
+		_, err := io.Copy(&buf, io.NewSectionReader(wr.tmp, int64(e.Offset), int64(e.Length)))
+
+		if err != nil {
+			return fmt.Errorf("Failed to read tile, %w", err)
+		}
+
+		_, err = out.Write(buf.Bytes())
+
+		if err != nil {
+			return fmt.Errorf("Failed to write tile, %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (wr *pmtilesWriter) Abort() {
+	wr.tmp.Close()
+	os.Remove(wr.tmp.Name())
+}
+
+// e7 returns 'v' (a longitude or latitude) multiplied by 10,000,000 and rounded to the nearest integer.
+func e7(v float64) int32 {
+	return int32(math.Round(v * 10000000))
+}
+
+// pmtilesArchive provides access to the tiles in a (local) PMTiles (v3) archive by reading byte ranges from the archive
+// as tiles are requested. It is safe for concurrent use.
+type pmtilesArchive struct {
+	Header       *pmtilesHeader
+	VectorLayers []string
+	fh           *os.File
+	root         []pmtilesEntry
+	leaves_mu    sync.Mutex
+	leaves       map[uint64][]pmtilesEntry
+}
+
+// openPMTilesArchive opens the PMTiles archive at 'path', reading its header, root directory and metadata. Only archives
+// containing (uncompressed or gzip-compressed) vector tiles are supported.
+func openPMTilesArchive(path string) (*pmtilesArchive, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	a := &pmtilesArchive{
+		fh:     fh,
+		leaves: make(map[uint64][]pmtilesEntry),
+	}
+
+	err = a.init()
+
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// init reads the header, root directory and metadata of the archive.
+func (a *pmtilesArchive) init() error {
+
+	b, err := a.read(0, uint64(pmtiles_header_length))
+
+	if err != nil {
+		return fmt.Errorf("Failed to read header, %w", err)
+	}
+
+	h, err := parsePMTilesHeader(b)
+
+	if err != nil {
+		return err
+	}
+
+	if h.TileType != pmtiles_tile_type_mvt {
+		return fmt.Errorf("Unsupported tile type (%d), only vector tiles are supported", h.TileType)
+	}
+
+	if h.TileCompression != pmtiles_compression_none && h.TileCompression != pmtiles_compression_gzip {
+		return fmt.Errorf("Unsupported tile compression (%d)", h.TileCompression)
+	}
+
+	a.Header = h
+
+	root, err := a.readDirectory(h.RootOffset, h.RootLength)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read root directory, %w", err)
+	}
+
+	a.root = root
+
+	b, err = a.read(h.MetadataOffset, h.MetadataLength)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read metadata, %w", err)
+	}
+
+	b, err = a.decompress(b)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decompress metadata, %w", err)
+	}
+
+	// Only the names of the (vector tile) layers are needed. Other properties are ignored since
+	// their types are not consistent across the tools which produce PMTiles archives.
+
+	var md struct {
+		VectorLayers []struct {
+			ID string `json:"id"`
+		} `json:"vector_layers"`
+	}
+
+	err = json.Unmarshal(b, &md)
+
+	if err != nil {
+		return fmt.Errorf("Failed to unmarshal metadata, %w", err)
+	}
+
+	a.VectorLayers = make([]string, len(md.VectorLayers))
+
+	for idx, l := range md.VectorLayers {
+		a.VectorLayers[idx] = l.ID
+	}
+
+	return nil
+}
+
+// Tile returns the (encoded, and possibly compressed) contents of tile 't', or nil if the archive does not contain 't'.
+func (a *pmtilesArchive) Tile(t maptile.Tile) ([]byte, error) {
+
+	if uint8(t.Z) < a.Header.MinZoom || uint8(t.Z) > a.Header.MaxZoom {
+		return nil, nil
+	}
+
+	tile_id := pmtilesTileID(t)
+	entries := a.root
+
+	for depth := 0; depth < pmtiles_max_depth; depth++ {
+
+		e, found := findPMTilesEntry(entries, tile_id)
+
+		if !found {
+			return nil, nil
+		}
+
+		if e.RunLength > 0 {
+			return a.read(a.Header.DataOffset+e.Offset, uint64(e.Length))
+		}
+
+		leaf, err := a.leaf(e)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read leaf directory, %w", err)
+		}
+
+		entries = leaf
+	}
+
+	return nil, fmt.Errorf("Maximum directory depth exceeded")
+}
+
+// Close closes the archive.
+func (a *pmtilesArchive) Close() error {
+	return a.fh.Close()
+}
+
+// leaf returns the (decoded) leaf directory that 'e' points to.
+func (a *pmtilesArchive) leaf(e pmtilesEntry) ([]pmtilesEntry, error) {
+
+	a.leaves_mu.Lock()
+	entries, exists := a.leaves[e.Offset]
+	a.leaves_mu.Unlock()
+
+	if exists {
+		return entries, nil
+	}
+
+	entries, err := a.readDirectory(a.Header.LeafOffset+e.Offset, uint64(e.Length))
+
+	if err != nil {
+		return nil, err
+	}
+
+	a.leaves_mu.Lock()
+	defer a.leaves_mu.Unlock()
+
+	// Rather than tracking which leaves are used least, all the leaves are discarded once the limit
+	// is reached. Leaves are small and only read once per tile request.
+
+	if len(a.leaves) >= pmtiles_max_leaves {
+		a.leaves = make(map[uint64][]pmtilesEntry)
+	}
+
+	a.leaves[e.Offset] = entries
+	return entries, nil
+}
+
+// readDirectory reads, decompresses and decodes the directory stored at 'offset'.
+func (a *pmtilesArchive) readDirectory(offset uint64, length uint64) ([]pmtilesEntry, error) {
+
+	b, err := a.read(offset, length)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = a.decompress(b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return deserializePMTilesDirectory(b)
+}
+
+// decompress decompresses 'b', a directory or metadata, using the archive's internal compression.
+func (a *pmtilesArchive) decompress(b []byte) ([]byte, error) {
+
+	switch a.Header.InternalCompression {
+	case pmtiles_compression_none:
+		return b, nil
+	case pmtiles_compression_gzip:
+
+		gz, err := gzip.NewReader(bytes.NewReader(b))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer gz.Close()
+		return io.ReadAll(gz)
+
+	default:
+		return nil, fmt.Errorf("Unsupported internal compression (%d)", a.Header.InternalCompression)
+	}
+}
+
+// read reads 'length' bytes from the archive starting at 'offset'.
+func (a *pmtilesArchive) read(offset uint64, length uint64) ([]byte, error) {
+
+	b := make([]byte, length)
+
+	_, err := a.fh.ReadAt(b, int64(offset))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// withPMTiles returns a new `http.Handler` which serves tiles for the layers in 'archives' directly from their PMTiles archive and
+// hands all other requests to 'next'. Tiles which are not contained in an archive yield an empty (204 No Content) response.
+func withPMTiles(archives map[string]*pmtilesArchive, next http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		m := re_tile_path.FindStringSubmatch(req.URL.Path)
+
+		if m == nil {
+			next.ServeHTTP(rsp, req)
+			return
+		}
+
+		a, exists := archives[m[1]]
+
+		if !exists {
+			next.ServeHTTP(rsp, req)
+			return
+		}
+
+		t, err := parseTile(m[2], m[3], m[4])
+
+		if err != nil {
+			http.Error(rsp, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := a.Tile(t)
+
+		if err != nil {
+			slog.Error("Failed to read tile from PMTiles archive", "path", req.URL.Path, "error", err)
+			http.Error(rsp, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		if body == nil {
+			rsp.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		rsp.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+
+		// Tiles are passed through as-is and decompressed by the browser.
+
+		if a.Header.TileCompression == pmtiles_compression_gzip {
+			rsp.Header().Set("Content-Encoding", "gzip")
+		}
+
+		rsp.Write(body)
+	}
+
+	return http.HandlerFunc(fn)
+}
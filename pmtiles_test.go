@@ -0,0 +1,424 @@
+package show
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestPMTilesDirectories(t *testing.T) {
+
+	// Enough entries, with non-contiguous offsets, that they will not fit in a root directory
+
+	entries := make([]pmtilesEntry, 200000)
+
+	for idx := range entries {
+
+		entries[idx] = pmtilesEntry{
+			TileID:    uint64(idx * 3),
+			Offset:    uint64(idx * 1000),
+			Length:    uint32(100 + idx%500),
+			RunLength: 1,
+		}
+	}
+
+	root, leaves, err := buildPMTilesDirectories(entries)
+
+	if err != nil {
+		t.Fatalf("Failed to build directories, %v", err)
+	}
+
+	if len(root) > pmtiles_root_length-pmtiles_header_length || len(leaves) == 0 {
+		t.Fatalf("Expected root directory with leaves, got %d bytes root and %d bytes leaves", len(root), len(leaves))
+	}
+
+	root_entries, err := deserializePMTilesDirectory(gunzip(t, root))
+
+	if err != nil {
+		t.Fatalf("Failed to deserialize root directory, %v", err)
+	}
+
+	for _, idx := range []int{0, 4095, 4096, 123457, 199999} {
+
+		e, found := findPMTilesEntry(root_entries, entries[idx].TileID)
+
+		if !found || e.RunLength != 0 {
+			t.Fatalf("Expected to find leaf for entry %d", idx)
+		}
+
+		leaf, err := deserializePMTilesDirectory(gunzip(t, leaves[e.Offset:e.Offset+uint64(e.Length)]))
+
+		if err != nil {
+			t.Fatalf("Failed to deserialize leaf directory, %v", err)
+		}
+
+		e, found = findPMTilesEntry(leaf, entries[idx].TileID)
+
+		if !found || e != entries[idx] {
+			t.Fatalf("Unexpected entry for %d: %v", idx, e)
+		}
+
+		// Tile IDs between entries are not in the directory
+
+		_, found = findPMTilesEntry(leaf, entries[idx].TileID+1)
+
+		if found {
+			t.Fatalf("Did not expect to find entry for tile ID %d", entries[idx].TileID+1)
+		}
+	}
+}
+
+func TestPMTilesArchive(t *testing.T) {
+
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "example.pmtiles")
+
+	wr, err := newPMTilesWriter(path)
+
+	if err != nil {
+		t.Fatalf("Failed to create writer, %v", err)
+	}
+
+	tiles := map[maptile.Tile][]byte{
+		maptile.New(0, 0, 0): []byte("zero"),
+		maptile.New(0, 0, 1): []byte("one"),
+		maptile.New(1, 0, 1): []byte("one"),
+		maptile.New(5, 9, 4): []byte("four"),
+	}
+
+	for tile, body := range tiles {
+
+		err := wr.WriteTile(ctx, tile, body)
+
+		if err != nil {
+			t.Fatalf("Failed to write tile, %v", err)
+		}
+	}
+
+	md := &exportMetadata{
+		Name:   "example",
+		Bounds: [4]float64{-122.5, 37.5, -122.25, 37.75},
+		VectorLayers: []*vectorLayer{
+			&vectorLayer{ID: "example"},
+		},
+	}
+
+	err = wr.Close(ctx, md)
+
+	if err != nil {
+		t.Fatalf("Failed to close writer, %v", err)
+	}
+
+	a, err := openPMTilesArchive(path)
+
+	if err != nil {
+		t.Fatalf("Failed to open archive, %v", err)
+	}
+
+	defer a.Close()
+
+	if a.Header.MinZoom != 0 || a.Header.MaxZoom != 4 || a.Header.TileContents != 3 {
+		t.Fatalf("Unexpected header: %v", a.Header)
+	}
+
+	if len(a.VectorLayers) != 1 || a.VectorLayers[0] != "example" {
+		t.Fatalf("Unexpected vector layers: %v", a.VectorLayers)
+	}
+
+	if a.Header.Bound().Min[0] != -122.5 || a.Header.Bound().Max[1] != 37.75 {
+		t.Fatalf("Unexpected bounds: %v", a.Header.Bound())
+	}
+
+	for tile, body := range tiles {
+
+		gz_body, err := a.Tile(tile)
+
+		if err != nil {
+			t.Fatalf("Failed to read tile %v, %v", tile, err)
+		}
+
+		if !bytes.Equal(gunzip(t, gz_body), body) {
+			t.Fatalf("Unexpected body for tile %v", tile)
+		}
+	}
+
+	missing, err := a.Tile(maptile.New(1, 1, 1))
+
+	if err != nil || missing != nil {
+		t.Fatalf("Expected missing tile to return nil")
+	}
+
+	handler := withPMTiles(map[string]*pmtilesArchive{"example": a}, http.NotFoundHandler())
+
+	tests := []struct {
+		path     string
+		status   int
+		encoding string
+	}{
+		{"/tiles/example/4/5/9.mvt", http.StatusOK, "gzip"},
+		{"/tiles/example/4/5/10.mvt", http.StatusNoContent, ""},
+		{"/tiles/other/4/5/9.mvt", http.StatusNotFound, ""},
+		{"/tiles/example/4/16/9.mvt", http.StatusBadRequest, ""},
+		{"/tiles/example/4/5/16.mvt", http.StatusBadRequest, ""},
+		{"/tiles/example/256/0/0.mvt", http.StatusBadRequest, ""},
+		{"/tiles/example/4/99999999999/0.mvt", http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+
+		req := httptest.NewRequest("GET", test.path, nil)
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		if rsp.Code != test.status || rsp.Header().Get("Content-Encoding") != test.encoding {
+			t.Fatalf("Unexpected response for %s: %d '%s'", test.path, rsp.Code, rsp.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func gunzip(t *testing.T, b []byte) []byte {
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+
+	if err != nil {
+		t.Fatalf("Failed to create gzip reader, %v", err)
+	}
+
+	body, err := io.ReadAll(gz)
+
+	if err != nil {
+		t.Fatalf("Failed to read gzip data, %v", err)
+	}
+
+	return body
+}
+
+// Test vectors for tile IDs from the PMTiles (v3) specification and the reference implementations.
+func TestPMTilesTileID(t *testing.T) {
+
+	tests := []struct {
+		tile     maptile.Tile
+		expected uint64
+	}{
+		{maptile.New(0, 0, 0), 0},
+		{maptile.New(0, 0, 1), 1},
+		{maptile.New(0, 1, 1), 2},
+		{maptile.New(1, 1, 1), 3},
+		{maptile.New(1, 0, 1), 4},
+		{maptile.New(0, 0, 2), 5},
+		{maptile.New(1, 1, 2), 7},
+		{maptile.New(0, 3, 2), 10},
+		{maptile.New(3, 3, 2), 15},
+		{maptile.New(3, 0, 2), 20},
+		{maptile.New(0, 0, 3), 21},
+		{maptile.New(7, 0, 3), 84},
+		{maptile.New(0, 0, 4), 85},
+		{maptile.New(3423, 1763, 12), 19078479},
+	}
+
+	for _, test := range tests {
+
+		id := pmtilesTileID(test.tile)
+
+		if id != test.expected {
+			t.Fatalf("Unexpected tile ID for %d/%d/%d: %d, expected %d", test.tile.Z, test.tile.X, test.tile.Y, id, test.expected)
+		}
+	}
+
+	// Every tile, up to zoom level 8, maps to a distinct ID which is the inverse of its position on the Hilbert curve
+
+	var expected uint64
+
+	for z := maptile.Zoom(0); z <= 8; z++ {
+
+		n := uint64(1) << z
+		tiles := make(map[uint64]maptile.Tile)
+
+		for x := uint32(0); x < uint32(n); x++ {
+
+			for y := uint32(0); y < uint32(n); y++ {
+				tile := maptile.New(x, y, z)
+				tiles[pmtilesTileID(tile)] = tile
+			}
+		}
+
+		for d := uint64(0); d < n*n; d++ {
+
+			tile, exists := tiles[expected+d]
+
+			if !exists || tile != hilbertTile(z, d) {
+				t.Fatalf("Unexpected tile for ID %d at zoom %d: %v", expected+d, z, tile)
+			}
+		}
+
+		expected += n * n
+	}
+}
+
+// hilbertTile returns the tile at position 'd' on the Hilbert curve at zoom level 'z', using the reference ("d2xy") algorithm.
+func hilbertTile(z maptile.Zoom, d uint64) maptile.Tile {
+
+	n := uint64(1) << z
+
+	var x uint64
+	var y uint64
+
+	for s := uint64(1); s < n; s *= 2 {
+
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+
+		if ry == 0 {
+
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+
+			x, y = y, x
+		}
+
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+
+	return maptile.New(uint32(x), uint32(y), z)
+}
+
+// An archive assembled, byte by byte, following the layout in the PMTiles (v3) specification rather than using `pmtilesWriter`.
+func TestPMTilesArchiveSpec(t *testing.T) {
+
+	// Tiles 0/0/0 ("A"), 1/0/0 and 1/0/1 (both "B", as a single run) and 1/1/0 ("CCC")
+
+	data := []byte("ABCCC")
+
+	// The number of entries, then the tile ID deltas, run lengths, lengths and offsets (offset + 1, or 0 if contiguous)
+
+	root := []byte{
+		0x03,
+		0x00, 0x01, 0x03,
+		0x01, 0x02, 0x01,
+		0x01, 0x01, 0x03,
+		0x01, 0x00, 0x00,
+	}
+
+	metadata := []byte(`{"vector_layers":[{"id":"example"}]}`)
+
+	root_offset := uint64(127)
+	metadata_offset := root_offset + uint64(len(root))
+	leaf_offset := metadata_offset + uint64(len(metadata))
+	data_offset := leaf_offset
+
+	header := make([]byte, 127)
+
+	copy(header[0:7], "PMTiles")
+	header[7] = 3
+
+	binary.LittleEndian.PutUint64(header[8:16], root_offset)
+	binary.LittleEndian.PutUint64(header[16:24], uint64(len(root)))
+	binary.LittleEndian.PutUint64(header[24:32], metadata_offset)
+	binary.LittleEndian.PutUint64(header[32:40], uint64(len(metadata)))
+	binary.LittleEndian.PutUint64(header[40:48], leaf_offset)
+	binary.LittleEndian.PutUint64(header[48:56], 0)
+	binary.LittleEndian.PutUint64(header[56:64], data_offset)
+	binary.LittleEndian.PutUint64(header[64:72], uint64(len(data)))
+	binary.LittleEndian.PutUint64(header[72:80], 4)
+	binary.LittleEndian.PutUint64(header[80:88], 3)
+	binary.LittleEndian.PutUint64(header[88:96], 3)
+
+	header[96] = 1  // clustered
+	header[97] = 1  // internal compression: none
+	header[98] = 1  // tile compression: none
+	header[99] = 1  // tile type: mvt
+	header[100] = 0 // min zoom
+	header[101] = 1 // max zoom
+	header[118] = 0 // center zoom
+
+	for offset, v := range map[int]int32{102: -1800000000, 106: -850511287, 110: 1800000000, 114: 850511287, 119: 0, 123: 0} {
+		binary.LittleEndian.PutUint32(header[offset:offset+4], uint32(v))
+	}
+
+	archive := make([]byte, 0)
+	archive = append(archive, header...)
+	archive = append(archive, root...)
+	archive = append(archive, metadata...)
+	archive = append(archive, data...)
+
+	path := filepath.Join(t.TempDir(), "spec.pmtiles")
+
+	err := os.WriteFile(path, archive, 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write archive, %v", err)
+	}
+
+	a, err := openPMTilesArchive(path)
+
+	if err != nil {
+		t.Fatalf("Failed to open archive, %v", err)
+	}
+
+	defer a.Close()
+
+	// The header is encoded exactly as it was read
+
+	if !bytes.Equal(a.Header.Bytes(), header) {
+		t.Fatalf("Unexpected header encoding: %x", a.Header.Bytes())
+	}
+
+	if len(a.VectorLayers) != 1 || a.VectorLayers[0] != "example" {
+		t.Fatalf("Unexpected vector layers: %v", a.VectorLayers)
+	}
+
+	tests := map[maptile.Tile]string{
+		maptile.New(0, 0, 0): "A",
+		maptile.New(0, 0, 1): "B",
+		maptile.New(0, 1, 1): "B",
+		maptile.New(1, 1, 1): "",
+		maptile.New(1, 0, 1): "CCC",
+		maptile.New(0, 0, 2): "",
+	}
+
+	for tile, expected := range tests {
+
+		body, err := a.Tile(tile)
+
+		if err != nil {
+			t.Fatalf("Failed to read tile %d/%d/%d, %v", tile.Z, tile.X, tile.Y, err)
+		}
+
+		if string(body) != expected {
+			t.Fatalf("Unexpected body for tile %d/%d/%d: '%s'", tile.Z, tile.X, tile.Y, body)
+		}
+	}
+
+	// Directories are encoded exactly as the specification describes
+
+	entries, err := deserializePMTilesDirectory(root)
+
+	if err != nil {
+		t.Fatalf("Failed to decode directory, %v", err)
+	}
+
+	enc, err := serializePMTilesDirectory(entries)
+
+	if err != nil {
+		t.Fatalf("Failed to encode directory, %v", err)
+	}
+
+	if !bytes.Equal(gunzip(t, enc), root) {
+		t.Fatalf("Unexpected directory encoding: %x", gunzip(t, enc))
+	}
+}
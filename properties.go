@@ -0,0 +1,203 @@
+package show
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// ZoomPropertyRule defines the list of properties to include for tiles below a given zoom level.
+type ZoomPropertyRule struct {
+	// The zoom level below which this rule is applied.
+	MaxZoom int `json:"max_zoom"`
+	// The list of glob patterns that property (column) names must match in order to be included.
+	Include []string `json:"include"`
+}
+
+// ParseZoomPropertyRule derives a new `ZoomPropertyRule` instance from a string in the form of "{MAX_ZOOM}={PATTERN},{PATTERN}...".
+func ParseZoomPropertyRule(str_rule string) (*ZoomPropertyRule, error) {
+
+	str_zoom, str_patterns, ok := strings.Cut(str_rule, "=")
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid zoom property rule, expected {MAX_ZOOM}={PATTERN}")
+	}
+
+	zoom, err := strconv.Atoi(strings.TrimSpace(str_zoom))
+
+	if err != nil || zoom < 0 {
+		return nil, fmt.Errorf("Invalid zoom level '%s'", str_zoom)
+	}
+
+	patterns := make([]string, 0)
+
+	for _, p := range strings.Split(str_patterns, ",") {
+
+		p = strings.TrimSpace(p)
+
+		if p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("Missing property patterns for zoom level %d", zoom)
+	}
+
+	r := &ZoomPropertyRule{
+		MaxZoom: zoom,
+		Include: patterns,
+	}
+
+	return r, nil
+}
+
+// PropertyRules defines which columns are assigned as (GeoJSON Feature) properties and how their values are encoded.
+type PropertyRules struct {
+	// Zero or more glob patterns that column names must match in order to be included. If empty all columns are included.
+	Include []string
+	// Zero or more glob patterns for column names to exclude. Exclusions take precedence over 'Include'.
+	Exclude []string
+	// Zero or more rules further limiting the properties included for tiles below a given zoom level. If more than one rule
+	// applies to a zoom level the rule with the lowest 'MaxZoom' is used.
+	ZoomRules []*ZoomPropertyRule
+	// Remove properties whose value is NULL.
+	DropNulls bool
+	// If greater than zero, string values longer than this number of characters are truncated.
+	MaxStringLength int
+}
+
+// Validate ensures that all the glob patterns in 'r' are well-formed.
+func (r *PropertyRules) Validate() error {
+
+	patterns := make([]string, 0)
+	patterns = append(patterns, r.Include...)
+	patterns = append(patterns, r.Exclude...)
+
+	for _, zr := range r.ZoomRules {
+		patterns = append(patterns, zr.Include...)
+	}
+
+	for _, p := range patterns {
+
+		_, err := path.Match(p, "")
+
+		if err != nil {
+			return fmt.Errorf("Invalid property pattern '%s', %w", p, err)
+		}
+	}
+
+	if r.MaxStringLength < 0 {
+		return fmt.Errorf("Invalid maximum string length %d", r.MaxStringLength)
+	}
+
+	return nil
+}
+
+// Columns returns the subset of 'cols' to include as properties for tiles at zoom level 'zoom'.
+func (r *PropertyRules) Columns(cols []string, zoom int) []string {
+
+	if r == nil {
+		return cols
+	}
+
+	zoom_rule := r.zoomRule(zoom)
+	included := make([]string, 0)
+
+	for _, c := range cols {
+
+		if len(r.Include) > 0 && !matchAny(r.Include, c) {
+			continue
+		}
+
+		if matchAny(r.Exclude, c) {
+			continue
+		}
+
+		if zoom_rule != nil && !matchAny(zoom_rule.Include, c) {
+			continue
+		}
+
+		included = append(included, c)
+	}
+
+	return included
+}
+
+// Apply removes NULL values from, and truncates long strings in, 'props' as defined by 'r'.
+func (r *PropertyRules) Apply(props geojson.Properties) {
+
+	if r == nil {
+		return
+	}
+
+	for k, v := range props {
+
+		switch str_v := v.(type) {
+		case nil:
+
+			if r.DropNulls {
+				delete(props, k)
+			}
+
+		case string:
+
+			if r.MaxStringLength > 0 {
+				props[k] = truncateString(str_v, r.MaxStringLength)
+			}
+		}
+	}
+}
+
+// zoomRule returns the rule in 'r.ZoomRules' with the lowest max zoom that is greater than 'zoom' or nil if there isn't one.
+func (r *PropertyRules) zoomRule(zoom int) *ZoomPropertyRule {
+
+	var match *ZoomPropertyRule
+
+	for _, zr := range r.ZoomRules {
+
+		if zoom >= zr.MaxZoom {
+			continue
+		}
+
+		if match == nil || zr.MaxZoom < match.MaxZoom {
+			match = zr
+		}
+	}
+
+	return match
+}
+
+// matchAny reports whether 'name' matches any of the glob patterns in 'patterns'.
+func matchAny(patterns []string, name string) bool {
+
+	for _, p := range patterns {
+
+		ok, _ := path.Match(p, name)
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncateString returns 'str' truncated to 'max' characters (runes).
+func truncateString(str string, max int) string {
+
+	if len(str) <= max {
+		return str
+	}
+
+	runes := []rune(str)
+
+	if len(runes) <= max {
+		return str
+	}
+
+	return string(runes[:max])
+}
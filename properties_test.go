@@ -0,0 +1,88 @@
+package show
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+func TestPropertyRulesColumns(t *testing.T) {
+
+	cols := []string{"wof:id", "wof:name", "wof:placetype", "src:geom", "geometry"}
+
+	zr, err := ParseZoomPropertyRule("10=wof:id")
+
+	if err != nil {
+		t.Fatalf("Failed to parse zoom property rule, %v", err)
+	}
+
+	r := &PropertyRules{
+		Include:   []string{"wof:*", "src:*"},
+		Exclude:   []string{"src:*"},
+		ZoomRules: []*ZoomPropertyRule{zr},
+	}
+
+	err = r.Validate()
+
+	if err != nil {
+		t.Fatalf("Failed to validate rules, %v", err)
+	}
+
+	tests := map[int]string{
+		4:  "wof:id",
+		9:  "wof:id",
+		10: "wof:id,wof:name,wof:placetype",
+		16: "wof:id,wof:name,wof:placetype",
+	}
+
+	for zoom, expected := range tests {
+
+		str_cols := strings.Join(r.Columns(cols, zoom), ",")
+
+		if str_cols != expected {
+			t.Fatalf("Unexpected columns for zoom %d: %s", zoom, str_cols)
+		}
+	}
+
+	var nil_rules *PropertyRules
+
+	if len(nil_rules.Columns(cols, 0)) != len(cols) {
+		t.Fatalf("Expected nil rules to return all columns")
+	}
+}
+
+func TestPropertyRulesApply(t *testing.T) {
+
+	r := &PropertyRules{
+		DropNulls:       true,
+		MaxStringLength: 3,
+	}
+
+	props := geojson.Properties{
+		"name":  "Öresund",
+		"short": "abc",
+		"empty": nil,
+		"id":    int64(1234567),
+	}
+
+	r.Apply(props)
+
+	_, exists := props["empty"]
+
+	if exists {
+		t.Fatalf("Expected NULL property to be removed")
+	}
+
+	if props["name"] != "Öre" {
+		t.Fatalf("Unexpected truncated value: %v", props["name"])
+	}
+
+	if props["short"] != "abc" {
+		t.Fatalf("Unexpected value: %v", props["short"])
+	}
+
+	if props["id"] != int64(1234567) {
+		t.Fatalf("Unexpected value: %v", props["id"])
+	}
+}
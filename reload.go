@@ -0,0 +1,419 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The path of the server-sent events endpoint used to notify clients that the tileset has been reloaded.
+const events_path string = "/events"
+
+// The name of the server-sent event sent to clients when the tileset has been reloaded.
+const reload_event string = "reload"
+
+// The interval at which comments are sent to server-sent event clients to keep their connections open.
+const events_keepalive_interval time.Duration = 30 * time.Second
+
+// reloadEvents is an `http.Handler` which streams server-sent events, broadcast using its `Broadcast` method, to each connected client.
+type reloadEvents struct {
+	mu      sync.Mutex
+	clients map[chan string]bool
+	closed  bool
+	done    chan bool
+}
+
+// newReloadEvents returns a new `reloadEvents` instance.
+func newReloadEvents() *reloadEvents {
+
+	ev := &reloadEvents{
+		clients: make(map[chan string]bool),
+		done:    make(chan bool),
+	}
+
+	return ev
+}
+
+// Broadcast sends the event 'name' to all the connected clients. Clients which are not ready to receive the event are skipped.
+func (ev *reloadEvents) Broadcast(name string) {
+
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	for ch := range ev.clients {
+
+		select {
+		case ch <- name:
+		default:
+			slog.Warn("Server-sent events client is not ready, skipping event", "event", name)
+		}
+	}
+}
+
+// Close ends the streams for all the connected clients, and any clients that connect later, so that the HTTP server can shut down.
+func (ev *reloadEvents) Close() {
+
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+
+	if !ev.closed {
+		ev.closed = true
+		close(ev.done)
+	}
+}
+
+// ServeHTTP streams server-sent events to the client until the request is cancelled or 'ev' is closed.
+func (ev *reloadEvents) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	flusher, ok := rsp.(http.Flusher)
+
+	if !ok {
+		http.Error(rsp, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := make(chan string, 1)
+
+	ev.mu.Lock()
+
+	if ev.closed {
+		ev.mu.Unlock()
+		http.Error(rsp, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	ev.clients[ch] = true
+	ev.mu.Unlock()
+
+	defer func() {
+		ev.mu.Lock()
+		delete(ev.clients, ch)
+		ev.mu.Unlock()
+	}()
+
+	rsp.Header().Set("Content-Type", "text/event-stream")
+	rsp.Header().Set("Cache-Control", "no-cache")
+	rsp.Header().Set("Connection", "keep-alive")
+
+	rsp.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(events_keepalive_interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ev.done:
+			return
+		case <-ticker.C:
+			fmt.Fprintf(rsp, ": keepalive\n\n")
+			flusher.Flush()
+		case name := <-ch:
+			fmt.Fprintf(rsp, "event: %s\ndata: %d\n\n", name, time.Now().UnixMilli())
+			flusher.Flush()
+		}
+	}
+}
+
+// swapHandler is an `http.Handler` which delegates requests to another handler that can be replaced while serving requests.
+type swapHandler struct {
+	mu      sync.RWMutex
+	handler http.Handler
+	// The requests currently being served by 'handler'.
+	inflight *sync.WaitGroup
+}
+
+// newSwapHandler returns a new `swapHandler` instance which delegates requests to 'handler'.
+func newSwapHandler(handler http.Handler) *swapHandler {
+
+	h := &swapHandler{
+		handler:  handler,
+		inflight: new(sync.WaitGroup),
+	}
+
+	return h
+}
+
+// Swap replaces the handler that requests are delegated to with 'handler'. It returns a `sync.WaitGroup` which is done
+// once all the requests delegated to the previous handler have completed.
+func (h *swapHandler) Swap(handler http.Handler) *sync.WaitGroup {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	inflight := h.inflight
+
+	h.handler = handler
+	h.inflight = new(sync.WaitGroup)
+
+	return inflight
+}
+
+// ServeHTTP delegates 'req' to the current handler.
+func (h *swapHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	// The request is added to the handler's in-flight requests while the lock is held so that
+	// it is always counted before `Swap` returns.
+
+	h.mu.RLock()
+	handler := h.handler
+	inflight := h.inflight
+	inflight.Add(1)
+	h.mu.RUnlock()
+
+	defer inflight.Done()
+
+	handler.ServeHTTP(rsp, req)
+}
+
+// isReloadable reports whether the tileset defined by 'opts' can be reloaded while serving requests.
+func isReloadable(opts *RunOptions) bool {
+	return opts.Reload || opts.ConfigFile != ""
+}
+
+// tilesetReloader manages the tileset served by a web server, and the handlers derived from it, replacing them when
+// the tileset is reloaded and notifying clients that it has been.
+type tilesetReloader struct {
+	// The handler for the map config of the current tileset.
+	MapConfigHandler *swapHandler
+	// The handler for the tiles of the current tileset.
+	TilesHandler *swapHandler
+	// The handler for retrieving complete features by ID from the current tileset.
+	FeaturesHandler *swapHandler
+	// The server-sent events handler used to notify clients when the tileset has been reloaded.
+	Events       *reloadEvents
+	opts         *RunOptions
+	mu           sync.Mutex
+	tileset      *tileset
+	cache        *TileCache
+	cancel_watch context.CancelFunc
+	// A channel which is closed once the most recently replaced tileset has been released (see `releaseTileset`).
+	released chan bool
+}
+
+// newTilesetReloader returns a new `tilesetReloader` instance for 'ts' and the handlers derived from it.
+func newTilesetReloader(opts *RunOptions, ts *tileset, handlers *tilesetHandlers) *tilesetReloader {
+
+	r := &tilesetReloader{
+		MapConfigHandler: newSwapHandler(handlers.MapConfig),
+		TilesHandler:     newSwapHandler(handlers.Tiles),
+		FeaturesHandler:  newSwapHandler(handlers.Features),
+		Events:           newReloadEvents(),
+		opts:             opts,
+		tileset:          ts,
+		cache:            handlers.Cache,
+	}
+
+	return r
+}
+
+// Watch starts polling the data sources of the current tileset for changes. If 'opts.Reload' is true the tileset is reloaded
+// when they change, otherwise the tile cache (if defined) is cleared.
+func (r *tilesetReloader) Watch(ctx context.Context) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.watch(ctx)
+}
+
+// Reload rebuilds the tileset, and the handlers derived from it, from 'opts' and notifies clients. If the tileset can not be rebuilt an error
+// is returned and the previous tileset continues to be served. Once all the requests being served by the previous tileset have completed its
+// cached tiles, materialized tables and PMTiles archives which are not used by the new tileset are released.
+func (r *tilesetReloader) Reload(ctx context.Context) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t1 := time.Now()
+
+	// The new tileset materializes its data sources in to tables whose names are unique to it so that
+	// the previous tileset can continue to serve requests until the handlers are swapped below.
+
+	ts, err := setupTileset(ctx, r.opts)
+
+	if err != nil {
+		return fmt.Errorf("Failed to set up tileset, %w", err)
+	}
+
+	handlers, err := newTilesetHandlers(ctx, r.opts, ts)
+
+	if err != nil {
+		releaseTileset(ctx, r.opts, ts, r.tileset, nil, nil)
+		return fmt.Errorf("Failed to create handlers for tileset, %w", err)
+	}
+
+	inflight := []*sync.WaitGroup{
+		r.MapConfigHandler.Swap(handlers.MapConfig),
+		r.TilesHandler.Swap(handlers.Tiles),
+		r.FeaturesHandler.Swap(handlers.Features),
+	}
+
+	prev_ts := r.tileset
+	prev_cache := r.cache
+
+	r.tileset = ts
+	r.cache = handlers.Cache
+
+	r.watch(ctx)
+
+	r.Events.Broadcast(reload_event)
+
+	slog.Info("Reloaded tileset", "layers", len(ts.Config.Layers), "time", time.Since(t1))
+
+	// Requests which are still being served by the previous tileset may be reading from its tables and
+	// archives, and writing to its tile cache, so it is only released once they have completed. Tilesets
+	// are released in order since tables and cached tiles may be shared by successive tilesets.
+
+	prev_released := r.released
+	released := make(chan bool)
+
+	r.released = released
+
+	go func() {
+
+		defer close(released)
+
+		if prev_released != nil {
+			<-prev_released
+		}
+
+		for _, wg := range inflight {
+			wg.Wait()
+		}
+
+		releaseTileset(context.Background(), r.opts, prev_ts, ts, prev_cache, handlers.Cache)
+	}()
+
+	return nil
+}
+
+// Close stops polling data sources for changes, ends any server-sent event streams, waits for any previous tilesets to be released
+// and closes the current tileset.
+func (r *tilesetReloader) Close() error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cancel_watch != nil {
+		r.cancel_watch()
+	}
+
+	r.Events.Close()
+
+	if r.released != nil {
+		<-r.released
+	}
+
+	return r.tileset.Close()
+}
+
+// releaseTileset removes the cached tiles, drops the materialized tables and closes the PMTiles archives of 'prev_ts' which are not used
+// by 'ts'. 'prev_cache' and 'cache' are the tile caches, either of which may be nil, used to serve the tiles for 'prev_ts' and 'ts' respectively.
+// Errors are logged rather than returned since the previous tileset is no longer being served.
+func releaseTileset(ctx context.Context, opts *RunOptions, prev_ts *tileset, ts *tileset, prev_cache *TileCache, cache *TileCache) {
+
+	// Tiles cached for layers whose fingerprint has not changed are still valid and are kept.
+
+	if prev_cache != nil {
+
+		current := make(map[string]bool)
+
+		for _, fingerprint := range ts.Fingerprints.Values() {
+			current[fingerprint] = true
+		}
+
+		stale := make([]string, 0)
+
+		for _, fingerprint := range prev_ts.Fingerprints.Values() {
+
+			if !current[fingerprint] {
+				stale = append(stale, fingerprint)
+			}
+		}
+
+		// Prefer the new tile cache, if it shares the same directory, so that it does not continue to
+		// account for the tiles which are removed.
+
+		if cache != nil && cache.directory == prev_cache.directory {
+			prev_cache = cache
+		}
+
+		err := prev_cache.ClearFingerprints(stale)
+
+		if err != nil {
+			slog.Error("Failed to clear tile cache for previous tileset", "error", err)
+		}
+	}
+
+	err := releaseMaterializedTables(ctx, opts.Database, prev_ts.Tables, ts.Tables)
+
+	if err != nil {
+		slog.Warn("Failed to release materialized tables for previous tileset", "error", err)
+	}
+
+	err = prev_ts.Close()
+
+	if err != nil {
+		slog.Warn("Failed to close previous tileset", "error", err)
+	}
+}
+
+// watch (re)starts polling the data sources of the current tileset for changes. It is assumed that 'r.mu' is locked.
+func (r *tilesetReloader) watch(ctx context.Context) {
+
+	if r.cancel_watch != nil {
+		r.cancel_watch()
+		r.cancel_watch = nil
+	}
+
+	if !r.opts.Reload && r.cache == nil {
+		return
+	}
+
+	watch_ctx, cancel := context.WithCancel(ctx)
+	r.cancel_watch = cancel
+
+	datasources := r.tileset.Datasources
+	fingerprints := r.tileset.Fingerprints
+	cache := r.cache
+
+	go watchSources(watch_ctx, datasources, source_poll_interval, func(changed []string) {
+
+		if !r.opts.Reload {
+
+			// Layer fingerprints include the state of each data source so they are recalculated, in order that new tiles
+			// are not cached using the previous fingerprint, and the tiles cached using the previous fingerprints are cleared.
+
+			slog.Info("Data sources changed, clearing tile cache", "datasources", changed)
+
+			stale, err := fingerprints.Update()
+
+			if err != nil {
+				slog.Error("Failed to update layer fingerprints, clearing tile cache", "error", err)
+				err = cache.Clear()
+			} else {
+				err = cache.ClearFingerprints(stale)
+			}
+
+			if err != nil {
+				slog.Error("Failed to clear tile cache", "error", err)
+			}
+
+			return
+		}
+
+		slog.Info("Data sources changed, reloading tileset", "datasources", changed)
+
+		err := r.Reload(ctx)
+
+		if err != nil {
+			slog.Error("Failed to reload tileset", "error", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package show
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadEvents(t *testing.T) {
+
+	ev := newReloadEvents()
+
+	server := httptest.NewServer(ev)
+	defer server.Close()
+
+	rsp, err := http.Get(server.URL)
+
+	if err != nil {
+		t.Fatalf("Failed to connect to events server, %v", err)
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected content type: %s", rsp.Header.Get("Content-Type"))
+	}
+
+	// Wait for the client to be registered before broadcasting
+
+	for i := 0; i < 100; i++ {
+
+		ev.mu.Lock()
+		count := len(ev.clients)
+		ev.mu.Unlock()
+
+		if count > 0 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ev.Broadcast(reload_event)
+
+	rdr := bufio.NewReader(rsp.Body)
+
+	line, err := rdr.ReadString('\n')
+
+	if err != nil {
+		t.Fatalf("Failed to read event, %v", err)
+	}
+
+	if strings.TrimSpace(line) != "event: "+reload_event {
+		t.Fatalf("Unexpected event: %s", line)
+	}
+
+	// Closing the events handler must end the stream
+
+	ev.Close()
+
+	done := make(chan error, 1)
+
+	go func() {
+		_, err := io.ReadAll(rdr)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+
+		if err != nil {
+			t.Fatalf("Failed to read remainder of stream, %v", err)
+		}
+
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for event stream to close")
+	}
+
+	ev.Close()
+}
+
+func TestSwapHandler(t *testing.T) {
+
+	handler := func(body string) http.Handler {
+
+		fn := func(rsp http.ResponseWriter, req *http.Request) {
+			rsp.Write([]byte(body))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+
+	h := newSwapHandler(handler("a"))
+
+	for _, body := range []string{"a", "b"} {
+
+		if body == "b" {
+			h.Swap(handler("b"))
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/map.json", nil))
+
+		if rec.Body.String() != body {
+			t.Fatalf("Expected '%s', got '%s'", body, rec.Body.String())
+		}
+	}
+}
+
+func TestSwapHandlerInflight(t *testing.T) {
+
+	started := make(chan bool)
+	release := make(chan bool)
+
+	blocking := func(rsp http.ResponseWriter, req *http.Request) {
+		started <- true
+		<-release
+	}
+
+	h := newSwapHandler(http.HandlerFunc(blocking))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/map.json", nil))
+
+	<-started
+
+	inflight := h.Swap(http.NotFoundHandler())
+
+	drained := make(chan bool)
+
+	go func() {
+		inflight.Wait()
+		close(drained)
+	}()
+
+	// Requests to the new handler are not counted as in-flight requests for the previous handler
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/map.json", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Expected request to be served by new handler, got %d", rec.Code)
+	}
+
+	select {
+	case <-drained:
+		t.Fatalf("Expected previous handler to have an in-flight request")
+	case <-time.After(50 * time.Millisecond):
+		// pass
+	}
+
+	close(release)
+
+	select {
+	case <-drained:
+		// pass
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for in-flight requests to drain")
+	}
+}
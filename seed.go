@@ -0,0 +1,402 @@
+package show
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/orb"
+	orb_mvt "github.com/paulmach/orb/encoding/mvt"
+	"github.com/paulmach/orb/maptile"
+)
+
+// The interval at which the progress of seeding the tile cache is logged.
+const seed_progress_interval time.Duration = 5 * time.Second
+
+// The number of (largest) tiles listed when the results of seeding the tile cache are logged.
+const seed_largest_tiles int = 10
+
+// ZoomRange defines an inclusive range of zoom levels.
+type ZoomRange struct {
+	// The minimum zoom level in the range.
+	MinZoom int
+	// The maximum zoom level in the range.
+	MaxZoom int
+}
+
+// ParseZoomRange derives a new `ZoomRange` instance from a string in the form of "{MIN_ZOOM}-{MAX_ZOOM}" or "{ZOOM}".
+func ParseZoomRange(str_range string) (*ZoomRange, error) {
+
+	str_min, str_max, ok := strings.Cut(str_range, "-")
+
+	if !ok {
+		str_max = str_min
+	}
+
+	min_zoom, err := parseZoomLevel(strings.TrimSpace(str_min))
+
+	if err != nil {
+		return nil, err
+	}
+
+	max_zoom, err := parseZoomLevel(strings.TrimSpace(str_max))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if min_zoom > max_zoom {
+		return nil, fmt.Errorf("Minimum zoom level (%d) is greater than maximum zoom level (%d)", min_zoom, max_zoom)
+	}
+
+	r := &ZoomRange{
+		MinZoom: min_zoom,
+		MaxZoom: max_zoom,
+	}
+
+	return r, nil
+}
+
+// RunSeed will fill the (on-disk) tile cache with tiles derived from GeoParquet data using the default seed flag set to parse 'args'.
+func RunSeed(ctx context.Context, args []string) error {
+	fs := DefaultSeedFlagSet()
+	return RunSeedWithFlagSet(ctx, fs, args)
+}
+
+// RunSeedWithFlagSet will fill the (on-disk) tile cache with tiles derived from GeoParquet data using options derived from 'fs'
+// after parsing 'args'.
+func RunSeedWithFlagSet(ctx context.Context, fs *flag.FlagSet, args []string) error {
+
+	err := fs.Parse(args)
+
+	if err != nil {
+		return fmt.Errorf("Failed to parse flags, %w", err)
+	}
+
+	opts, err := runOptionsFromFlags(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return RunSeedWithOptions(ctx, opts)
+}
+
+// RunSeedWithOptions will fill the (on-disk) tile cache with tiles derived from GeoParquet data using configuration details provided
+// by 'opts'. Tiles are produced for every layer, and every zoom level in 'opts.SeedZooms', over the extent of each layer (or
+// 'opts.SeedBound' if set). Since the cache only outlives this process on disk 'opts.TileCacheDirectory' must be set.
+func RunSeedWithOptions(ctx context.Context, opts *RunOptions) error {
+
+	if opts.SeedZooms == nil {
+		return fmt.Errorf("Missing zoom levels to seed")
+	}
+
+	if opts.TileCacheDirectory == "" {
+		return fmt.Errorf("Seeding the tile cache requires a tile cache directory")
+	}
+
+	ts, err := setupTileset(ctx, opts)
+
+	if err != nil {
+		return err
+	}
+
+	defer ts.Close()
+
+	tiles_handler, _, err := newTilesHandler(opts, ts)
+
+	if err != nil {
+		return err
+	}
+
+	return seedTileCache(ctx, opts, ts, tiles_handler)
+}
+
+// seedTileCache produces the tiles defined by 'opts.SeedZooms' and 'opts.SeedBound' for every layer in 'ts', except those read
+// from PMTiles archives, by requesting them from 'tiles_handler' (which is expected to store them in a tile cache) and logs a
+// summary of the results.
+func seedTileCache(ctx context.Context, opts *RunOptions, ts *tileset, tiles_handler http.Handler) error {
+
+	workers := opts.SeedWorkers
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	layers := make([]*mapLayerConfig, 0)
+
+	for _, layer_cfg := range ts.Config.Layers {
+
+		_, is_archive := ts.Archives[layer_cfg.Name]
+
+		if !is_archive {
+			layers = append(layers, layer_cfg)
+		}
+	}
+
+	slog.Info("Seed tile cache", "min_zoom", opts.SeedZooms.MinZoom, "max_zoom", opts.SeedZooms.MaxZoom, "workers", workers)
+
+	report, err := seedTiles(ctx, tiles_handler, layers, opts.SeedZooms, opts.SeedBound, workers)
+
+	if err != nil {
+		return fmt.Errorf("Failed to seed tile cache, %w", err)
+	}
+
+	report.Log()
+	return nil
+}
+
+// seedTiles requests the tiles for every zoom level in 'zooms' which intersect 'bound', or the extent of each layer if nil, for
+// each layer in 'layers' from 'tiles_handler' using 'workers' goroutines. Zoom levels outside of a layer's own zoom range are skipped.
+func seedTiles(ctx context.Context, tiles_handler http.Handler, layers []*mapLayerConfig, zooms *ZoomRange, bound *orb.Bound, workers int) (*seedReport, error) {
+
+	ranges := make([]*tileWalkRange, 0)
+
+	for _, layer_cfg := range layers {
+
+		l := &tileWalkRange{
+			Layer:   layer_cfg.Name,
+			MinZoom: max(zooms.MinZoom, layer_cfg.MinZoom),
+			MaxZoom: zooms.MaxZoom,
+			Bound: orb.Bound{
+				Min: orb.Point{layer_cfg.MinX, layer_cfg.MinY},
+				Max: orb.Point{layer_cfg.MaxX, layer_cfg.MaxY},
+			},
+		}
+
+		if layer_cfg.MaxZoom > 0 {
+			l.MaxZoom = min(l.MaxZoom, layer_cfg.MaxZoom)
+		}
+
+		if bound != nil {
+			l.Bound = *bound
+		}
+
+		if l.MinZoom > l.MaxZoom {
+			slog.Debug("Skip layer outside of zoom range", "layer", l.Layer, "min_zoom", layer_cfg.MinZoom, "max_zoom", layer_cfg.MaxZoom)
+			continue
+		}
+
+		ranges = append(ranges, l)
+	}
+
+	slog.Info("Tiles to seed", "count", countTileRanges(ranges), "layers", len(ranges))
+
+	report := &seedReport{}
+	t1 := time.Now()
+
+	walk_opts := &tileWalkOptions{
+		Ranges:           ranges,
+		Workers:          workers,
+		ProgressMessage:  "Seed progress",
+		ProgressInterval: seed_progress_interval,
+	}
+
+	_, err := walkTilesParallel(ctx, walk_opts, func(ctx context.Context, layer string, t maptile.Tile) error {
+
+		path := fmt.Sprintf("/tiles/%s/%d/%d/%d.mvt", layer, t.Z, t.X, t.Y)
+
+		err := seedTile(ctx, tiles_handler, path, report)
+
+		if err != nil {
+			return fmt.Errorf("Failed to seed tile %s, %w", path, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	report.Time = time.Since(t1)
+	return report, nil
+}
+
+// seedTile requests 'path' from 'tiles_handler' and records the result in 'report'.
+func seedTile(ctx context.Context, tiles_handler http.Handler, path string, report *seedReport) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create request, %w", err)
+	}
+
+	rsp := newTileResponseWriter()
+
+	t1 := time.Now()
+	tiles_handler.ServeHTTP(rsp, req)
+	d := time.Since(t1)
+
+	if rsp.status != http.StatusOK {
+		return fmt.Errorf("Unexpected status code %d (%s)", rsp.status, strings.TrimSpace(rsp.body.String()))
+	}
+
+	body := rsp.body.Bytes()
+
+	empty, err := isEmptyTile(body)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decode tile, %w", err)
+	}
+
+	cached := rsp.header.Get(cache_header) == "HIT"
+
+	report.Record(path, len(body), empty, cached, d)
+	return nil
+}
+
+// isEmptyTile reports whether the (MVT) tile encoded in 'body' contains no features.
+func isEmptyTile(body []byte) (bool, error) {
+
+	if len(body) == 0 {
+		return true, nil
+	}
+
+	mvt_layers, err := orb_mvt.Unmarshal(body)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, l := range mvt_layers {
+
+		if len(l.Features) > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// tileResponseWriter implements the `http.ResponseWriter` interface to capture, in memory, the tiles produced by a tiles handler
+// while seeding the tile cache or exporting tiles.
+type tileResponseWriter struct {
+	header http.Header
+	status int
+	body   *bytes.Buffer
+}
+
+// newTileResponseWriter returns a new `tileResponseWriter` instance.
+func newTileResponseWriter() *tileResponseWriter {
+
+	w := &tileResponseWriter{
+		header: make(http.Header),
+		status: http.StatusOK,
+		body:   new(bytes.Buffer),
+	}
+
+	return w
+}
+
+func (w *tileResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *tileResponseWriter) WriteHeader(status_code int) {
+	w.status = status_code
+}
+
+func (w *tileResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// seedTileSize records the size of a tile produced while seeding the tile cache.
+type seedTileSize struct {
+	// The path of the tile, for example "/tiles/all/10/163/395.mvt".
+	Path string
+	// The size of the (encoded) tile in bytes.
+	Size int
+}
+
+// seedReport summarizes the tiles produced while seeding the tile cache.
+type seedReport struct {
+	mu sync.Mutex
+	// The number of tiles which contain one or more features.
+	NonEmpty int64
+	// The number of tiles which contain no features.
+	Empty int64
+	// The number of tiles which were already in the tile cache.
+	Cached int64
+	// The cumulative time spent producing tiles.
+	TileTime time.Duration
+	// The longest time spent producing a single tile.
+	MaxTileTime time.Duration
+	// The path of the tile which took the longest time to produce.
+	SlowestTile string
+	// The largest tiles produced, sorted by size in descending order.
+	Largest []*seedTileSize
+	// The total (wall clock) time spent seeding the tile cache.
+	Time time.Duration
+}
+
+// Record updates 'r' with the details of a tile that was produced in 'd' time.
+func (r *seedReport) Record(path string, size int, empty bool, cached bool, d time.Duration) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if empty {
+		r.Empty += 1
+	} else {
+		r.NonEmpty += 1
+	}
+
+	if cached {
+		r.Cached += 1
+	}
+
+	r.TileTime += d
+
+	if d > r.MaxTileTime {
+		r.MaxTileTime = d
+		r.SlowestTile = path
+	}
+
+	if empty {
+		return
+	}
+
+	if len(r.Largest) == seed_largest_tiles && size <= r.Largest[len(r.Largest)-1].Size {
+		return
+	}
+
+	idx, _ := slices.BinarySearchFunc(r.Largest, size, func(t *seedTileSize, size int) int {
+		return size - t.Size
+	})
+
+	r.Largest = slices.Insert(r.Largest, idx, &seedTileSize{Path: path, Size: size})
+
+	if len(r.Largest) > seed_largest_tiles {
+		r.Largest = r.Largest[:seed_largest_tiles]
+	}
+}
+
+// Tiles returns the total number of tiles recorded by 'r'.
+func (r *seedReport) Tiles() int64 {
+	return r.NonEmpty + r.Empty
+}
+
+// Log writes the details of 'r' to the default logger.
+func (r *seedReport) Log() {
+
+	var avg_time time.Duration
+
+	if r.Tiles() > 0 {
+		avg_time = r.TileTime / time.Duration(r.Tiles())
+	}
+
+	slog.Info("Seeding complete", "tiles", r.Tiles(), "non_empty", r.NonEmpty, "empty", r.Empty, "cached", r.Cached, "time", r.Time, "average_tile_time", avg_time, "max_tile_time", r.MaxTileTime, "slowest_tile", r.SlowestTile)
+
+	for _, t := range r.Largest {
+		slog.Info("Largest tile", "path", t.Path, "size", t.Size)
+	}
+}
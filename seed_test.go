@@ -0,0 +1,164 @@
+package show
+
+import (
+	"context"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/sfomuseum/go-http-mvt"
+)
+
+func TestParseZoomRange(t *testing.T) {
+
+	tests := map[string][2]int{
+		"0-10": {0, 10},
+		"5":    {5, 5},
+		" 3-4": {3, 4},
+	}
+
+	for str_range, expected := range tests {
+
+		r, err := ParseZoomRange(str_range)
+
+		if err != nil {
+			t.Fatalf("Failed to parse '%s', %v", str_range, err)
+		}
+
+		if r.MinZoom != expected[0] || r.MaxZoom != expected[1] {
+			t.Fatalf("Unexpected range for '%s': %d-%d", str_range, r.MinZoom, r.MaxZoom)
+		}
+	}
+
+	for _, str_range := range []string{"", "10-0", "0-99", "a-b"} {
+
+		_, err := ParseZoomRange(str_range)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", str_range)
+		}
+	}
+}
+
+func TestParseBound(t *testing.T) {
+
+	b, err := ParseBound("-122.5,37.6,-122.3,37.8")
+
+	if err != nil {
+		t.Fatalf("Failed to parse bound, %v", err)
+	}
+
+	if b.Min[0] != -122.5 || b.Min[1] != 37.6 || b.Max[0] != -122.3 || b.Max[1] != 37.8 {
+		t.Fatalf("Unexpected bound %v", b)
+	}
+
+	for _, str_bound := range []string{"", "1,2,3", "1,2,0,4", "a,b,c,d"} {
+
+		_, err := ParseBound(str_bound)
+
+		if err == nil {
+			t.Fatalf("Expected '%s' to fail", str_bound)
+		}
+	}
+}
+
+func TestSeedTiles(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Yield a single point, in the north-west quadrant of the world, for every tile
+
+	cb := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
+
+		fc := geojson.NewFeatureCollection()
+
+		if t.Bound().Contains(orb.Point{-100, 40}) {
+			f := geojson.NewFeature(orb.Point{-100, 40})
+			f.Properties["name"] = "example"
+			fc.Append(f)
+		}
+
+		return map[string]*geojson.FeatureCollection{
+			layer: fc,
+		}, nil
+	}
+
+	mvt_handler, err := mvt.NewTileHandler(&mvt.TileHandlerOptions{
+		GetFeaturesCallback: cb,
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to create tile handler, %v", err)
+	}
+
+	cache, err := NewTileCache(1024*1024, "", 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	fingerprints := newLayerFingerprints()
+	fingerprints.Set("points", "points")
+	fingerprints.Set("late", "late")
+
+	tiles_handler := withTileCache(cache, fingerprints, mvt_handler)
+
+	layers := []*mapLayerConfig{
+		{Name: "points", MinX: -180, MinY: -85, MaxX: 180, MaxY: 85},
+		// Only zoom levels 2 and 3 are in range
+		{Name: "late", MinZoom: 2, MinX: -180, MinY: -85, MaxX: 180, MaxY: 85},
+	}
+
+	zooms := &ZoomRange{MinZoom: 0, MaxZoom: 3}
+
+	report, err := seedTiles(ctx, tiles_handler, layers, zooms, nil, 4)
+
+	if err != nil {
+		t.Fatalf("Failed to seed tiles, %v", err)
+	}
+
+	// 1 + 4 + 16 + 64 tiles for the "points" layer and 16 + 64 for the "late" layer
+
+	if report.Tiles() != 165 {
+		t.Fatalf("Expected 165 tiles, got %d", report.Tiles())
+	}
+
+	// One tile per zoom level contains the point
+
+	if report.NonEmpty != 6 {
+		t.Fatalf("Expected 6 non-empty tiles, got %d", report.NonEmpty)
+	}
+
+	if report.Cached != 0 {
+		t.Fatalf("Expected no cached tiles, got %d", report.Cached)
+	}
+
+	if len(report.Largest) != 6 {
+		t.Fatalf("Expected 6 largest tiles, got %d", len(report.Largest))
+	}
+
+	for i := 1; i < len(report.Largest); i++ {
+
+		if report.Largest[i].Size > report.Largest[i-1].Size {
+			t.Fatalf("Largest tiles are not sorted by size")
+		}
+	}
+
+	// Seeding again, limited to a bounding box, should only read from the cache
+
+	bound := &orb.Bound{
+		Min: orb.Point{-101, 39},
+		Max: orb.Point{-99, 40.5},
+	}
+
+	report, err = seedTiles(ctx, tiles_handler, layers, zooms, bound, 2)
+
+	if err != nil {
+		t.Fatalf("Failed to seed tiles, %v", err)
+	}
+
+	if report.Tiles() != 6 || report.NonEmpty != 6 || report.Cached != 6 {
+		t.Fatalf("Unexpected report for bounding box: tiles %d, non-empty %d, cached %d", report.Tiles(), report.NonEmpty, report.Cached)
+	}
+}
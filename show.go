@@ -2,12 +2,20 @@ package show
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
 
+	"github.com/paulmach/orb"
 	"github.com/sfomuseum/go-geoparquet-show/static/www"
 	"github.com/sfomuseum/go-http-mvt"
 	www_show "github.com/sfomuseum/go-www-show/v2"
@@ -34,6 +42,229 @@ func RunWithFlagSet(ctx context.Context, fs *flag.FlagSet) error {
 // Run with launch a web server and browser serving GeoParquet data as vector tiles using configuration details provided by 'opts'
 func RunWithOptions(ctx context.Context, opts *RunOptions) error {
 
+	if opts.SeedZooms != nil && opts.TileCacheSize == 0 && opts.TileCacheDirectory == "" {
+		return fmt.Errorf("Seeding the tile cache requires a tile cache size or directory")
+	}
+
+	ts, err := setupTileset(ctx, opts)
+
+	if err != nil {
+		return err
+	}
+
+	handlers, err := newTilesetHandlers(ctx, opts, ts)
+
+	if err != nil {
+		ts.Close()
+		return err
+	}
+
+	r := newTilesetReloader(opts, ts, handlers)
+	defer r.Close()
+
+	r.Watch(ctx)
+
+	mux := http.NewServeMux()
+
+	www_fs := http.FS(www.FS)
+	mux.Handle("/", http.FileServer(www_fs))
+
+	mux.Handle("/map.json", r.MapConfigHandler)
+	mux.Handle("/tiles/", r.TilesHandler)
+	mux.Handle(features_path, r.FeaturesHandler)
+
+	// The tileset can be rebuilt (reloaded) when its data sources change or the process receives a SIGHUP signal,
+	// in which case browsers are notified using server-sent events.
+
+	if isReloadable(opts) {
+
+		mux.Handle(events_path, r.Events)
+
+		go func() {
+
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+
+			defer signal.Stop(hup)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-hup:
+
+					slog.Info("Received SIGHUP signal, reloading tileset")
+
+					err := r.Reload(ctx)
+
+					if err != nil {
+						slog.Error("Failed to reload tileset", "error", err)
+					}
+				}
+			}
+		}()
+
+		// The server is shut down (by the go-www-show package) when the process receives an interrupt
+		// signal which waits for all connections to close, including server-sent event streams.
+
+		go func() {
+
+			interrupt := make(chan os.Signal, 1)
+			signal.Notify(interrupt, os.Interrupt)
+
+			defer signal.Stop(interrupt)
+
+			select {
+			case <-ctx.Done():
+			case <-interrupt:
+			}
+
+			r.Events.Close()
+		}()
+	}
+
+	// https://github.com/sfomuseum/go-www-show
+
+	www_show_opts := &www_show.RunOptions{
+		Port:    opts.Port,
+		Mux:     mux,
+		Browser: opts.Browser,
+	}
+
+	return www_show.RunWithOptions(ctx, www_show_opts)
+}
+
+// tilesetHandlers defines the `http.Handler` instances derived from a tileset.
+type tilesetHandlers struct {
+	// The handler for the tileset's map config.
+	MapConfig http.Handler
+	// The handler for the tiles of the tileset's layers.
+	Tiles http.Handler
+	// The handler for retrieving complete features by ID.
+	Features http.Handler
+	// The tile cache used by 'Tiles', which may be nil.
+	Cache *TileCache
+}
+
+// newTilesetHandlers returns the `http.Handler` instances for the map config, tiles and features of 'ts' and the tile cache (if 'opts'
+// defines one) used by the tiles handler. If 'opts' defines a range of zoom levels to seed the tile cache with tiles are produced before returning.
+func newTilesetHandlers(ctx context.Context, opts *RunOptions, ts *tileset) (*tilesetHandlers, error) {
+
+	if isReloadable(opts) {
+		ts.Config.EventsURL = events_path
+	}
+
+	map_cfg_handler := mapConfigHandler(ts.Config)
+
+	tiles_handler, cache, err := newTilesHandler(opts, ts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SeedZooms != nil {
+
+		err := seedTileCache(ctx, opts, ts, tiles_handler)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ts.Archives) > 0 {
+		tiles_handler = withPMTiles(ts.Archives, tiles_handler)
+	}
+
+	handlers := &tilesetHandlers{
+		MapConfig: map_cfg_handler,
+		Tiles:     tiles_handler,
+		Features:  featuresHandler(ts.Lookups),
+		Cache:     cache,
+	}
+
+	return handlers, nil
+}
+
+// newTilesHandler returns a new `http.Handler` instance for producing the tiles for the layers in 'ts'. If 'opts' defines a tile cache
+// the handler stores tiles in, and reads tiles from, that cache which is also returned. Layers read from PMTiles archives are not handled.
+func newTilesHandler(opts *RunOptions, ts *tileset) (http.Handler, *TileCache, error) {
+
+	features_cb := GetFeaturesForLayersFunc(ts.Callbacks)
+
+	mvt_handler, err := newMVTHandler(features_cb)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tiles_handler := withTileStats(mvt_handler)
+
+	if opts.TileCacheSize == 0 && opts.TileCacheDirectory == "" {
+		return tiles_handler, nil, nil
+	}
+
+	cache, err := NewTileCache(opts.TileCacheSize, opts.TileCacheDirectory, opts.TileCacheDirectorySize)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to create tile cache, %w", err)
+	}
+
+	tiles_handler = withTileCache(cache, ts.Fingerprints, tiles_handler)
+	return tiles_handler, cache, nil
+}
+
+// newMVTHandler returns a new `sfomuseum/go-http-mvt` tile handler which encodes the features derived by 'features_cb' as
+// (uncompressed) MVT tiles. It is used to produce tiles which are served, cached, seeded and exported so that they are all encoded identically.
+func newMVTHandler(features_cb mvt.GetFeaturesCallbackFunc) (http.Handler, error) {
+
+	mvt_opts := &mvt.TileHandlerOptions{
+		GetFeaturesCallback: features_cb,
+		Simplify:            true,
+	}
+
+	return mvt.NewTileHandler(mvt_opts)
+}
+
+// tileset defines the vector tile layers derived from a `RunOptions` instance and the callback functions used to produce their tiles.
+type tileset struct {
+	// Configuration details for the layers, and their combined extent, used to display them in a map.
+	Config *mapConfig
+	// A dictionary of layer names and the callback functions used to derive the features in each layer's tiles.
+	Callbacks map[string]mvt.GetFeaturesCallbackFunc
+	// A dictionary of layer names and strings derived from the options used to produce each layer's tiles. See `layerFingerprint` for details.
+	Fingerprints *layerFingerprints
+	// The list of (non-query) data sources used by the layers.
+	Datasources []string
+	// A dictionary of layer names and the PMTiles archives that their tiles are read from.
+	Archives map[string]*pmtilesArchive
+	// The list of layers whose features can be retrieved by ID, in the order they are defined.
+	Lookups []*featureLookup
+	// The names of the (DuckDB) tables that the layers' data sources were materialized in to.
+	Tables []string
+}
+
+// The number of tilesets which have been set up, used to derive unique names for the tables each tileset materializes data sources in to.
+var tileset_generation atomic.Int64
+
+// Close closes any PMTiles archives opened by 'ts'.
+func (ts *tileset) Close() error {
+
+	for name, a := range ts.Archives {
+
+		err := a.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to close PMTiles archive for layer '%s', %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// setupTileset sets up the database and derives the vector tile layers, and the callback functions used to produce their tiles,
+// using configuration details provided by 'opts'.
+func setupTileset(ctx context.Context, opts *RunOptions) (*tileset, error) {
+
 	if opts.Verbose {
 		slog.SetLogLoggerLevel(slog.LevelDebug)
 		slog.Debug("Verbose logging enabled")
@@ -48,35 +279,394 @@ func RunWithOptions(ctx context.Context, opts *RunOptions) error {
 		slog.Warn("Rendering label properties in Leaflet maps is currently disabled.")
 	}
 
+	if !IsValidGeometryCollectionStrategy(opts.GeometryCollectionStrategy) {
+		return nil, fmt.Errorf("Invalid geometry collection strategy '%s'", opts.GeometryCollectionStrategy)
+	}
+
+	if opts.BinShape != "" && !IsValidBinShape(opts.BinShape) {
+		return nil, fmt.Errorf("Invalid bin shape '%s'", opts.BinShape)
+	}
+
+	if opts.ClusterMaxZoom > 0 && opts.ClusterGridSize != 0 && !IsValidClusterGridSize(opts.ClusterGridSize) {
+		return nil, fmt.Errorf("Invalid cluster grid size %d, must evenly divide the tile size (256 pixels)", opts.ClusterGridSize)
+	}
+
 	// START OF set up database
 
-	setup := []string{
-		"INSTALL spatial",
-		"LOAD spatial",
+	err := loadSpatialExtension(ctx, opts.Database, extensionOptions(opts))
+
+	if err != nil {
+		return nil, fmt.Errorf("Database setup failed, %w", err)
+	}
+
+	// START OF set up layers
+
+	generation := tileset_generation.Add(1)
+
+	layers := opts.Layers
+
+	if opts.Datasource != "" || opts.Query != "" {
+
+		if opts.Datasource != "" && opts.Query != "" {
+			return nil, fmt.Errorf("Data source and query options are mutually exclusive")
+		}
+
+		l := &Layer{
+			Name:       default_layer_name,
+			Datasource: opts.Datasource,
+			Query:      opts.Query,
+		}
+
+		layers = append([]*Layer{l}, layers...)
+	}
+
+	// Layers defined in a config file are read every time the tileset is set up so that changes
+	// are applied when the tileset is reloaded.
+
+	if opts.ConfigFile != "" {
+
+		cfg_layers, err := ReadLayersConfig(opts.ConfigFile)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read layers from config file, %w", err)
+		}
+
+		layers = append(slices.Clone(layers), cfg_layers...)
+	}
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("No data sources or layers defined")
 	}
 
-	for _, q := range setup {
+	// https://github.com/sfomuseum/go-http-mvt
+
+	callbacks := make(map[string]mvt.GetFeaturesCallbackFunc)
+
+	// Fingerprints of the options used to produce each layer's tiles, and the list of data
+	// sources to watch for changes, used by the tile cache (if enabled).
+
+	fingerprints := newLayerFingerprints()
+	datasources := make([]string, 0)
+
+	// Layers whose tiles are read directly from a PMTiles archive
+
+	archives := make(map[string]*pmtilesArchive)
+
+	// Layers whose features can be retrieved by ID
+
+	lookups := make([]*featureLookup, 0)
+
+	// Tables that data sources are materialized in to
+
+	tables := make([]string, 0)
+
+	// Release the tables materialized, and archives opened, for any layers set up before an error
+	// occurs since the tileset they belong to is never served.
+
+	complete := false
+
+	defer func() {
+
+		if complete {
+			return
+		}
+
+		err := releaseMaterializedTables(ctx, opts.Database, tables, nil)
+
+		if err != nil {
+			slog.Warn("Failed to release materialized tables", "error", err)
+		}
+
+		for _, a := range archives {
+			a.Close()
+		}
+	}()
+
+	map_cfg.Layers = make([]*mapLayerConfig, len(layers))
+
+	// Bins layers are appended to the list of layers, after all the other layers, so that
+	// they are drawn underneath them.
 
-		_, err := opts.Database.ExecContext(ctx, q)
+	bins_layers := make([]*mapLayerConfig, 0)
+
+	for idx, l := range layers {
+
+		_, exists := callbacks[l.Name]
+		_, is_archive := archives[l.Name]
+
+		if exists || is_archive {
+			return nil, fmt.Errorf("Duplicate layer name '%s'", l.Name)
+		}
+
+		// PMTiles archives are served as-is so none of the options used to query, filter
+		// or encode features apply to them.
+
+		if IsPMTiles(l.Datasource) {
+
+			archive, layer_cfg, err := setupPMTilesLayer(l)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to set up layer '%s', %w", l.Name, err)
+			}
+
+			archives[l.Name] = archive
+			map_cfg.Layers[idx] = layer_cfg
+			continue
+		}
+
+		features_opts, extent, err := setupLayer(ctx, opts, generation, idx, l)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to set up layer '%s', %w", l.Name, err)
+		}
+
+		callbacks[l.Name] = GetFeaturesForTileFunc(features_opts)
+
+		if features_opts.Table != "" {
+			tables = append(tables, features_opts.Table)
+		}
+
+		err = fingerprints.Add(l.Name, features_opts)
 
 		if err != nil {
-			return fmt.Errorf("Database setup command (%s) failed, %w", q, err)
+			return nil, fmt.Errorf("Failed to derive fingerprint for layer '%s', %w", l.Name, err)
+		}
+
+		if l.Datasource != "" {
+			datasources = append(datasources, l.Datasource)
+		}
+
+		source_layers := []string{
+			l.Name,
+		}
+
+		if features_opts.LayerBy != "" {
+
+			partitions, err := DerivePartitions(ctx, opts.Database, fromClause(features_opts), features_opts.Where, features_opts.LayerBy, l.Name)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to derive partitions for layer '%s', %w", l.Name, err)
+			}
+
+			slog.Debug("Partition layer", "layer", l.Name, "column", features_opts.LayerBy, "partitions", partitions)
+			source_layers = partitions
+		}
+
+		map_cfg.Layers[idx] = &mapLayerConfig{
+			Name:         l.Name,
+			SourceLayers: source_layers,
+			TilesURL:     fmt.Sprintf("/tiles/%s/{z}/{x}/{y}.mvt", l.Name),
+			Type:         layer_type_features,
+			MinZoom:      l.MinZoom,
+			MaxZoom:      l.MaxZoom,
+			MinX:         extent.Min[0],
+			MinY:         extent.Min[1],
+			MaxX:         extent.Max[0],
+			MaxY:         extent.Max[1],
+			IdColumn:     features_opts.IdColumn,
+		}
+
+		if features_opts.IdColumn != "" {
+
+			lookups = append(lookups, &featureLookup{
+				Layer:   l.Name,
+				Options: features_opts,
+			})
+
+			map_cfg.FeaturesURL = features_path
+		}
+
+		if features_opts.Bins != nil {
+
+			bins_name := l.Name + bins_layer_suffix
+
+			_, exists := callbacks[bins_name]
+
+			if exists {
+				return nil, fmt.Errorf("Duplicate layer name '%s'", bins_name)
+			}
+
+			err = fingerprints.Add(bins_name, features_opts)
+
+			if err != nil {
+				return nil, fmt.Errorf("Failed to derive fingerprint for layer '%s', %w", bins_name, err)
+			}
+
+			callbacks[bins_name] = GetBinsForTileFunc(features_opts)
+
+			bins_layers = append(bins_layers, &mapLayerConfig{
+				Name:         bins_name,
+				SourceLayers: []string{bins_name},
+				TilesURL:     fmt.Sprintf("/tiles/%s/{z}/{x}/{y}.mvt", bins_name),
+				Type:         layer_type_bins,
+				MinZoom:      l.MinZoom,
+				MaxZoom:      l.MaxZoom,
+				MinX:         extent.Min[0],
+				MinY:         extent.Min[1],
+				MaxX:         extent.Max[0],
+				MaxY:         extent.Max[1],
+			})
+		}
+	}
+
+	for idx, layer_cfg := range map_cfg.Layers {
+
+		if idx == 0 {
+			map_cfg.MinX = layer_cfg.MinX
+			map_cfg.MinY = layer_cfg.MinY
+			map_cfg.MaxX = layer_cfg.MaxX
+			map_cfg.MaxY = layer_cfg.MaxY
+		} else {
+			map_cfg.MinX = min(map_cfg.MinX, layer_cfg.MinX)
+			map_cfg.MinY = min(map_cfg.MinY, layer_cfg.MinY)
+			map_cfg.MaxX = max(map_cfg.MaxX, layer_cfg.MaxX)
+			map_cfg.MaxY = max(map_cfg.MaxY, layer_cfg.MaxY)
 		}
 	}
 
+	map_cfg.Layers = append(map_cfg.Layers, bins_layers...)
+
+	// END OF set up layers
+
+	ts := &tileset{
+		Config:       map_cfg,
+		Callbacks:    callbacks,
+		Fingerprints: fingerprints,
+		Datasources:  datasources,
+		Archives:     archives,
+		Lookups:      lookups,
+		Tables:       tables,
+	}
+
+	complete = true
+	return ts, nil
+}
+
+// readOptions returns the options to pass to the DuckDB `read_parquet` function derived from 'opts'.
+func readOptions(opts *RunOptions) *ParquetReadOptions {
+
+	read_opts := &ParquetReadOptions{
+		HivePartitioning: opts.HivePartitioning,
+		UnionByName:      opts.UnionByName,
+		Filename:         opts.FilenameProperty,
+	}
+
+	return read_opts
+}
+
+// setupLayer derives the `GetFeaturesForTileFuncOptions` used to query the data for 'layer', and the extent of that data,
+// using configuration details provided by 'opts'. 'idx' is the (unique) position of 'layer' in the list of layers being served and
+// 'generation' is the (unique) number of the tileset being set up, both of which are used to name the table 'layer' is materialized in to.
+func setupLayer(ctx context.Context, opts *RunOptions, generation int64, idx int, layer *Layer) (*GetFeaturesForTileFuncOptions, orb.Bound, error) {
+
+	var extent orb.Bound
+
+	properties := &PropertyRules{
+		Include:         opts.IncludeProperties,
+		Exclude:         opts.ExcludeProperties,
+		ZoomRules:       opts.ZoomProperties,
+		DropNulls:       opts.DropNullProperties,
+		MaxStringLength: opts.MaxStringLength,
+	}
+
+	err := properties.Validate()
+
+	if err != nil {
+		return nil, extent, fmt.Errorf("Invalid property rules, %w", err)
+	}
+
+	features_opts := &GetFeaturesForTileFuncOptions{
+		Database:                   opts.Database,
+		Datasource:                 layer.Datasource,
+		Query:                      layer.Query,
+		ReadOptions:                readOptions(opts),
+		GeometryCollectionStrategy: opts.GeometryCollectionStrategy,
+		LayerBy:                    opts.LayerBy,
+		Where:                      opts.Where,
+		MinZoom:                    layer.MinZoom,
+		MaxZoom:                    layer.MaxZoom,
+		MinZoomColumn:              opts.MinZoomColumn,
+		MaxFeatures:                opts.MaxFeaturesPerTile,
+		OrderBy:                    opts.OrderBy,
+		Properties:                 properties,
+		IdColumn:                   opts.IdColumn,
+	}
+
+	if layer.Where != "" {
+		features_opts.Where = layer.Where
+	}
+
+	if layer.MaxZoom > 0 && layer.MinZoom > layer.MaxZoom {
+		return nil, extent, fmt.Errorf("Minimum zoom level (%d) is greater than maximum zoom level (%d)", layer.MinZoom, layer.MaxZoom)
+	}
+
+	// START OF read GeoParquet metadata
+
+	// Queries don't have GeoParquet metadata so geometries are assumed to be in a column named
+	// "geometry" (or the -geometry-column flag) and their encoding is derived from the column type.
+
+	var geo_md *GeoMetadata
+
+	if layer.Query == "" {
+
+		md, err := ReadGeoMetadata(ctx, opts.Database, layer.Datasource)
+
+		if err != nil {
+			slog.Warn("Failed to read GeoParquet metadata", "layer", layer.Name, "error", err)
+		}
+
+		geo_md = md
+	}
+
+	geom_col, geom_md, err := geo_md.GeometryColumn(opts.GeometryColumn)
+
+	if err != nil {
+		return nil, extent, fmt.Errorf("Failed to determine geometry column, %w", err)
+	}
+
+	if !IsSupportedEncoding(geom_md.Encoding) {
+		return nil, extent, fmt.Errorf("Unsupported encoding (%s) for geometry column '%s'", geom_md.Encoding, geom_col)
+	}
+
+	slog.Debug("Use geometry column", "layer", layer.Name, "column", geom_col, "encoding", geom_md.Encoding, "geometry_types", geom_md.GeometryTypes)
+
+	features_opts.GeometryColumn = geom_col
+	features_opts.GeometryEncoding = geom_md.Encoding
+
+	source_crs := opts.SourceCRS
+
+	if source_crs == "" {
+
+		crs, err := geom_md.SourceCRS()
+
+		if err != nil {
+			return nil, extent, fmt.Errorf("Failed to derive source CRS from GeoParquet metadata, %w", err)
+		}
+
+		source_crs = crs
+	}
+
+	if !isDisplayCRS(source_crs) {
+		slog.Debug("Transform geometries", "layer", layer.Name, "source_crs", source_crs, "target_crs", display_crs)
+		features_opts.SourceCRS = source_crs
+	}
+
+	// END OF read GeoParquet metadata
+
 	table_cols := make([]string, 0)
+	table_types := make(map[string]string)
 
 	// START OF get table defs
 
 	// Update to use https://www.markhneedham.com/blog/2024/09/22/duckdb-dynamic-column-selection/
 
-	q := fmt.Sprintf(`DESCRIBE SELECT * FROM read_parquet("%s")`, opts.Datasource)
+	q := fmt.Sprintf(`DESCRIBE SELECT * FROM %s`, fromClause(features_opts))
 
 	rows, err := opts.Database.QueryContext(ctx, q)
 
 	if err != nil {
 		slog.Error("Failed to query database", "error", err, "query", q)
-		return fmt.Errorf("Failed to query database, %w", err)
+		return nil, extent, fmt.Errorf("Failed to query database, %w", err)
 	}
 
 	defer rows.Close()
@@ -94,91 +684,405 @@ func RunWithOptions(ctx context.Context, opts *RunOptions) error {
 
 		if err != nil {
 			slog.Error("Failed to scan row", "error", err)
-			return fmt.Errorf("Failed to scan row, %w", err)
+			return nil, extent, fmt.Errorf("Failed to scan row, %w", err)
 		}
 
 		// slog.Debug("Column definition", "name", col_name, "type", col_type)
+
+		// Skip any other geometry columns since they can't be encoded as (vector tile) properties
+
+		if col_name != geom_col && geo_md != nil {
+
+			_, is_geom := geo_md.Columns[col_name]
+
+			if is_geom {
+				continue
+			}
+		}
+
 		table_cols = append(table_cols, col_name)
+		table_types[col_name] = col_type
 	}
 
 	err = rows.Err()
 
 	if err != nil {
-		return fmt.Errorf("There was a problem scanning rows, %w", err)
+		return nil, extent, fmt.Errorf("There was a problem scanning rows, %w", err)
+	}
+
+	geom_type, exists := table_types[geom_col]
+
+	if !exists {
+		return nil, extent, fmt.Errorf("Data source does not contain a geometry column named '%s'", geom_col)
+	}
+
+	if strings.ToUpper(geom_type) == "GEOMETRY" {
+
+		// The data source already yields (DuckDB spatial) GEOMETRY values, for example the results
+		// of a query or GeoParquet files read by DuckDB with the spatial extension loaded, so
+		// there is nothing to decode.
+
+		features_opts.GeometryEncoding = ENCODING_GEOMETRY
+
+	} else if strings.ToUpper(geom_type) == "VARCHAR" {
+
+		// WKB-encoded geometries are stored as BLOBs so strings are assumed to be WKT, for example
+		// the results of a query reading a CSV file.
+
+		features_opts.GeometryEncoding = ENCODING_WKT
 	}
 
 	// END OF get table defs
 
+	// START OF materialize data source
+
+	if opts.Materialize {
+
+		materialize_opts := &MaterializeOptions{
+			Database:         opts.Database,
+			Name:             fmt.Sprintf("%s_%d_%d", materialized_table, generation, idx),
+			Datasource:       layer.Datasource,
+			ReadOptions:      features_opts.ReadOptions,
+			Query:            layer.Query,
+			GeometryColumn:   geom_col,
+			GeometryEncoding: features_opts.GeometryEncoding,
+			CacheDirectory:   opts.MaterializeCache,
+		}
+
+		table, err := Materialize(ctx, materialize_opts)
+
+		if err != nil {
+			return nil, extent, fmt.Errorf("Failed to materialize data source, %w", err)
+		}
+
+		features_opts.Table = table
+	}
+
+	// END OF materialize data source
+
+	if features_opts.Where != "" {
+
+		err := ValidateWhere(ctx, opts.Database, fromClause(features_opts), features_opts.Where)
+
+		if err != nil {
+			return nil, extent, err
+		}
+
+		slog.Debug("Filter features", "layer", layer.Name, "where", features_opts.Where)
+	}
+
+	if features_opts.LayerBy != "" {
+
+		_, exists := table_types[features_opts.LayerBy]
+
+		if !exists {
+			return nil, extent, fmt.Errorf("Data source does not contain a column named '%s' to partition layers by", features_opts.LayerBy)
+		}
+	}
+
+	if features_opts.OrderBy != "" {
+
+		err := ValidateOrderBy(ctx, opts.Database, fromClause(features_opts), features_opts.OrderBy)
+
+		if err != nil {
+			return nil, extent, err
+		}
+	}
+
+	if opts.BinShape != "" {
+
+		for _, a := range opts.BinAggregates {
+
+			_, exists := table_types[a.Column]
+
+			if !exists {
+				return nil, extent, fmt.Errorf("Data source does not contain a column named '%s' to aggregate", a.Column)
+			}
+		}
+
+		features_opts.Bins = &BinOptions{
+			Shape:      opts.BinShape,
+			Size:       opts.BinSize,
+			Aggregates: opts.BinAggregates,
+		}
+	}
+
+	if opts.ClusterMaxZoom > 0 {
+
+		for _, a := range opts.ClusterAggregates {
+
+			_, exists := table_types[a.Column]
+
+			if !exists {
+				return nil, extent, fmt.Errorf("Data source does not contain a column named '%s' to aggregate", a.Column)
+			}
+		}
+
+		features_opts.Cluster = &ClusterOptions{
+			MaxZoom:    opts.ClusterMaxZoom,
+			GridSize:   opts.ClusterGridSize,
+			Aggregates: opts.ClusterAggregates,
+		}
+	}
+
+	if features_opts.MinZoomColumn != "" {
+
+		_, exists := table_types[features_opts.MinZoomColumn]
+
+		if !exists {
+			return nil, extent, fmt.Errorf("Data source does not contain a column named '%s' to derive minimum zoom levels from", features_opts.MinZoomColumn)
+		}
+	}
+
+	if features_opts.IdColumn != "" {
+
+		id_type, exists := table_types[features_opts.IdColumn]
+
+		if !exists {
+			return nil, extent, fmt.Errorf("Data source does not contain a column named '%s' to identify features with", features_opts.IdColumn)
+		}
+
+		features_opts.IdColumnType = id_type
+	}
+
+	features_opts.TableColumns = table_cols
+
+	// START OF bbox columns
+
+	bbox_cols := DeriveBboxColumns(geo_md, geom_col, table_types)
+
+	bbox_cols = bbox_cols.Merge(&BboxColumns{
+		MinX: opts.MinXColumn,
+		MinY: opts.MinYColumn,
+		MaxX: opts.MaxXColumn,
+		MaxY: opts.MaxYColumn,
+	})
+
+	if !bbox_cols.IsZero() {
+		slog.Debug("Use bounding box columns", "layer", layer.Name, "minx", bbox_cols.MinX, "miny", bbox_cols.MinY, "maxx", bbox_cols.MaxX, "maxy", bbox_cols.MaxY)
+	}
+
+	features_opts.BboxColumns = bbox_cols
+
+	// END OF bbox columns
+
+	// START OF source files
+
+	// Files whose bounding box does not intersect a tile are skipped by filtering on the "filename" column and row groups whose
+	// bounding box (derived from the statistics for the bbox columns) does not intersect a tile are skipped by filtering on the
+	// "file_row_number" column. Both columns are added to the data source if necessary but not assigned as properties.
+
+	if layer.Query == "" && features_opts.Table == "" {
+
+		files, err := DeriveSourceFiles(ctx, opts.Database, layer.Datasource, geom_col, bbox_cols)
+
+		if err != nil {
+			slog.Warn("Failed to derive source files, files and row groups will not be pruned", "layer", layer.Name, "error", err)
+		} else {
+
+			bounded_files := 0
+			row_groups := 0
+			bounded_row_groups := 0
+
+			for _, f := range files {
+
+				if f.Bound != nil {
+					bounded_files += 1
+				}
+
+				for _, rg := range f.RowGroups {
+
+					row_groups += 1
+
+					if rg.Bound != nil {
+						bounded_row_groups += 1
+					}
+				}
+			}
+
+			prune_files := len(files) > 1 && bounded_files > 0
+			prune_row_groups := row_groups > len(files) && bounded_row_groups > 0
+
+			if prune_files {
+
+				_, has_filename := table_types[filename_column]
+
+				if has_filename && !features_opts.ReadOptions.Filename {
+					slog.Warn("Data source contains a column named 'filename', files will not be pruned", "layer", layer.Name)
+					prune_files = false
+				}
+			}
+
+			if prune_row_groups {
+
+				_, has_row_number := table_types[file_row_number_column]
+
+				if has_row_number {
+					slog.Warn("Data source contains a column named 'file_row_number', row groups will not be pruned", "layer", layer.Name)
+					prune_row_groups = false
+				}
+			}
+
+			if prune_files || prune_row_groups {
+
+				slog.Debug("Prune source files", "layer", layer.Name, "files", len(files), "files_with_bounds", bounded_files, "row_groups", row_groups, "row_groups_with_bounds", bounded_row_groups)
+
+				features_opts.Files = files
+				features_opts.ReadOptions.Filename = features_opts.ReadOptions.Filename || prune_files
+				features_opts.ReadOptions.FileRowNumber = prune_row_groups
+
+			} else {
+				slog.Debug("Source files and row groups will not be pruned", "layer", layer.Name, "files", len(files), "row_groups", row_groups)
+			}
+		}
+	}
+
+	// END OF source files
+
+	// START OF feature order
+
+	// Tiles truncated to 'MaxFeatures' are ordered by the position of each feature in the file it was read from, when there is no ID
+	// column, so that the same features are kept for every request. See `orderTiebreakers` for the other cases.
+
+	if features_opts.MaxFeatures > 0 && features_opts.IdColumn == "" && layer.Query == "" && features_opts.Table == "" {
+
+		_, has_filename := table_types[filename_column]
+		_, has_row_number := table_types[file_row_number_column]
+
+		if has_row_number || (has_filename && !features_opts.ReadOptions.Filename) {
+			slog.Debug("Data source contains a 'filename' or 'file_row_number' column, truncated tiles will be ordered by all columns", "layer", layer.Name)
+		} else {
+			features_opts.ReadOptions.Filename = true
+			features_opts.ReadOptions.FileRowNumber = true
+		}
+	}
+
+	// END OF feature order
+
 	// START OF feature(s) extent
 
-	extent_q := fmt.Sprintf(`SELECT MIN(ST_XMin(ST_GeomFromWKB(geometry::WKB_BLOB))) AS minx, MIN(ST_YMin(ST_GeomFromWKB(geometry::WKB_BLOB))) AS miny, MAX(ST_Xmax(ST_GeomFromWKB(geometry::WKB_BLOB))) AS maxx, MAX(ST_YMax(ST_GeomFromWKB(geometry::WKB_BLOB))) AS maxy FROM read_parquet("%s")`, opts.Datasource)
+	// The extent is calculated using untransformed geometries and then the extent itself
+	// is transformed (below) which is much faster than transforming every geometry.
 
-	extent_row := opts.Database.QueryRowContext(ctx, extent_q)
+	geom, err := sourceGeometryExpression(features_opts)
+
+	if err != nil {
+		return nil, extent, err
+	}
+
+	extent_q := fmt.Sprintf(`SELECT MIN(ST_XMin(%s)) AS minx, MIN(ST_YMin(%s)) AS miny, MAX(ST_Xmax(%s)) AS maxx, MAX(ST_YMax(%s)) AS maxy FROM %s%s`, geom, geom, geom, geom, fromClause(features_opts), whereClause(features_opts.Where))
 
-	var minx float64
-	var miny float64
-	var maxx float64
-	var maxy float64
+	extent_row := opts.Database.QueryRowContext(ctx, extent_q)
 
-	err = extent_row.Scan(&minx, &miny, &maxx, &maxy)
+	source_extent, ok, err := scanExtent(extent_row)
 
 	if err != nil {
-		return fmt.Errorf("Failed to derive database extent, %w", err)
+		return nil, extent, fmt.Errorf("Failed to derive database extent, %w", err)
+	}
+
+	// Layers whose data source is empty, or whose filter matches no features, are still served (and tiles
+	// will simply be empty) rather than preventing the tileset from being set up.
+
+	switch {
+	case !ok:
+
+		slog.Warn("Layer matches no features, using world extent", "layer", layer.Name)
+
+		extent = orb.Bound{
+			Min: orb.Point{-180, -max_mercator_lat},
+			Max: orb.Point{180, max_mercator_lat},
+		}
+
+	case features_opts.SourceCRS != "":
+
+		bound, err := transformBound(ctx, opts.Database, source_extent, features_opts.SourceCRS, display_crs)
+
+		if err != nil {
+			return nil, extent, fmt.Errorf("Failed to transform database extent, %w", err)
+		}
+
+		extent = bound
+
+	default:
+		extent = source_extent
 	}
 
-	map_cfg.MinX = minx
-	map_cfg.MinY = miny
-	map_cfg.MaxX = maxx
-	map_cfg.MaxY = maxy
+	features_opts.Extent = &extent
 
 	// END OF feature(s) extent
 
-	mux := http.NewServeMux()
+	return features_opts, extent, nil
+}
 
-	www_fs := http.FS(www.FS)
-	mux.Handle("/", http.FileServer(www_fs))
+// scanExtent scans the minimum and maximum X and Y values, in that order, from 'row' returning false if they are NULL, which is
+// the case when the query they were derived from matched no rows.
+func scanExtent(row *sql.Row) (orb.Bound, bool, error) {
 
-	map_cfg_handler := mapConfigHandler(map_cfg)
-	mux.Handle("/map.json", map_cfg_handler)
+	var minx sql.NullFloat64
+	var miny sql.NullFloat64
+	var maxx sql.NullFloat64
+	var maxy sql.NullFloat64
 
-	// https://github.com/sfomuseum/go-http-mvt
+	err := row.Scan(&minx, &miny, &maxx, &maxy)
 
-	features_opts := &GetFeaturesForTileFuncOptions{
-		Database:     opts.Database,
-		Datasource:   opts.Datasource,
-		TableColumns: table_cols,
-		MaxXColumn:   opts.MaxXColumn,
-		MaxYColumn:   opts.MaxYColumn,
+	if err != nil {
+		return orb.Bound{}, false, err
 	}
 
-	features_cb := GetFeaturesForTileFunc(features_opts)
+	if !minx.Valid || !miny.Valid || !maxx.Valid || !maxy.Valid {
+		return orb.Bound{}, false, nil
+	}
 
-	mvt_opts := &mvt.TileHandlerOptions{
-		GetFeaturesCallback: features_cb,
-		Simplify:            true,
+	bound := orb.Bound{
+		Min: orb.Point{minx.Float64, miny.Float64},
+		Max: orb.Point{maxx.Float64, maxy.Float64},
+	}
+
+	return bound, true, nil
+}
+
+// setupPMTilesLayer opens the PMTiles archive for 'layer' and derives the configuration details used to display its tiles.
+func setupPMTilesLayer(layer *Layer) (*pmtilesArchive, *mapLayerConfig, error) {
+
+	if layer.Where != "" {
+		return nil, nil, fmt.Errorf("Filters are not supported for PMTiles data sources")
 	}
 
-	mvt_handler, err := mvt.NewTileHandler(mvt_opts)
+	archive, err := openPMTilesArchive(layer.Datasource)
 
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("Failed to open PMTiles archive, %w", err)
 	}
 
-	// https://github.com/victorspringer/http-cache/
-	// Initial tests suggest this still has problems
-	// (Whole zoom levels getting dropped for example)
+	source_layers := archive.VectorLayers
 
-	mux.Handle("/tiles/", mvt_handler)
+	if len(source_layers) == 0 {
+		archive.Close()
+		return nil, nil, fmt.Errorf("PMTiles archive does not define any vector layers in its metadata")
+	}
 
-	// https://github.com/sfomuseum/go-www-show
+	h := archive.Header
+	extent := h.Bound()
 
-	www_show_opts := &www_show.RunOptions{
-		Port:    opts.Port,
-		Mux:     mux,
-		Browser: opts.Browser,
+	slog.Debug("Serve PMTiles archive", "layer", layer.Name, "path", layer.Datasource, "min_zoom", h.MinZoom, "max_zoom", h.MaxZoom, "vector_layers", source_layers)
+
+	// Tiles above the archive's maximum zoom level are derived (overzoomed) by the renderer.
+
+	layer_cfg := &mapLayerConfig{
+		Name:          layer.Name,
+		SourceLayers:  source_layers,
+		TilesURL:      fmt.Sprintf("/tiles/%s/{z}/{x}/{y}.mvt", layer.Name),
+		Type:          layer_type_features,
+		MinZoom:       max(layer.MinZoom, int(h.MinZoom)),
+		MaxZoom:       layer.MaxZoom,
+		MaxNativeZoom: int(h.MaxZoom),
+		MinX:          extent.Min[0],
+		MinY:          extent.Min[1],
+		MaxX:          extent.Max[0],
+		MaxY:          extent.Max[1],
 	}
 
-	return www_show.RunWithOptions(ctx, www_show_opts)
+	return archive, layer_cfg, nil
 }
 
 func mapConfigHandler(cfg *mapConfig) http.Handler {
@@ -0,0 +1,74 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+func TestScanExtent(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	q := `SELECT MIN(x), MIN(y), MAX(x), MAX(y) FROM (VALUES (-122.5::DOUBLE, 37.5::DOUBLE), (-122.0, 38.0)) AS points(x, y) WHERE %s`
+
+	bound, ok, err := scanExtent(db.QueryRowContext(ctx, fmt.Sprintf(q, "true")))
+
+	if err != nil || !ok {
+		t.Fatalf("Failed to scan extent, %v", err)
+	}
+
+	if bound.Min[0] != -122.5 || bound.Min[1] != 37.5 || bound.Max[0] != -122.0 || bound.Max[1] != 38.0 {
+		t.Fatalf("Unexpected extent: %v", bound)
+	}
+
+	// Aggregating no rows yields NULL values, which are reported rather than failing
+
+	_, ok, err = scanExtent(db.QueryRowContext(ctx, fmt.Sprintf(q, "false")))
+
+	if err != nil {
+		t.Fatalf("Failed to scan empty extent, %v", err)
+	}
+
+	if ok {
+		t.Fatalf("Expected empty extent not to be valid")
+	}
+}
+
+func TestSetupLayerNoFeatures(t *testing.T) {
+
+	ctx := context.Background()
+
+	tile_opts := countriesTileOptions(t)
+
+	opts := &RunOptions{
+		Database: tile_opts.Database,
+	}
+
+	layer := &Layer{
+		Name:       "countries",
+		Datasource: countries_fixture,
+		Where:      `"id" = 'XYZ'`,
+	}
+
+	features_opts, extent, err := setupLayer(ctx, opts, 1, 0, layer)
+
+	if err != nil {
+		t.Fatalf("Expected layer matching no features to be set up, %v", err)
+	}
+
+	if extent.Min[0] != -180 || extent.Max[0] != 180 || features_opts.Extent == nil {
+		t.Fatalf("Expected layer matching no features to use world extent, got %v", extent)
+	}
+}
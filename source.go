@@ -0,0 +1,203 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The interval at which (local) data sources are checked for changes.
+const source_poll_interval time.Duration = 5 * time.Second
+
+// sourceFiles returns the sorted list of local files matching 'datasource', which may be a path, a glob pattern or a comma-separated
+// list of paths and glob patterns. Remote data sources (for example "s3://...") and data sources which do not match any files are ignored.
+func sourceFiles(datasource string) []string {
+
+	files := make([]string, 0)
+
+	for _, p := range datasourcePaths(datasource) {
+
+		if strings.Contains(p, "://") {
+			continue
+		}
+
+		paths, err := globFiles(p)
+
+		if err != nil {
+			continue
+		}
+
+		files = append(files, paths...)
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+// globFiles returns the list of files matching 'pattern' using the same rules as DuckDB: "*", "?" and "[...]" match within a single
+// path segment, as they do for `filepath.Glob`, and a "**" segment matches zero or more directories.
+func globFiles(pattern string) ([]string, error) {
+
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(pattern)
+	}
+
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+
+	// Walk the directory preceding the first segment containing a pattern, rather than the entire file system.
+
+	idx := slices.IndexFunc(segments, func(seg string) bool {
+		return strings.ContainsAny(seg, "*?[")
+	})
+
+	root := filepath.FromSlash(strings.Join(segments[:idx], "/"))
+
+	if root == "" {
+
+		root = "."
+
+		if filepath.IsAbs(pattern) {
+			root = string(filepath.Separator)
+		}
+	}
+
+	for _, seg := range segments[idx:] {
+
+		_, err := filepath.Match(seg, "")
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	paths := make([]string, 0)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		rel_path, err := filepath.Rel(root, path)
+
+		if err != nil {
+			return err
+		}
+
+		if matchSegments(segments[idx:], strings.Split(filepath.ToSlash(rel_path), "/")) {
+			paths = append(paths, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// matchSegments returns true if each of the path segments in 'parts' matches the glob pattern segments in 'patterns', where a "**"
+// pattern segment matches zero or more path segments.
+func matchSegments(patterns []string, parts []string) bool {
+
+	if len(patterns) == 0 {
+		return len(parts) == 0
+	}
+
+	if patterns[0] == "**" {
+
+		for i := 0; i <= len(parts); i++ {
+
+			if matchSegments(patterns[1:], parts[i:]) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(parts) == 0 {
+		return false
+	}
+
+	ok, _ := filepath.Match(patterns[0], parts[0])
+
+	return ok && matchSegments(patterns[1:], parts[1:])
+}
+
+// sourceState returns a string derived from the path, size and modification time of each of the local files matching
+// 'datasource'. The state of data sources which are not local files is always an empty string.
+func sourceState(datasource string) string {
+
+	parts := make([]string, 0)
+
+	for _, path := range sourceFiles(datasource) {
+
+		info, err := os.Stat(path)
+
+		if err != nil {
+			continue
+		}
+
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", path, info.Size(), info.ModTime().UnixNano()))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// watchSources polls the local files matching each of 'datasources', every 'interval', and invokes 'on_change' with the list
+// of data sources whose state (see `sourceState`) has changed. It returns when 'ctx' is cancelled.
+func watchSources(ctx context.Context, datasources []string, interval time.Duration, on_change func([]string)) {
+
+	states := make(map[string]string)
+
+	for _, ds := range datasources {
+		states[ds] = sourceState(ds)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+
+			changed := make([]string, 0)
+
+			for _, ds := range datasources {
+
+				state := sourceState(ds)
+
+				if state != states[ds] {
+					states[ds] = state
+					changed = append(changed, ds)
+				}
+			}
+
+			if len(changed) > 0 {
+				slog.Debug("Data sources changed", "datasources", changed)
+				on_change(changed)
+			}
+		}
+	}
+}
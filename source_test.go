@@ -0,0 +1,106 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	_ "github.com/marcboeker/go-duckdb"
+)
+
+func TestGlobFiles(t *testing.T) {
+
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	files := []string{
+		"a.parquet",
+		"b.csv",
+		"2024/c.parquet",
+		"2024/01/d.parquet",
+		"2024/01/e.csv",
+		"2025/02/03/f.parquet",
+	}
+
+	for _, rel_path := range files {
+
+		path := filepath.Join(root, rel_path)
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			t.Fatalf("Failed to create directory for %s, %v", path, err)
+		}
+
+		err = os.WriteFile(path, []byte("test"), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+	}
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		t.Fatalf("Failed to open database, %v", err)
+	}
+
+	defer db.Close()
+
+	// Files must match the same patterns as they do when they are read by DuckDB
+
+	patterns := []string{
+		"*.parquet",
+		"**/*.parquet",
+		"*/**/*.parquet",
+		"2024/**/*.csv",
+		"**/0?/*.parquet",
+		"2025/**",
+		"**/[cd].parquet",
+	}
+
+	for _, pattern := range patterns {
+
+		pattern = filepath.Join(root, pattern)
+
+		paths, err := globFiles(pattern)
+
+		if err != nil {
+			t.Fatalf("Failed to glob '%s', %v", pattern, err)
+		}
+
+		rows, err := db.QueryContext(ctx, `SELECT file FROM glob(?)`, pattern)
+
+		if err != nil {
+			t.Fatalf("Failed to glob '%s' using DuckDB, %v", pattern, err)
+		}
+
+		expected := make([]string, 0)
+
+		for rows.Next() {
+
+			var path string
+
+			err := rows.Scan(&path)
+
+			if err != nil {
+				t.Fatalf("Failed to scan path, %v", err)
+			}
+
+			expected = append(expected, filepath.Clean(path))
+		}
+
+		rows.Close()
+
+		slices.Sort(paths)
+		slices.Sort(expected)
+
+		if len(expected) == 0 || !slices.Equal(paths, expected) {
+			t.Fatalf("Unexpected files for '%s': %v, expected %v", pattern, paths, expected)
+		}
+	}
+}
@@ -7,44 +7,351 @@ window.addEventListener("load", function load(event){
 	    [ cfg.maxy, cfg.maxx ],
 	];
 
-	// To do: Read this from cfg
-	var tiles_styles = {
+	var map = L.map('map');
+
+	var view = restore_view();
+
+	if (view){
+	    map.setView([ view.lat, view.lon ], view.zoom);
+	} else {
+	    map.fitBounds(bounds);
+	}
+
+	var overlays = {};
+	var tiles_urls = {};
+	var count_layers = cfg.layers.length;
+	var colour_idx = 0;
+	
+	for (var i=0; i < count_layers; i++){
+
+	    var layer_cfg = cfg.layers[i];
+	    var layer_name = layer_cfg.name;
+	    var source_layers = layer_cfg.source_layers;
+	    
+	    var tiles_styles = {};
+
+	    for (var j=0; j < source_layers.length; j++){
+
+		var style_func = (layer_cfg.type == "bins") ? leaflet_bins_style : leaflet_style;
+		
+		tiles_styles[ source_layers[j] ] = style_func(layer_colour(colour_idx));
+		colour_idx += 1;
+	    }
+	    
+	    var tiles_opts = {
+		rendererFactory: L.canvas.tile,
+		vectorTileLayerStyles: tiles_styles,
+		interactive: true,
+	    };
+
+	    if (layer_cfg.min_zoom){
+		tiles_opts.minZoom = layer_cfg.min_zoom;
+	    }
+
+	    if (layer_cfg.max_zoom){
+		tiles_opts.maxZoom = layer_cfg.max_zoom;
+	    }
+
+	    if (layer_cfg.max_native_zoom){
+		tiles_opts.maxNativeZoom = layer_cfg.max_native_zoom;
+	    }
 	    
-	    all: function(properties, zoom) {
-		return {
-		    weight: 2,
-		    color: 'red',
-		    opacity: .5,
-		    fillColor: 'yellow',
-		    fill: true,
-		    radius: 6,
-		    fillOpacity: 0.1
+	    var layer = L.vectorGrid.protobuf(layer_cfg.tiles_url, tiles_opts);
+
+	    // onclick events trigger mysterious "L.DomEvent._fakeStop is not a function" errors
+	    // https://github.com/Leaflet/Leaflet.VectorGrid/issues/148
+	    // layer.on('click', function(e) { ... })
+	    
+	    layer.addTo(map);
+	    overlays[layer_name] = layer;
+	    tiles_urls[layer_name] = layer_cfg.tiles_url;
+	}
+
+	if (count_layers > 1){
+	    L.control.layers(null, overlays).addTo(map);
+	}
+
+	return {
+	    view: function(){
+		var center = map.getCenter();
+		return { lat: center.lat, lon: center.lng, zoom: map.getZoom() };
+	    },
+	    refresh: function(version){
+
+		// Leaflet.VectorGrid.Protobuf layers do not have a setUrl method so the (template) URL is
+		// assigned directly before the layer's tiles are redrawn.
+		
+		for (var layer_name in overlays){
+		    var layer = overlays[layer_name];
+		    layer._url = tiles_urls[layer_name] + "?v=" + version;
+		    layer.redraw();
 		}
+	    },
+	};
+    };
+
+    // Return a Leaflet.VectorGrid style function for features drawn using 'colour'
+    
+    var leaflet_style = function(colour){
+
+	return function(properties, zoom) {
+
+	    var radius = 6;
+
+	    if (properties.point_count){
+		radius = cluster_radius(properties.point_count);
+	    }
+	    
+	    return {
+		weight: 2,
+		color: colour,
+		opacity: .5,
+		fillColor: colour,
+		fill: true,
+		radius: radius,
+		fillOpacity: 0.1
 	    }
 	};
+    };
+
+    // Return a Leaflet.VectorGrid style function for bins drawn using 'colour' whose opacity increases
+    // with the number of features in each bin
+
+    var leaflet_bins_style = function(colour){
+
+	return function(properties, zoom) {
+	    return {
+		weight: 0.5,
+		color: colour,
+		opacity: .5,
+		fillColor: colour,
+		fill: true,
+		fillOpacity: bins_opacity(properties.count),
+	    }
+	};
+    };
+
+    // Return the (fill) opacity for a bin containing 'count' features. Opacity is scaled logarithmically
+    // from 0.1 (1 feature) to 0.8 (10,000 or more features).
+    
+    var bins_opacity = function(count){
+	var scale = Math.min(1, Math.log(1 + (count || 0)) / Math.log(10000));
+	return 0.1 + (scale * 0.7);
+    };
+    
+    // Return the radius, in pixels, of a circle for a cluster of 'count' points. Clusters are
+    // produced by the server when the -cluster-max-zoom flag is set.
+    
+    var cluster_radius = function(count){
+	return Math.min(40, 6 + Math.sqrt(count) * 2);
+    };
+    
+    // A list of colours to assign to layers, in order
+    var layer_colours = [
+	'#cc6699',
+	'#3366cc',
+	'#339966',
+	'#ff9933',
+	'#9933cc',
+	'#cc3333',
+    ];
+
+    var layer_colour = function(idx){
+	return layer_colours[idx % layer_colours.length];
+    };
+
+    // Add the fill, line and point (MapLibre) layers for the vector tile layer 'source_layer' in the source
+    // named 'source_name' to 'map'. Layer IDs are prefixed with 'label'. 'layer_cfg' is the (map.json) configuration
+    // for the source whose zoom range is applied to each layer. Returns the list of layer IDs which should trigger popups.
+    
+    var add_maplibre_layer = function(map, source_name, source_layer, label, colour, layer_cfg){
+
+	var points_id = label + '-points';
+	var line_id = label + '-line';
+	var fill_id = label + '-fill';
+
+	// Note that MapLibre layers are hidden at zoom levels greater than or equal to 'maxzoom'
 	
-	var map = L.map('map');
-	map.fitBounds(bounds);
+	var minzoom = layer_cfg.min_zoom || 0;
+	var maxzoom = (layer_cfg.max_zoom) ? layer_cfg.max_zoom + 1 : 24;
+	
+	map.addLayer({
+	    'id': points_id,
+	    'minzoom': minzoom,
+	    'maxzoom': maxzoom,
+	    'type': 'circle',
+	    'source': source_name,
+	    'source-layer': source_layer,
+	    'paint': {
+		'circle-color': colour,
+		// 'circle-radius': 6,
+		// Not really sure I understand what's happening here
+		// Clusters (which have a "point_count" property) are sized by the number of points they contain
+		'circle-radius': [
+		    "case",
+		    ['has', 'point_count'],
+		    ['min', 40, ['+', 6, ['*', 2, ['sqrt', ['get', 'point_count']]]]],
+		    [
+			"interpolate", ["linear"], ["zoom"],
+			0, 0,
+			20, ['*', 2, ['get', 'amount']]
+		    ]
+		],
+		'circle-opacity': 0.5,
+		'circle-stroke-color': '#fff',
+		'circle-stroke-width': 1,
+	    }
+	});
 
-	// To do: Read this from cfg	
-	var tiles_url = "/tiles/all/{z}/{x}/{y}.mvt";
+	// START OF this is important
+	// Without this filter then the points layer renders layers for all the
+	// points AND all the centroids of all the other features because... computers?
+	// https://maplibre.org/maplibre-style-spec/expressions/#geometry-type
 	
-	var tiles_opts = {
-	    rendererFactory: L.canvas.tile,
-	    vectorTileLayerStyles: tiles_styles,
-	    interactive: true,
-	};
+	map.setFilter(points_id, ["any",
+				  ["==", ["geometry-type"], "Point"],
+				  ["==", ["geometry-type"], "MultiPoint"]
+	]);
 	
-	var layer = L.vectorGrid.protobuf(tiles_url, tiles_opts);
+	// END OF this is important	    
 
-	// onclick events trigger mysterious "L.DomEvent._fakeStop is not a function" errors
-	// https://github.com/Leaflet/Leaflet.VectorGrid/issues/148
-	// layer.on('click', function(e) { ... })
+	map.addLayer({
+	    'id': line_id,
+	    'minzoom': minzoom,
+	    'maxzoom': maxzoom,
+	    'type': 'line',
+	    'source': source_name,
+	    'source-layer': source_layer,
+	    'layout': {
+		'line-join': 'round',
+		'line-cap': 'round'
+	    },
+	    'paint': {
+		'line-color': '#000',
+		'line-width': 1,
+	    }
+	}, points_id);
 	
-	layer.addTo(map);
+	map.addLayer({
+	    'id': fill_id,
+	    'minzoom': minzoom,
+	    'maxzoom': maxzoom,
+	    'type': 'fill',
+	    'source': source_name,
+	    'source-layer': source_layer,
+	    'paint': {
+		// To do: change colour onclick
+		// https://gis.stackexchange.com/questions/349827/change-polygon-color-on-click-with-mapbox
+		'fill-color': colour,
+		'fill-opacity': 0.1,
+	    }
+	}, line_id);
+
+	return [
+	    fill_id,
+	    points_id,
+	];
+    };
+    
+    // Add the fill and line (MapLibre) layers for the bins layer 'source_layer' in the source named 'source_name'
+    // to 'map'. Layer IDs are prefixed with 'label'. Bins are drawn as a choropleth whose opacity increases with the
+    // number of features in each bin (see bins_opacity). Returns an empty list since bins do not trigger popups.
+
+    var add_maplibre_bins_layer = function(map, source_name, source_layer, label, colour, layer_cfg){
+
+	var line_id = label + '-line';
+	var fill_id = label + '-fill';
+
+	var minzoom = layer_cfg.min_zoom || 0;
+	var maxzoom = (layer_cfg.max_zoom) ? layer_cfg.max_zoom + 1 : 24;
+	
+	map.addLayer({
+	    'id': line_id,
+	    'minzoom': minzoom,
+	    'maxzoom': maxzoom,
+	    'type': 'line',
+	    'source': source_name,
+	    'source-layer': source_layer,
+	    'paint': {
+		'line-color': colour,
+		'line-width': 0.5,
+		'line-opacity': 0.5,
+	    }
+	});
 	
+	map.addLayer({
+	    'id': fill_id,
+	    'minzoom': minzoom,
+	    'maxzoom': maxzoom,
+	    'type': 'fill',
+	    'source': source_name,
+	    'source-layer': source_layer,
+	    'paint': {
+		'fill-color': colour,
+		'fill-opacity': [
+		    "interpolate", ["linear"],
+		    ["ln", ["+", 1, ["get", "count"]]],
+		    0, 0.1,
+		    Math.log(10000), 0.8
+		],
+	    }
+	}, line_id);
+
+	// Popups are not shown for bins
+	return [];
     };
+    
+    // Return a (MapLibre) control for toggling the visibility of 'groups' where each group is a dictionary
+    // containing a label and the list of (MapLibre) layer IDs it controls.
+    
+    var maplibre_layers_control = function(groups){
+
+	var container;
+	
+	return {
+	    onAdd: function(map){
+
+		container = document.createElement("div");
+		container.setAttribute("class", "maplibregl-ctrl maplibregl-ctrl-group show-layers-control");
 
+		var count_groups = groups.length;
+		
+		for (var i=0; i < count_groups; i++){
+
+		    var group = groups[i];
+		    
+		    var input = document.createElement("input");
+		    input.setAttribute("type", "checkbox");
+		    input.checked = true;
+
+		    input.onchange = function(layers){
+
+			return function(e){
+
+			    var visibility = (e.target.checked) ? "visible" : "none";
+			    
+			    for (var j=0; j < layers.length; j++){
+				map.setLayoutProperty(layers[j], "visibility", visibility);
+			    }
+			};
+			
+		    }(group.layers);
+		    
+		    var label = document.createElement("label");
+		    label.appendChild(input);
+		    label.appendChild(document.createTextNode(" " + group.label));
+
+		    container.appendChild(label);
+		}
+		
+		return container;
+	    },
+	    onRemove: function(){
+		container.parentNode.removeChild(container);
+	    },
+	};
+    };
+    
     var init_maplibre = function(cfg){
 
 	var bounds = [
@@ -52,11 +359,8 @@ window.addEventListener("load", function load(event){
 	    [ cfg.maxx, cfg.maxy ],
 	];
 
-	var tiles_url = "http://" + location.host + "/tiles/all/{z}/{x}/{y}.mvt";
-	
-	var map = new maplibregl.Map({
+	var map_opts = {
             container: 'map',
-	    bounds: bounds,
 	    // style: 'https://demotiles.maplibre.org/style.json',	    
 	    style: {
 		"id": "go-geoparquet-show",
@@ -80,85 +384,141 @@ window.addEventListener("load", function load(event){
 		"sources": {},
 		"version": 8
 	    }
-	});
+	};
+
+	var view = restore_view();
+
+	if (view){
+	    map_opts.center = [ view.lon, view.lat ];
+	    map_opts.zoom = view.zoom;
+	} else {
+	    map_opts.bounds = bounds;
+	}
+	
+	var map = new maplibregl.Map(map_opts);
 	
 	map.on('load', () => {
 
 	    try {
 		
-		map.addSource('all', {
-		    type: 'vector',
-		    tiles: [
-			tiles_url,
-		    ],
-		});
+		var popup_layers = [];
+		var control_groups = [];
+		
+		var count_layers = cfg.layers.length;
+		var colour_idx = 0;
+		
+		// Layers are added in reverse order so that the first layer is drawn on top
+
+		for (var i=count_layers - 1; i >= 0; i--){
+
+		    var layer_cfg = cfg.layers[i];
+		    var source_name = layer_cfg.name;
+		    var tiles_url = location.protocol + "//" + location.host + layer_cfg.tiles_url;
+		    
+		    var source_opts = {
+			type: 'vector',
+			tiles: [
+			    tiles_url,
+			],
+		    };
 
-		var l = map.addLayer({
-		    'id': 'all-points',
-		    'type': 'circle',
-		    'source': 'all',
-		    'source-layer': 'all',
-		    'paint': {
-			'circle-color': 'red',
-			// 'circle-radius': 6,
-			// Not really sure I understand what's happening here
-			'circle-radius': [
-			    "interpolate", ["linear"], ["zoom"],
-			    0, 0,
-			    20, ['*', 2, ['get', 'amount']]],
-			'circle-opacity': 0.5,
-			'circle-stroke-color': '#fff',
-			'circle-stroke-width': 1,
+		    // Tiles above this zoom level are derived (overzoomed) from tiles at this zoom level
+		    
+		    if (layer_cfg.max_native_zoom){
+			source_opts.maxzoom = layer_cfg.max_native_zoom;
 		    }
-		});
+		    
+		    map.addSource(source_name, source_opts);
 
-		// START OF this is important
-		// Without this filter then the all-points layer renders layers for all the
-		// points AND all the centroids of all the other features because... computers?
-		// https://maplibre.org/maplibre-style-spec/expressions/#geometry-type
-		
-		map.setFilter('all-points', ["any",
-					     ["==", ["geometry-type"], "Point"],
-					     ["==", ["geometry-type"], "MultiPoint"]
-		]);
-		
-		// END OF this is important	    
-
-		map.addLayer({
-		    'id': 'all-line',
-		    'type': 'line',
-		    'source': 'all',
-		    'source-layer': 'all',
-		    'layout': {
-			'line-join': 'round',
-			'line-cap': 'round'
-		    },
-		    'paint': {
-			'line-color': '#000',
-			'line-width': 1,
+		    var source_layers = layer_cfg.source_layers;
+
+		    for (var j=source_layers.length - 1; j >= 0; j--){
+
+			var source_layer = source_layers[j];
+			var label = source_name;
+
+			if (source_layer != source_name){
+			    label = source_name + "/" + source_layer;
+			}
+			
+			var add_func = (layer_cfg.type == "bins") ? add_maplibre_bins_layer : add_maplibre_layer;
+			var layer_ids = add_func(map, source_name, source_layer, label, layer_colour(colour_idx), layer_cfg);
+			colour_idx += 1;
+			
+			popup_layers = popup_layers.concat(layer_ids);
+
+			control_groups.unshift({
+			    label: label,
+			    layers: (layer_cfg.type == "bins") ? [ label + '-fill', label + '-line' ] : [ label + '-fill', label + '-line', label + '-points' ],
+			});
 		    }
-		}, 'all-points');
-		
-		
-		map.addLayer({
-		    'id': 'all-fill',
-		    'type': 'fill',
-		    'source': 'all',
-		    'source-layer': 'all',
-		    'paint': {
-			// To do: change colour onclick
-			// https://gis.stackexchange.com/questions/349827/change-polygon-color-on-click-with-mapbox
-			'fill-color': '#cc6699',
-			'fill-opacity': 0.1,
+		}
+
+		if (control_groups.length > 1){
+		    map.addControl(maplibre_layers_control(control_groups), 'top-right');
+		}
+		
+		// Layers whose complete features can be retrieved by ID, keyed by source name
+		
+		var lookup_layers = {};
+
+		if (cfg.features_url){
+		    
+		    for (var i=0; i < count_layers; i++){
+
+			var layer_cfg = cfg.layers[i];
+
+			if (layer_cfg.id_column){
+			    lookup_layers[layer_cfg.name] = layer_cfg;
+			}
 		    }
-		}, 'all-line');
+		}
 		
-			
-		var popup_layers = [
-		    'all-fill',
-		    'all-points',
-		];
+		// Show a popup with all the properties of the complete feature, retrieved from the server, that was
+		// clicked on. Returns false if the feature can not be retrieved by ID.
 		
+		var show_feature_popup = function(e){
+
+		    var f = e.features[0];
+		    var layer_cfg = lookup_layers[f.source];
+
+		    if (! layer_cfg){
+			return false;
+		    }
+
+		    var id = f.properties[ layer_cfg.id_column ];
+
+		    if ((id === undefined) || (id === null)){
+			return false;
+		    }
+
+		    var popup = new maplibregl.Popup({ maxWidth: '400px' })
+			.setLngLat(e.lngLat)
+			.setText("Loading feature " + id)
+			.addTo(map);
+		    
+		    var url = cfg.features_url + encodeURIComponent(id) + "?layer=" + encodeURIComponent(f.source);
+		    
+		    fetch(url)
+			.then((rsp) => {
+
+			    if (! rsp.ok){
+				throw new Error(rsp.status + " " + rsp.statusText);
+			    }
+
+			    return rsp.json();
+			})
+			.then((feature) => {
+			    popup.setDOMContent(feature_popup_content(feature));
+			}).catch((err) => {
+			    console.error("Failed to retrieve feature", id, err);
+			    popup.setText("Failed to retrieve feature " + id);
+			});
+
+		    return true;
+		};
+		
+		var show_popup = null;
 		var label_props = cfg.label_properties;
 		
 		if (label_props){
@@ -167,7 +527,7 @@ window.addEventListener("load", function load(event){
 		    
 		    if (count_props > 0) {
 			
-			var show_popup = function(e){
+			show_popup = function(e){
 			    
 			    var label_text = [];
 			    
@@ -187,14 +547,6 @@ window.addEventListener("load", function load(event){
 					  .addTo(map);
 			};
 			
-			for (i in popup_layers){
-			    
-			    var layer_id = popup_layers[i];
-			    
-			    map.on('click', layer_id, (e) => {
-				show_popup(e);
-			    });
-			}
 		    }
 		}
 		
@@ -202,6 +554,40 @@ window.addEventListener("load", function load(event){
 		    
 		    var layer_id = popup_layers[i];
 		    
+		    map.on('click', layer_id, (e) => {
+
+			// Clicking on a cluster zooms in to it (below) rather than showing a popup
+			
+			if (e.features[0].properties.point_count){
+			    return;
+			}
+
+			if (show_feature_popup(e)){
+			    return;
+			}
+
+			if (show_popup){
+			    show_popup(e);
+			}
+		    });
+		}
+		
+		for (i in popup_layers){
+		    
+		    var layer_id = popup_layers[i];
+
+		    map.on('click', layer_id, (e) => {
+
+			if (! e.features[0].properties.point_count){
+			    return;
+			}
+
+			map.easeTo({
+			    center: e.lngLat,
+			    zoom: map.getZoom() + 2,
+			});
+		    });
+		    
 		    map.on('mouseenter', layer_id, () => {
 			map.getCanvas().style.cursor = 'pointer';
 		    });
@@ -216,25 +602,175 @@ window.addEventListener("load", function load(event){
 	    }
 	});
 
-	return;
+	return {
+	    view: function(){
+		var center = map.getCenter();
+		return { lat: center.lat, lon: center.lng, zoom: map.getZoom() };
+	    },
+	    refresh: function(version){
+
+		var count_layers = cfg.layers.length;
+
+		for (var i=0; i < count_layers; i++){
+
+		    var layer_cfg = cfg.layers[i];
+		    var source = map.getSource(layer_cfg.name);
+
+		    // Sources are not added until the map has loaded
+		    
+		    if (! source){
+			continue;
+		    }
+		    
+		    var tiles_url = location.protocol + "//" + location.host + layer_cfg.tiles_url;
+		    source.setTiles([ tiles_url + "?v=" + version ]);
+		}
+	    },
+	};
+	
+    };
+
+    // Return the DOM content for a popup listing all the properties of the GeoJSON Feature 'feature', sorted by name,
+    // and a button to copy its (GeoJSON) geometry to the clipboard.
+    
+    var feature_popup_content = function(feature){
+
+	var container = document.createElement("div");
+	container.setAttribute("class", "show-feature");
+
+	var table = document.createElement("table");
+	var props = feature.properties || {};
+	var keys = Object.keys(props).sort();
+
+	for (var i=0; i < keys.length; i++){
+
+	    var k = keys[i];
+	    var v = props[k];
+
+	    if ((v !== null) && (typeof(v) == "object")){
+		v = JSON.stringify(v);
+	    }
+	    
+	    var th = document.createElement("th");
+	    th.textContent = k;
+
+	    var td = document.createElement("td");
+	    td.textContent = String(v);
+
+	    var tr = document.createElement("tr");
+	    tr.appendChild(th);
+	    tr.appendChild(td);
+	    
+	    table.appendChild(tr);
+	}
+
+	var button = document.createElement("button");
+	button.textContent = "Copy geometry";
+
+	button.onclick = function(){
+
+	    navigator.clipboard.writeText(JSON.stringify(feature.geometry)).then(() => {
+		button.textContent = "Copied geometry";
+	    }).catch((err) => {
+		console.error("Failed to copy geometry", err);
+		button.textContent = "Failed to copy geometry";
+	    });
+	};
 	
+	container.appendChild(table);
+	container.appendChild(button);
+	
+	return container;
+    };
+    
+    // The key used to store the current view (in session storage) when the page is reloaded because
+    // the layers being served have changed.
+    
+    var view_key = "go-geoparquet-show-view";
+
+    // Return the view (a dictionary containing lat, lon and zoom properties) saved before the page was last
+    // reloaded, removing it from session storage, or null if there is no saved view.
+    
+    var restore_view = function(){
+
+	try {
+	    
+	    var str_view = sessionStorage.getItem(view_key);
+
+	    if (! str_view){
+		return null;
+	    }
+
+	    sessionStorage.removeItem(view_key);
+	    return JSON.parse(str_view);
+	    
+	} catch(err) {
+	    console.warn("Failed to restore map view", err);
+	    return null;
+	}
+    };
+
+    // Return a string derived from the layers in 'cfg' used to determine whether the layers being served
+    // have changed (in which case the page is reloaded) or just their tiles.
+    
+    var layers_signature = function(cfg){
+
+	var layers = cfg.layers.map((l) => {
+	    return [ l.name, l.type, l.tiles_url, l.source_layers, l.min_zoom, l.max_zoom, l.max_native_zoom ];
+	});
+
+	return JSON.stringify(layers);
+    };
+    
+    // Listen for "reload" events, sent by the server when the tileset has been reloaded, and refresh the
+    // tiles for each layer of 'map_handle' (returned by init_leaflet or init_maplibre) without changing the
+    // current view. If the layers being served have changed the page is reloaded, restoring the current view.
+    
+    var listen_for_reloads = function(cfg, map_handle){
+
+	var events = new EventSource(cfg.events_url);
+
+	events.addEventListener("reload", (e) => {
+
+	    fetch("/map.json")
+		.then((rsp) => rsp.json())
+		.then((new_cfg) => {
+
+		    if (layers_signature(new_cfg) == layers_signature(cfg)){
+			map_handle.refresh(e.data);
+			return;
+		    }
+
+		    sessionStorage.setItem(view_key, JSON.stringify(map_handle.view()));
+		    location.reload();
+		    
+		}).catch((err) => {
+		    console.error("Failed to retrieve map config after reload", err);
+		});
+	});
     };
     
     var init = function(cfg){
 
 	try {
 	    
+	    var map_handle;
+	    
 	    switch (cfg.renderer){
 		case "maplibre":
-		    init_maplibre(cfg);
+		    map_handle = init_maplibre(cfg);
 		    break;
 		default:
 		    if (cfg.renderer != "leaflet"){
 			log.warn("Unknown renderer, defaulting to leaflet", cfg.renderer)
 		    }
-		    init_leaflet(cfg);
+		    map_handle = init_leaflet(cfg);
 		    break;
 	    }
+
+	    if (cfg.events_url){
+		listen_for_reloads(cfg, map_handle);
+	    }
 	    
 	} catch(err) {
 	    console.error("Failed to initialize map", err);
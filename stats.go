@@ -0,0 +1,96 @@
+package show
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// The name of the HTTP response header reporting the maximum number of features that were included in a truncated tile.
+const truncated_header string = "X-Features-Truncated"
+
+type tileStatsKey struct{}
+
+// tileStats records details about the features in a tile, while it is being produced, that are reported back to the client.
+type tileStats struct {
+	mu        sync.Mutex
+	truncated int
+}
+
+// withTileStats returns a new `http.Handler` which assigns a `tileStats` instance to each request's context and reports
+// its contents as HTTP response headers once 'next' starts writing its response.
+func withTileStats(next http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		stats := &tileStats{}
+
+		ctx := context.WithValue(req.Context(), tileStatsKey{}, stats)
+		req = req.WithContext(ctx)
+
+		stats_rsp := &tileStatsResponseWriter{
+			ResponseWriter: rsp,
+			stats:          stats,
+		}
+
+		next.ServeHTTP(stats_rsp, req)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// recordTruncated records that the features in the tile being produced for 'ctx' were limited to 'limit'. It is a no-op if 'ctx'
+// was not created by `withTileStats`.
+func recordTruncated(ctx context.Context, limit int) {
+
+	v := ctx.Value(tileStatsKey{})
+
+	if v == nil {
+		return
+	}
+
+	stats := v.(*tileStats)
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.truncated = limit
+}
+
+// tileStatsResponseWriter implements the `http.ResponseWriter` interface, adding headers derived from a `tileStats`
+// instance before the response is written.
+type tileStatsResponseWriter struct {
+	http.ResponseWriter
+	stats       *tileStats
+	wroteHeader bool
+}
+
+func (w *tileStatsResponseWriter) WriteHeader(status_code int) {
+
+	if !w.wroteHeader {
+		w.setHeaders()
+		w.wroteHeader = true
+	}
+
+	w.ResponseWriter.WriteHeader(status_code)
+}
+
+func (w *tileStatsResponseWriter) Write(b []byte) (int, error) {
+
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *tileStatsResponseWriter) setHeaders() {
+
+	w.stats.mu.Lock()
+	defer w.stats.mu.Unlock()
+
+	if w.stats.truncated > 0 {
+		w.Header().Set(truncated_header, strconv.Itoa(w.stats.truncated))
+	}
+}
@@ -0,0 +1,90 @@
+package show
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithTileStats(t *testing.T) {
+
+	truncate := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.URL.Path == "/tiles/all/1/0/0.mvt" {
+			recordTruncated(req.Context(), 100)
+		}
+
+		rsp.Write([]byte("tile"))
+	}
+
+	handler := withTileStats(http.HandlerFunc(truncate))
+
+	tests := map[string]string{
+		"/tiles/all/1/0/0.mvt": "100",
+		"/tiles/all/1/1/0.mvt": "",
+	}
+
+	for path, expected := range tests {
+
+		req := httptest.NewRequest("GET", path, nil)
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		v := rsp.Result().Header.Get(truncated_header)
+
+		if v != expected {
+			t.Fatalf("Unexpected %s header for %s: '%s'", truncated_header, path, v)
+		}
+	}
+}
+
+func TestOrderByClause(t *testing.T) {
+
+	tests := []struct {
+		order_by    string
+		tiebreakers []string
+		limit       int
+		expected    string
+	}{
+		{"", nil, 0, ""},
+		{"", []string{`"id"`}, 11, ` ORDER BY "id" LIMIT 11`},
+		{`"wof:priority" DESC`, nil, 0, ` ORDER BY "wof:priority" DESC`},
+		{"ST_Area(geometry) DESC", []string{"rowid"}, 101, " ORDER BY ST_Area(geometry) DESC, rowid LIMIT 101"},
+	}
+
+	for _, test := range tests {
+
+		clause := orderByClause(test.order_by, test.tiebreakers, test.limit)
+
+		if clause != test.expected {
+			t.Fatalf("Unexpected clause '%s', expected '%s'", clause, test.expected)
+		}
+	}
+}
+
+func TestOrderTiebreakers(t *testing.T) {
+
+	tests := []struct {
+		opts     *GetFeaturesForTileFuncOptions
+		order_by string
+		expected string
+	}{
+		{&GetFeaturesForTileFuncOptions{IdColumn: "id"}, "", ` ORDER BY "id" LIMIT 11`},
+		{&GetFeaturesForTileFuncOptions{IdColumn: "id"}, `"wof:priority" DESC`, ` ORDER BY "wof:priority" DESC, "id" LIMIT 11`},
+		{&GetFeaturesForTileFuncOptions{Table: "features"}, "", ` ORDER BY rowid LIMIT 11`},
+		{&GetFeaturesForTileFuncOptions{ReadOptions: &ParquetReadOptions{FileRowNumber: true}}, "", ` ORDER BY "file_row_number" LIMIT 11`},
+		{&GetFeaturesForTileFuncOptions{ReadOptions: &ParquetReadOptions{Filename: true, FileRowNumber: true}}, `"wof:priority" DESC`, ` ORDER BY "wof:priority" DESC, "filename", "file_row_number" LIMIT 11`},
+		{&GetFeaturesForTileFuncOptions{Query: "SELECT 1", ReadOptions: &ParquetReadOptions{FileRowNumber: true}}, "", ` ORDER BY 1, 2, 3 LIMIT 11`},
+		{&GetFeaturesForTileFuncOptions{}, `"wof:priority" DESC`, ` ORDER BY "wof:priority" DESC, 1, 2, 3 LIMIT 11`},
+	}
+
+	for _, test := range tests {
+
+		clause := orderByClause(test.order_by, orderTiebreakers(test.opts, 3), 11)
+
+		if clause != test.expected {
+			t.Fatalf("Unexpected clause '%s', expected '%s'", clause, test.expected)
+		}
+	}
+}
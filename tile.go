@@ -1,85 +1,89 @@
 package show
 
-// https://github.com/victorspringer/http-cache
-
 import (
 	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log/slog"
-	"regexp"
 	"strings"
 	"time"
 
+	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/encoding/wkb"
-	"github.com/paulmach/orb/encoding/wkt"
 	"github.com/paulmach/orb/geojson"
 	"github.com/paulmach/orb/maptile"
 	"github.com/sfomuseum/go-http-mvt"
 )
 
-// START OF The DuckDB spatial extension returns WKT-formatted MultiPoint strings
-//  without enclosing bracketsfor individual points which makes Orb sad. See also:
-// https://libgeos.org/specifications/wkt/
-
-var re_wkt_point = regexp.MustCompile(`\-?\d+(?:\.\d+)? \-?\d+(?:\.\d+)?`)
-
-func fixMultiPoint(wkt_geom string) string {
-	return re_wkt_point.ReplaceAllStringFunc(wkt_geom, replaceMultiPoint)
-}
-
-func replaceMultiPoint(s string) string {
-	return fmt.Sprintf("(%s)", s)
-}
-
-// END OF The DuckDB spatial extension returns WKT-formatted MultiPoint strings
-
 // GetFeaturesForTileFuncOptions defines configuration details to pass the `GetFeaturesForTileFunc` method.
 type GetFeaturesForTileFuncOptions struct {
 	// A valid `sql.DB` instance (assumed for the time being to be using the "duckdb" engine).
 	Database *sql.DB
-	// A valid URI to a GeoParquet file to pass to the DuckDB `read_parquet` method.
+	// A valid URI to a GeoParquet file, a glob pattern or a comma-separated list of URIs, to pass to the DuckDB `read_parquet` method.
 	Datasource string
+	// Optional options to pass to the DuckDB `read_parquet` method.
+	ReadOptions *ParquetReadOptions
+	// The list of files in 'Datasource', and the bounding boxes of those files and their row groups, used to skip files and row groups
+	// which do not intersect a tile. Files are only skipped if 'ReadOptions.Filename' is true and row groups are only skipped if
+	// 'ReadOptions.FileRowNumber' is true. If empty files and row groups are not pruned.
+	Files []*SourceFile
+	// An optional SQL SELECT statement to query instead of 'Datasource'.
+	Query string
+	// The name of the column containing geometries.
+	GeometryColumn string
+	// The encoding of the geometries in 'GeometryColumn'. Valid options are "WKB" or any of the GeoArrow "native" encodings
+	// (point, linestring, polygon, multipoint, multilinestring, multipolygon) defined by the GeoParquet specification.
+	GeometryEncoding string
+	// The optional CRS of the geometries in 'GeometryColumn', expressed in a form understood by the DuckDB `ST_Transform` function
+	// (for example "EPSG:2227"). If set geometries will be transformed to WGS84 (EPSG:4326) on the fly.
+	SourceCRS string
+	// An optional SQL boolean expression used to filter features.
+	Where string
+	// The minimum zoom level for which features are queried. Requests for tiles below this zoom level yield an empty collection.
+	MinZoom int
+	// The maximum zoom level for which features are queried. Requests for tiles above this zoom level yield an empty collection. If 0 there is no maximum zoom level.
+	MaxZoom int
+	// The optional name of a numeric column containing the minimum zoom level at which each feature should be displayed. Features with a NULL value are displayed at all zoom levels.
+	MinZoomColumn string
+	// Optional configuration details for aggregating features in to bins. Bins are served by the callback function returned by `GetBinsForTileFunc`.
+	Bins *BinOptions
+	// Optional configuration details for grouping features in to clusters at low zoom levels.
+	Cluster *ClusterOptions
+	// The maximum number of features (rows) to include in a tile. If 0 there is no limit.
+	MaxFeatures int
+	// An optional SQL ORDER BY expression used to sort features before 'MaxFeatures' is applied.
+	OrderBy string
+	// The optional name of a column whose values will be used to partition features in to separate (vector tile) layers.
+	LayerBy string
+	// The strategy for handling GEOMETRYCOLLECTION geometries. Valid options are: explode, merge. If empty "explode" is assumed.
+	GeometryCollectionStrategy string
+	// The optional name of a (DuckDB) table, created by the `Materialize` method, to query instead of 'Datasource'.
+	Table string
 	// The list of table columns to query for and assign as GeoJSON properties.
 	TableColumns []string
-	// An option column name to use for a initial bounding box constraint. This columns is expected to contain the maximum X (longitude) value of the geometry it is associated with.
-	MaxXColumn string
-	// An option column name to use for a initial bounding box constraint. This columns is expected to contain the maximum Y (latitude) value of the geometry it is associated with.
-	MaxYColumn string
+	// Optional rules limiting which of 'TableColumns' are assigned as GeoJSON properties and how their values are encoded.
+	Properties *PropertyRules
+	// Optional columns containing the bounding box of each geometry used to construct an initial bounding box constraint.
+	BboxColumns *BboxColumns
+	// The optional name of a column uniquely identifying each feature. If set it is always assigned as a property, regardless of
+	// 'Properties', so that the complete feature can be retrieved using `GetFeature`.
+	IdColumn string
+	// The (DuckDB) type of 'IdColumn'.
+	IdColumnType string
+	// The optional extent, in WGS84, of the features in the data source. If set, and 'SourceCRS' is set, tile boundaries are clamped
+	// to it before being transformed in to 'SourceCRS'.
+	Extent *orb.Bound
 }
 
 // GetFeaturesForTileFunc returns a `mvt.GetFeaturesCallbackFunc` callback function using details specified in 'opts' to yield
 // a dictionary of GeoJSON FeatureCollections instances.
 func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeaturesCallbackFunc { // db *sql.DB, datasource string, table_cols []string) mvt.GetFeaturesCallbackFunc {
 
-	// quoted_cols wraps each column name in double-quotes
-	quoted_cols := make([]string, 0)
-
-	// pointer_cols is a list of column names we use to construct an array of pointers
-	// to indices to an array of values (below) that database column values will be written
-	// in to – this is a bit of unfortunate hoop-jumping that is necessary
-	// to account for the way that database/sql "scans" column data
-	// in to variables.
-	pointer_cols := make([]string, 0)
-
-	for _, c := range opts.TableColumns {
-		switch c {
-		case "geometry":
-			// Note: We are treating the geometry column as a special case
-			// in the SQL query below.
-		default:
-			quoted_cols = append(quoted_cols, fmt.Sprintf(`"%s"`, c))
-			pointer_cols = append(pointer_cols, c)
-		}
-	}
+	from := fromClause(opts)
 
-	// But wait, there's more! Append the "geometry" column back in to pointer_cols
-	// so that it is included in the pointers/values.
-	pointer_cols = append(pointer_cols, "geometry")
-
-	// Generate a CSV string of quoted_cols for use with SQL queries below.
-	str_cols := strings.Join(quoted_cols, ",")
+	// An invalid geometry encoding is reported for every tile since the callback function can not return an error.
+	geom, geom_err := geometryExpression(opts)
 
 	fn := func(ctx context.Context, layer string, t *maptile.Tile) (map[string]*geojson.FeatureCollection, error) {
 
@@ -89,60 +93,98 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 		logger = logger.With("layer", layer)
 		logger = logger.With("tile", tile_key)
 
-		fc := geojson.NewFeatureCollection()
+		// Features are assigned to a single collection, named after the layer being requested,
+		// unless they are being partitioned in to multiple collections by the value of 'opts.LayerBy'.
+
+		collections := make(map[string]*geojson.FeatureCollection)
+		count := 0
 
 		t1 := time.Now()
 
 		defer func() {
-			logger.Debug("Time to get features", "count", len(fc.Features), "time", time.Since(t1))
+			logger.Debug("Time to get features", "count", count, "collections", len(collections), "time", time.Since(t1))
 		}()
 
-		bound := t.Bound()
-		poly := bound.ToPolygon()
+		if !inZoomRange(int(t.Z), opts.MinZoom, opts.MaxZoom) {
+			collections[layer] = geojson.NewFeatureCollection()
+			return collections, nil
+		}
+
+		if geom_err != nil {
+			return nil, fmt.Errorf("Failed to derive geometry expression, %w", geom_err)
+		}
 
-		enc_poly, err := wkb.MarshalToHex(poly, wkb.DefaultByteOrder)
+		str_where, args, pruned, err := tileConstraints(ctx, opts, t.Bound(), int(t.Z))
 
 		if err != nil {
-			return nil, fmt.Errorf("Failed to marshal tile boundary to WKBHEX, %w", err)
+			return nil, err
 		}
 
-		// Note: Do not change the order of columns here (geometry at the end) without adjusting
-		// pointer_cols above.
+		if pruned != nil {
+			logger.Debug("Prune row groups", "row_groups", pruned.Total, "pruned", pruned.Pruned)
+		}
+
+		if opts.Cluster != nil && int(t.Z) < opts.Cluster.MaxZoom {
+
+			clusters, err := clusterFeatures(ctx, opts, layer, t, str_where, args)
+
+			if err != nil {
+				logger.Error("Failed to cluster features", "error", err)
+				return nil, err
+			}
 
-		where := make([]string, 0)
-		args := make([]interface{}, 0)
+			for name, fc := range clusters {
+				collections[name] = fc
+				count += len(fc.Features)
+			}
 
-		// START OF bbox constraint
-		// It is not clear to me whether this has any meaningful impact on query times.
+			if len(collections) == 0 {
+				collections[layer] = geojson.NewFeatureCollection()
+			}
 
-		if opts.MaxXColumn != "" && opts.MaxYColumn != "" {
+			return collections, nil
+		}
 
-			max_lon := opts.MaxXColumn
-			max_lat := opts.MaxYColumn
+		// pointer_cols is a list of column names we use to construct an array of pointers
+		// to indices to an array of values (below) that database column values will be written
+		// in to – this is a bit of unfortunate hoop-jumping that is necessary
+		// to account for the way that database/sql "scans" column data
+		// in to variables. Only the columns needed for this zoom level are queried so that
+		// DuckDB can skip reading the others.
 
-			minx := bound.Min[0]
-			miny := bound.Min[1]
-			maxx := bound.Max[0]
-			maxy := bound.Max[1]
+		pointer_cols, hidden_cols := tileColumns(opts, int(t.Z))
 
-			where_bbox := fmt.Sprintf(`(("%s" > ? AND "%s" < ?) OR ("%s" > ? AND "%s" < ?))`, max_lon, max_lon, max_lat, max_lat)
-			where = append(where, where_bbox)
+		select_cols := make([]string, 0)
 
-			args = append(args, minx)
-			args = append(args, maxy)
-			args = append(args, miny)
-			args = append(args, maxx)
+		for _, c := range pointer_cols {
+			select_cols = append(select_cols, fmt.Sprintf(`"%s"`, c))
 		}
 
-		// END OF bbox constraint
+		// Note: The geometry column is treated as a special case and is always the last column.
 
-		where = append(where, `ST_Intersects(ST_GeomFromWkb(geometry::WKB_BLOB), ST_GeomFromHEXWKB(?))`)
-		args = append(args, string(enc_poly))
+		select_cols = append(select_cols, fmt.Sprintf(`ST_AsWKB(%s) AS "%s"`, geom, opts.GeometryColumn))
+		pointer_cols = append(pointer_cols, opts.GeometryColumn)
+
+		// One more row than the maximum number of features is requested in order to determine whether the tile was truncated.
 
-		str_where := strings.Join(where, " AND ")
+		// Features are always ordered, with a tiebreaker, when tiles are truncated so that tiles contain the same
+		// features for every request rather than whichever rows DuckDB's parallel scan happens to return first.
 
-		q := fmt.Sprintf(`SELECT %s, ST_AsText(ST_GeomFromWkb(geometry::WKB_BLOB)) AS geometry FROM read_parquet("%s") WHERE %s`,
-			str_cols, opts.Datasource, str_where)
+		limit := 0
+		var tiebreakers []string
+
+		if opts.MaxFeatures > 0 {
+			limit = opts.MaxFeatures + 1
+		}
+
+		if opts.MaxFeatures > 0 || opts.OrderBy != "" {
+			tiebreakers = orderTiebreakers(opts, len(select_cols))
+		}
+
+		q := fmt.Sprintf(`SELECT %s FROM %s WHERE %s%s`,
+			strings.Join(select_cols, ","), from, str_where, orderByClause(opts.OrderBy, tiebreakers, limit))
+
+		row_count := 0
 
 		/*
 			logger.Debug(q)
@@ -160,7 +202,7 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 				return nil, nil
 			}
 
-			logger.Error("Failed to query database", "error", err, "query", q, "geom", enc_poly)
+			logger.Error("Failed to query database", "error", err, "query", q, "args", args)
 			return nil, fmt.Errorf("Failed to query database, %w", err)
 		}
 
@@ -175,6 +217,14 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 				// pass
 			}
 
+			row_count += 1
+
+			if opts.MaxFeatures > 0 && row_count > opts.MaxFeatures {
+				logger.Info("Tile truncated", "max_features", opts.MaxFeatures)
+				recordTruncated(ctx, opts.MaxFeatures)
+				break
+			}
+
 			// START OF indirect all the things to satify db.Scan
 			// See notes wrt/ pointer_cols above
 
@@ -200,8 +250,8 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 				return nil, fmt.Errorf("Failed to scan row, %w", err)
 			}
 
-			var wkt_geom string
-			props := make(map[string]any)
+			var wkb_geom []byte
+			props := geojson.Properties{}
 
 			for idx, k := range pointer_cols {
 
@@ -210,32 +260,86 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 				// because we indirected all the things (above). Good times.
 
 				switch k {
-				case "geometry":
-					wkt_geom = values[idx].(string)
+				case opts.GeometryColumn:
+					wkb_geom, _ = values[idx].([]byte)
 				default:
 					props[k] = values[idx]
 				}
 			}
 
-			// See notes above
-			if strings.HasPrefix(wkt_geom, "MULTIPOINT (") {
-				wkt_geom = fixMultiPoint(wkt_geom)
+			if len(wkb_geom) == 0 {
+				logger.Debug("Skipping feature with empty geometry")
+				continue
 			}
 
-			// To do:
-			// GEOMETRYCOLLECTION (
+			orb_geom, err := wkb.Unmarshal(wkb_geom)
 
-			orb_geom, err := wkt.Unmarshal(wkt_geom)
+			if err != nil {
+				logger.Error("Failed to unmarshal geometry", "error", err)
+				continue
+			}
+
+			fc_name := layer
+
+			if opts.LayerBy != "" {
+				fc_name = PartitionName(props[opts.LayerBy], layer)
+			}
+
+			for k, _ := range hidden_cols {
+				delete(props, k)
+			}
+
+			// Feature IDs are assigned after the property rules are applied since they may truncate
+			// (string) IDs which are needed, in full, to retrieve the complete feature.
+
+			var id any
+
+			if opts.IdColumn != "" {
+				id = props[opts.IdColumn]
+			}
+
+			opts.Properties.Apply(props)
+
+			if id != nil {
+				props[opts.IdColumn] = id
+			}
+
+			// Vector tiles can not encode geometry collections so they are either exploded
+			// in to individual features or merged in to Multi* features.
+
+			geoms, err := expandGeometry(orb_geom, opts.GeometryCollectionStrategy)
 
 			if err != nil {
-				logger.Error("Failed to unmarshal geometry", "geom", wkt_geom, "error", err)
+				logger.Error("Failed to expand geometry", "error", err)
+				return nil, fmt.Errorf("Failed to expand geometry, %w", err)
+			}
+
+			if len(geoms) == 0 {
+				logger.Warn("Skipping feature with empty geometry collection")
 				continue
 			}
 
-			f := geojson.NewFeature(orb_geom)
-			f.Properties = props
+			for idx, g := range geoms {
+
+				f_props := props
 
-			fc.Append(f)
+				if idx > 0 {
+					f_props = props.Clone()
+				}
+
+				f := geojson.NewFeature(g)
+				f.Properties = f_props
+
+				fc, exists := collections[fc_name]
+
+				if !exists {
+					fc = geojson.NewFeatureCollection()
+					collections[fc_name] = fc
+				}
+
+				fc.Append(f)
+				count += 1
+			}
 		}
 
 		err = rows.Err()
@@ -247,8 +351,8 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 			}
 		}
 
-		collections := map[string]*geojson.FeatureCollection{
-			layer: fc,
+		if len(collections) == 0 {
+			collections[layer] = geojson.NewFeatureCollection()
 		}
 
 		return collections, nil
@@ -256,3 +360,197 @@ func GetFeaturesForTileFunc(opts *GetFeaturesForTileFuncOptions) mvt.GetFeatures
 
 	return fn
 }
+
+// tileConstraints returns the SQL boolean expression, and any query arguments, used to select the features in the data
+// defined by 'opts' which intersect 'bound' (in WGS84) for a tile at zoom level 'zoom'. If 'opts.Files' is not empty the
+// number of row groups which are skipped is also returned. If 'bound' does not intersect 'opts.Extent' (when geometries are
+// transformed) the expression is "false".
+func tileConstraints(ctx context.Context, opts *GetFeaturesForTileFuncOptions, bound orb.Bound, zoom int) (string, []any, *prunedRowGroups, error) {
+
+	source_geom, err := sourceGeometryExpression(opts)
+
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("Failed to derive geometry expression, %w", err)
+	}
+
+	// Whether the constraints below are applied to untransformed geometries in the source CRS.
+	in_source_crs := true
+
+	if opts.SourceCRS != "" {
+
+		// Transform the tile boundary in to the source CRS so that the spatial
+		// (and bounding box) constraints below can be applied to untransformed
+		// geometries. The tile boundary is clamped to the extent of the layer
+		// first since transforming areas outside the CRS's area of use (for
+		// example a world-sized tile in to a UTM zone) may fail or yield
+		// infinite coordinates.
+
+		if opts.Extent != nil {
+
+			clamped, ok := clampBound(bound, *opts.Extent)
+
+			if !ok {
+				return "false", nil, nil, nil
+			}
+
+			bound = clamped
+		}
+
+		source_bound, err := transformBoundCached(ctx, opts.Database, bound, display_crs, opts.SourceCRS)
+
+		if err == nil && isFiniteBound(source_bound) {
+			bound = source_bound
+		} else {
+
+			// Fall back to transforming geometries in to WGS84 and comparing them with
+			// the (untransformed) tile boundary which is slower but always works.
+
+			slog.Debug("Unable to transform tile boundary to source CRS, transforming geometries instead", "bound", bound, "source_crs", opts.SourceCRS, "error", err)
+
+			geom, err := geometryExpression(opts)
+
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("Failed to derive geometry expression, %w", err)
+			}
+
+			source_geom = geom
+			in_source_crs = false
+		}
+	}
+
+	poly := bound.ToPolygon()
+
+	enc_poly, err := wkb.MarshalToHex(poly, wkb.DefaultByteOrder)
+
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("Failed to marshal tile boundary to WKBHEX, %w", err)
+	}
+
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+
+	var pruned *prunedRowGroups
+
+	// START OF bbox constraint
+
+	if in_source_crs && opts.BboxColumns != nil && !opts.BboxColumns.IsZero() {
+		where = append(where, bboxPredicate(opts.BboxColumns, bound))
+	}
+
+	// END OF bbox constraint
+
+	// START OF files constraint
+
+	if in_source_crs && opts.Table == "" && len(opts.Files) > 0 {
+
+		files_where, files_args, files_pruned := filesPredicate(opts.Files, bound, opts.ReadOptions)
+		pruned = files_pruned
+
+		if files_where != "" {
+			where = append(where, files_where)
+			args = append(args, files_args...)
+		}
+	}
+
+	// END OF files constraint
+
+	if opts.Where != "" {
+		where = append(where, fmt.Sprintf("(%s)", opts.Where))
+	}
+
+	if opts.MinZoomColumn != "" {
+		where = append(where, fmt.Sprintf(`("%s" IS NULL OR "%s" <= %d)`, opts.MinZoomColumn, opts.MinZoomColumn, zoom))
+	}
+
+	if opts.Table != "" {
+		// The R-tree index on materialized tables is only used when the geometry being compared
+		// is a constant so the tile boundary is inlined rather than passed as a query argument.
+		// This is safe because it is (hex-encoded) WKB we created above.
+		where = append(where, fmt.Sprintf(`ST_Intersects(%s, ST_GeomFromHEXWKB('%s'))`, source_geom, enc_poly))
+	} else {
+		where = append(where, fmt.Sprintf(`ST_Intersects(%s, ST_GeomFromHEXWKB(?))`, source_geom))
+		args = append(args, string(enc_poly))
+	}
+
+	return strings.Join(where, " AND "), args, pruned, nil
+}
+
+// tileColumns returns the list of columns, excluding the geometry column, to query for tiles at zoom level 'zoom' and
+// a dictionary of those columns which are only queried for internal use (for example partitioning features) and should
+// not be assigned as properties.
+func tileColumns(opts *GetFeaturesForTileFuncOptions, zoom int) ([]string, map[string]bool) {
+
+	cols := make([]string, 0)
+	hidden := make(map[string]bool)
+	seen := make(map[string]bool)
+
+	for _, c := range opts.Properties.Columns(opts.TableColumns, zoom) {
+
+		if c == opts.GeometryColumn || seen[c] {
+			continue
+		}
+
+		cols = append(cols, c)
+		seen[c] = true
+	}
+
+	if opts.IdColumn != "" && !seen[opts.IdColumn] {
+		cols = append(cols, opts.IdColumn)
+		seen[opts.IdColumn] = true
+	}
+
+	if opts.LayerBy != "" && !seen[opts.LayerBy] {
+		cols = append(cols, opts.LayerBy)
+		hidden[opts.LayerBy] = true
+	}
+
+	return cols, hidden
+}
+
+// fromClause returns the SQL to use in the FROM clause of queries against the data defined by 'opts'.
+func fromClause(opts *GetFeaturesForTileFuncOptions) string {
+
+	if opts.Table != "" {
+		return opts.Table
+	}
+
+	return sourceClause(opts.Datasource, opts.Query, opts.ReadOptions)
+}
+
+// sourceClause returns the SQL to use in the FROM clause of queries against 'query', wrapped as a subquery, if
+// not empty or otherwise 'datasource' read using the DuckDB `read_parquet` function with 'read_opts'.
+func sourceClause(datasource string, query string, read_opts *ParquetReadOptions) string {
+
+	if query != "" {
+		return fmt.Sprintf(`(%s) AS source`, query)
+	}
+
+	return readParquetClause(datasource, read_opts)
+}
+
+// geometryExpression returns the SQL expression used to derive a (DuckDB spatial) GEOMETRY, in WGS84 (EPSG:4326), from the data defined by 'opts'.
+func geometryExpression(opts *GetFeaturesForTileFuncOptions) (string, error) {
+
+	geom, err := sourceGeometryExpression(opts)
+
+	if err != nil {
+		return "", err
+	}
+
+	if opts.SourceCRS != "" {
+		geom = transformExpression(geom, opts.SourceCRS, display_crs)
+	}
+
+	return geom, nil
+}
+
+// sourceGeometryExpression returns the SQL expression used to derive a (DuckDB spatial) GEOMETRY, in its source CRS, from the data defined by 'opts'.
+func sourceGeometryExpression(opts *GetFeaturesForTileFuncOptions) (string, error) {
+
+	if opts.Table != "" {
+		// Materialized tables store decoded geometries.
+		return fmt.Sprintf(`"%s"`, opts.GeometryColumn), nil
+	}
+
+	return decodeGeometryExpression(opts.GeometryColumn, opts.GeometryEncoding)
+}
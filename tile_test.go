@@ -1,40 +1,278 @@
 package show
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 
+	_ "github.com/marcboeker/go-duckdb"
 	"github.com/paulmach/orb/encoding/wkt"
+	"github.com/paulmach/orb/maptile"
+	"github.com/sfomuseum/go-http-mvt"
 )
 
-func TestOrbMultiPoints(t *testing.T) {
+// A GeoParquet file containing the (multi) polygons of 177 countries, derived from Natural Earth. See testdata/README.md for details.
+const countries_fixture string = "testdata/countries.parquet"
 
-	tests := []string{
-		// These are tests that wkt.Unmarshal should be happy with
-		"MULTIPOINT((-122.3931 37.618206))",
-		"MULTIPOINT((-122.3931 37.618206), (-122.388749 37.620113))",
-		// These are tests that will make wkt.Unmarshal sad without intervention (fixMultiPoint)
-		"MULTIPOINT (-122.388749 37.620113)",
+// The names of the environment variables used to load the DuckDB spatial extension offline, matching the "-extension-directory"
+// and "-spatial-extension" flags. See testdata/README.md for details.
+const (
+	extension_directory_env string = "GEOPARQUET_SHOW_EXTENSION_DIRECTORY"
+	spatial_extension_env   string = "GEOPARQUET_SHOW_SPATIAL_EXTENSION"
+)
+
+// spatialDatabase returns a new (in-memory) DuckDB database with the spatial extension loaded from the locations defined by the
+// GEOPARQUET_SHOW_EXTENSION_DIRECTORY and GEOPARQUET_SHOW_SPATIAL_EXTENSION environment variables, or installed if neither is set.
+// Tests (and benchmarks) are skipped if the extension can not be loaded, for example on machines without network access.
+func spatialDatabase(tb testing.TB) *sql.DB {
+
+	ctx := context.Background()
+
+	db, err := sql.Open("duckdb", "")
+
+	if err != nil {
+		tb.Fatalf("Failed to open database, %v", err)
 	}
 
-	for _, wkt_geom := range tests {
+	tb.Cleanup(func() {
+		db.Close()
+	})
 
-		orb_geom, err := wkt.Unmarshal(wkt_geom)
+	ext_opts := &ExtensionOptions{
+		Directory:        os.Getenv(extension_directory_env),
+		SpatialExtension: os.Getenv(spatial_extension_env),
+	}
 
-		if err != nil {
-			t.Logf("Failed to unmarshal '%s', %v", wkt_geom, err)
-		} else {
-			t.Log(wkt_geom, orb_geom)
+	err = loadSpatialExtension(ctx, db, ext_opts)
+
+	if err != nil {
+		tb.Skipf("Spatial extension is not available (set %s or %s to load it offline), %v", extension_directory_env, spatial_extension_env, err)
+	}
+
+	return db
+}
+
+// countriesTileOptions returns the `GetFeaturesForTileFuncOptions` used to query the countries fixture. Tests (and benchmarks)
+// are skipped if the DuckDB spatial extension can not be loaded, see `spatialDatabase`.
+func countriesTileOptions(tb testing.TB) *GetFeaturesForTileFuncOptions {
+
+	db := spatialDatabase(tb)
+
+	opts := &GetFeaturesForTileFuncOptions{
+		Database:         db,
+		Datasource:       countries_fixture,
+		GeometryColumn:   "geometry",
+		GeometryEncoding: ENCODING_WKB,
+		TableColumns:     []string{"id", "name", "geometry"},
+	}
+
+	return opts
+}
+
+// countriesTiles returns the list of tiles at zoom level 2 which contain countries.
+func countriesTiles() []*maptile.Tile {
+
+	tiles := make([]*maptile.Tile, 0)
+
+	for x := uint32(0); x < 4; x++ {
+
+		for y := uint32(0); y < 4; y++ {
+			t := maptile.New(x, y, 2)
+			tiles = append(tiles, &t)
+		}
+	}
+
+	return tiles
+}
+
+func TestGetFeaturesForTile(t *testing.T) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(t)
+	cb := GetFeaturesForTileFunc(opts)
+
+	// The tile containing the south-western United States and Mexico
+
+	tile := maptile.New(1, 3, 3)
+
+	collections, err := cb(ctx, "countries", &tile)
+
+	if err != nil {
+		t.Fatalf("Failed to get features for tile, %v", err)
+	}
+
+	fc, exists := collections["countries"]
+
+	if !exists {
+		t.Fatalf("Missing countries collection")
+	}
+
+	found := false
+
+	for _, f := range fc.Features {
+
+		if f.Properties["id"] != "USA" {
 			continue
 		}
 
-		wkt_geom = fixMultiPoint(wkt_geom)
+		found = true
+
+		if f.Properties["name"] != "United States of America" {
+			t.Fatalf("Unexpected name: %v", f.Properties["name"])
+		}
+
+		if f.Geometry.GeoJSONType() != "MultiPolygon" {
+			t.Fatalf("Unexpected geometry type: %s", f.Geometry.GeoJSONType())
+		}
+	}
+
+	if !found {
+		t.Fatalf("Expected tile to contain the United States")
+	}
+}
+
+// Benchmarks comparing the cost of producing the features for tiles by decoding geometries returned by DuckDB as WKB (which
+// is what GetFeaturesForTileFunc does) and WKT (which is what GetFeaturesForTileFunc used to do) using the countries fixture.
+
+func BenchmarkGetFeaturesForTileWKB(b *testing.B) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(b)
+	cb := GetFeaturesForTileFunc(opts)
+
+	tiles := countriesTiles()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		for _, t := range tiles {
+
+			_, err := cb(ctx, "countries", t)
+
+			if err != nil {
+				b.Fatalf("Failed to get features for tile, %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkGetFeaturesForTileWKT(b *testing.B) {
+
+	ctx := context.Background()
+
+	opts := countriesTileOptions(b)
+	tiles := countriesTiles()
+
+	geom, err := geometryExpression(opts)
+
+	if err != nil {
+		b.Fatalf("Failed to derive geometry expression, %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		for _, t := range tiles {
+
+			err := getFeaturesForTileWKT(ctx, opts, geom, t)
+
+			if err != nil {
+				b.Fatalf("Failed to get features for tile, %v", err)
+			}
+		}
+	}
+}
+
+// getFeaturesForTileWKT queries the features in 't' using the same constraints as GetFeaturesForTileFunc but returning
+// geometries as WKT, decoded using orb's `wkt` package, rather than WKB.
+func getFeaturesForTileWKT(ctx context.Context, opts *GetFeaturesForTileFuncOptions, geom string, t *maptile.Tile) error {
+
+	where, args, _, err := tileConstraints(ctx, opts, t.Bound(), int(t.Z))
+
+	if err != nil {
+		return err
+	}
+
+	q := fmt.Sprintf(`SELECT "id", "name", ST_AsText(%s) FROM %s WHERE %s`, geom, fromClause(opts), where)
+
+	rows, err := opts.Database.QueryContext(ctx, q, args...)
+
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+
+		var id string
+		var name string
+		var wkt_geom string
+
+		err := rows.Scan(&id, &name, &wkt_geom)
+
+		if err != nil {
+			return err
+		}
 
-		orb_geom, err = wkt.Unmarshal(wkt_geom)
+		_, err = wkt.Unmarshal(wkt_geom)
 
 		if err != nil {
-			t.Fatalf("Failed to unmarshal '%s' after fix, %v", wkt_geom, err)
+			return err
 		}
+	}
+
+	return rows.Err()
+}
+
+// tileFeatureIds returns the sorted list of the "id" properties of the features in the "countries" layer of the tile
+// 'z'/'x'/'y' produced by 'cb'.
+func tileFeatureIds(tb testing.TB, cb mvt.GetFeaturesCallbackFunc, z uint32, x uint32, y uint32) []string {
+
+	tile := maptile.New(x, y, maptile.Zoom(z))
+
+	collections, err := cb(context.Background(), "countries", &tile)
 
-		t.Log(wkt_geom, orb_geom)
+	if err != nil {
+		tb.Fatalf("Failed to get features for tile %d/%d/%d, %v", z, x, y, err)
 	}
+
+	ids := make([]string, 0)
+
+	for _, f := range collections["countries"].Features {
+		ids = append(ids, fmt.Sprintf("%v", f.Properties["id"]))
+	}
+
+	slices.Sort(ids)
+
+	return ids
+}
+
+// countriesDerivedFixture writes the results of 'query', which may select from the countries fixture as "countries" and
+// its decoded geometries as "geom", to a new Parquet file in a temporary directory and returns its path. 'copy_opts' are
+// appended to the options passed to the DuckDB COPY statement.
+func countriesDerivedFixture(tb testing.TB, db *sql.DB, name string, query string, copy_opts string) string {
+
+	path := filepath.Join(tb.TempDir(), name)
+
+	q := fmt.Sprintf(`COPY (WITH countries AS (SELECT *, ST_GeomFromWkb("geometry"::WKB_BLOB) AS geom FROM read_parquet('%s')) %s) TO '%s' (FORMAT PARQUET%s)`,
+		countries_fixture, query, path, copy_opts)
+
+	_, err := db.ExecContext(context.Background(), q)
+
+	if err != nil {
+		tb.Fatalf("Failed to write %s, %v", name, err)
+	}
+
+	return path
 }
@@ -0,0 +1,784 @@
+package show
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The pattern used to derive the layer, zoom, column and row of a tile request.
+var re_tile_path = regexp.MustCompile(`^/tiles/([^/]+)/(\d+)/(\d+)/(\d+)\.mvt$`)
+
+// The list of HTTP response headers which are stored with, and replayed for, cached tiles.
+var cached_headers = []string{
+	"Content-Type",
+	truncated_header,
+}
+
+// The name of the HTTP response header reporting whether a tile was served from the cache.
+const cache_header string = "X-Tile-Cache"
+
+// The prefix of the sub-directories, one per layer fingerprint, in which tiles are stored in a tile cache directory. Only
+// files in these sub-directories are ever read or removed so that a tile cache directory can be shared with other files.
+const tile_cache_dir_prefix string = "geoparquet-show-"
+
+// The pattern used to derive the fingerprint, zoom, column and row of a tile cache key.
+var re_tile_cache_key = regexp.MustCompile(`^([0-9A-Za-z_-]+)/(\d+)/(\d+)/(\d+)$`)
+
+// The pattern matching the paths, relative to a fingerprint sub-directory, of the tiles stored in a tile cache directory.
+var re_tile_cache_file = regexp.MustCompile(`^\d+/\d+/\d+\.json$`)
+
+// The pattern matching the paths, relative to a fingerprint sub-directory, of the temporary files written by a tile cache.
+var re_tile_cache_tmp = regexp.MustCompile(`^\d+/\d+/tile-[^/]*\.tmp$`)
+
+// cachedTile is a tile, and the HTTP response headers it was served with, stored in a `TileCache`.
+type cachedTile struct {
+	Key    string            `json:"key"`
+	Header map[string]string `json:"header"`
+	Body   []byte            `json:"body"`
+}
+
+// size returns the (approximate) number of bytes used to store 't' in memory.
+func (t *cachedTile) size() int64 {
+
+	sz := len(t.Key) + len(t.Body)
+
+	for k, v := range t.Header {
+		sz += len(k) + len(v)
+	}
+
+	return int64(sz)
+}
+
+// diskTile is the path, and size, of a tile stored in a tile cache directory.
+type diskTile struct {
+	path string
+	size int64
+}
+
+// TileCache is a least-recently-used cache of (encoded) tiles, limited by the total number of bytes stored in memory,
+// with an optional directory where tiles are also stored on disk, limited by the total number of bytes stored on disk.
+type TileCache struct {
+	mu             sync.Mutex
+	max_bytes      int64
+	size           int64
+	entries        map[string]*list.Element
+	lru            *list.List
+	directory      string
+	max_disk_bytes int64
+	disk_size      int64
+	disk_entries   map[string]*list.Element
+	disk_lru       *list.List
+}
+
+// NewTileCache returns a new `TileCache` instance which stores up to 'max_bytes' bytes of tiles in memory and, if not empty,
+// up to 'max_disk_bytes' bytes of tiles in 'directory' on disk. If 'max_bytes' is 0 tiles are only stored on disk. If 'max_disk_bytes'
+// is 0 there is no limit to the number of bytes stored on disk. Tiles stored in 'directory' by a previous instance count towards that limit.
+func NewTileCache(max_bytes int64, directory string, max_disk_bytes int64) (*TileCache, error) {
+
+	c := &TileCache{
+		max_bytes:      max_bytes,
+		entries:        make(map[string]*list.Element),
+		lru:            list.New(),
+		directory:      directory,
+		max_disk_bytes: max_disk_bytes,
+		disk_entries:   make(map[string]*list.Element),
+		disk_lru:       list.New(),
+	}
+
+	if directory == "" {
+		return c, nil
+	}
+
+	err := os.MkdirAll(directory, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create tile cache directory, %w", err)
+	}
+
+	err = c.loadDiskTiles()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read tile cache directory, %w", err)
+	}
+
+	return c, nil
+}
+
+// Get returns the tile stored for 'key', first checking memory and then the cache directory (if defined).
+func (c *TileCache) Get(key string) (*cachedTile, bool) {
+
+	c.mu.Lock()
+
+	el, exists := c.entries[key]
+
+	if exists {
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*cachedTile), true
+	}
+
+	c.mu.Unlock()
+
+	if c.directory == "" {
+		return nil, false
+	}
+
+	path, ok := c.path(key)
+
+	if !ok {
+		return nil, false
+	}
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, false
+	}
+
+	var t *cachedTile
+
+	err = json.Unmarshal(body, &t)
+
+	if err != nil || t.Key != key {
+		return nil, false
+	}
+
+	c.setMemory(t)
+	c.setDisk(path, int64(len(body)))
+
+	return t, true
+}
+
+// Set stores 't' in memory and in the cache directory (if defined).
+func (c *TileCache) Set(t *cachedTile) {
+
+	c.setMemory(t)
+
+	if c.directory == "" {
+		return
+	}
+
+	path, ok := c.path(t.Key)
+
+	if !ok {
+		slog.Warn("Invalid tile cache key", "key", t.Key)
+		return
+	}
+
+	body, err := json.Marshal(t)
+
+	if err != nil {
+		slog.Warn("Failed to marshal cached tile", "key", t.Key, "error", err)
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+
+	if err != nil {
+		slog.Warn("Failed to create tile cache directory", "path", path, "error", err)
+		return
+	}
+
+	// Write to a temporary file first so that concurrent readers never see a partial tile.
+
+	tmp_wr, err := os.CreateTemp(filepath.Dir(path), "tile-*.tmp")
+
+	if err != nil {
+		slog.Warn("Failed to create temporary file for cached tile", "path", path, "error", err)
+		return
+	}
+
+	_, err = tmp_wr.Write(body)
+
+	if err == nil {
+		err = tmp_wr.Close()
+	} else {
+		tmp_wr.Close()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp_wr.Name(), path)
+	}
+
+	if err != nil {
+		slog.Warn("Failed to write cached tile", "path", path, "error", err)
+		os.Remove(tmp_wr.Name())
+		return
+	}
+
+	c.setDisk(path, int64(len(body)))
+}
+
+// Clear removes all the tiles stored in memory and in the cache directory (if defined). Only the tiles, and temporary files,
+// written by a tile cache (and the directories containing them, once empty) are removed from the cache directory.
+func (c *TileCache) Clear() error {
+	return c.clear(nil)
+}
+
+// ClearFingerprints removes the tiles stored in memory and in the cache directory (if defined) for the layers whose fingerprint,
+// derived by `layerFingerprint`, is in 'fingerprints'. Tiles for other layers are left as-is.
+func (c *TileCache) ClearFingerprints(fingerprints []string) error {
+
+	if len(fingerprints) == 0 {
+		return nil
+	}
+
+	return c.clear(fingerprints)
+}
+
+// clear removes the tiles stored for the layers whose fingerprint is in 'fingerprints' or, if nil, all the tiles.
+func (c *TileCache) clear(fingerprints []string) error {
+
+	c.mu.Lock()
+
+	if fingerprints == nil {
+
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		c.size = 0
+
+		c.disk_entries = make(map[string]*list.Element)
+		c.disk_lru.Init()
+		c.disk_size = 0
+
+	} else {
+
+		for _, fp := range fingerprints {
+
+			key_prefix := fp + "/"
+			path_prefix := filepath.Join(c.directory, tile_cache_dir_prefix+fp) + string(filepath.Separator)
+
+			for key, el := range c.entries {
+
+				if !strings.HasPrefix(key, key_prefix) {
+					continue
+				}
+
+				c.size -= el.Value.(*cachedTile).size()
+				c.lru.Remove(el)
+				delete(c.entries, key)
+			}
+
+			for path, el := range c.disk_entries {
+
+				if !strings.HasPrefix(path, path_prefix) {
+					continue
+				}
+
+				c.disk_size -= el.Value.(*diskTile).size
+				c.disk_lru.Remove(el)
+				delete(c.disk_entries, path)
+			}
+		}
+	}
+
+	c.mu.Unlock()
+
+	if c.directory == "" {
+		return nil
+	}
+
+	dirs := make([]string, 0)
+
+	err := c.walkDirectory(fingerprints, func(path string, rel_path string, d fs.DirEntry) error {
+
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		if !re_tile_cache_file.MatchString(rel_path) && !re_tile_cache_tmp.MatchString(rel_path) {
+			return nil
+		}
+
+		err := os.Remove(path)
+
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Failed to remove %s, %w", path, err)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to clear tile cache directory, %w", err)
+	}
+
+	// Remove directories, deepest first, ignoring those which are not empty because they contain files not written by a tile cache.
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	return nil
+}
+
+// setMemory stores 't' in memory, evicting the least recently used tiles until the total size of all the tiles
+// in memory is less than 'c.max_bytes'. Tiles larger than 'c.max_bytes' are not stored.
+func (c *TileCache) setMemory(t *cachedTile) {
+
+	sz := t.size()
+
+	if sz > c.max_bytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, exists := c.entries[t.Key]
+
+	if exists {
+		c.size -= el.Value.(*cachedTile).size()
+		c.lru.Remove(el)
+	}
+
+	c.entries[t.Key] = c.lru.PushFront(t)
+	c.size += sz
+
+	for c.size > c.max_bytes {
+
+		oldest := c.lru.Back()
+		old_t := oldest.Value.(*cachedTile)
+
+		c.lru.Remove(oldest)
+		delete(c.entries, old_t.Key)
+		c.size -= old_t.size()
+	}
+}
+
+// setDisk records that the tile stored at 'path' in the cache directory, and which is 'size' bytes, was the most recently used tile on disk,
+// removing the least recently used tiles until the total size of all the tiles on disk is less than 'c.max_disk_bytes' (if not 0).
+func (c *TileCache) setDisk(path string, size int64) {
+
+	c.mu.Lock()
+
+	el, exists := c.disk_entries[path]
+
+	if exists {
+		c.disk_size -= el.Value.(*diskTile).size
+		c.disk_lru.Remove(el)
+	}
+
+	c.disk_entries[path] = c.disk_lru.PushFront(&diskTile{path: path, size: size})
+	c.disk_size += size
+
+	evicted := make([]string, 0)
+
+	for c.max_disk_bytes > 0 && c.disk_size > c.max_disk_bytes && c.disk_lru.Len() > 1 {
+
+		oldest := c.disk_lru.Back()
+		old_t := oldest.Value.(*diskTile)
+
+		c.disk_lru.Remove(oldest)
+		delete(c.disk_entries, old_t.path)
+		c.disk_size -= old_t.size
+
+		evicted = append(evicted, old_t.path)
+	}
+
+	c.mu.Unlock()
+
+	for _, old_path := range evicted {
+
+		err := os.Remove(old_path)
+
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove cached tile", "path", old_path, "error", err)
+		}
+	}
+}
+
+// loadDiskTiles records the tiles already stored in the cache directory, ordered by their modification time, so that they count
+// towards 'c.max_disk_bytes' and are the first to be removed when it is exceeded.
+func (c *TileCache) loadDiskTiles() error {
+
+	type diskTileInfo struct {
+		path     string
+		size     int64
+		mod_time time.Time
+	}
+
+	tiles := make([]*diskTileInfo, 0)
+
+	err := c.walkDirectory(nil, func(path string, rel_path string, d fs.DirEntry) error {
+
+		if d.IsDir() || !re_tile_cache_file.MatchString(rel_path) {
+			return nil
+		}
+
+		info, err := d.Info()
+
+		if err != nil {
+			return nil
+		}
+
+		tiles = append(tiles, &diskTileInfo{
+			path:     path,
+			size:     info.Size(),
+			mod_time: info.ModTime(),
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].mod_time.Before(tiles[j].mod_time)
+	})
+
+	for _, t := range tiles {
+		c.setDisk(t.path, t.size)
+	}
+
+	return nil
+}
+
+// walkDirectory invokes 'cb' for each file and directory in the sub-directories of the cache directory owned by a tile cache,
+// with its path and its path relative to the sub-directory it is in. Files outside those sub-directories are never visited. If 'fingerprints'
+// is not nil only the sub-directories for those fingerprints are visited.
+func (c *TileCache) walkDirectory(fingerprints []string, cb func(path string, rel_path string, d fs.DirEntry) error) error {
+
+	entries, err := os.ReadDir(c.directory)
+
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), tile_cache_dir_prefix) {
+			continue
+		}
+
+		if fingerprints != nil && !slices.Contains(fingerprints, strings.TrimPrefix(e.Name(), tile_cache_dir_prefix)) {
+			continue
+		}
+
+		root := filepath.Join(c.directory, e.Name())
+
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			rel_path, err := filepath.Rel(root, path)
+
+			if err != nil {
+				return err
+			}
+
+			return cb(path, filepath.ToSlash(rel_path), d)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// path returns the path in the cache directory for the tile stored for 'key', in the form of "{PREFIX}{FINGERPRINT}/{Z}/{X}/{Y}.json".
+// Keys which are not derived by `tileCacheKey` return false.
+func (c *TileCache) path(key string) (string, bool) {
+
+	m := re_tile_cache_key.FindStringSubmatch(key)
+
+	if m == nil {
+		return "", false
+	}
+
+	path := filepath.Join(c.directory, tile_cache_dir_prefix+m[1], m[2], m[3], m[4]+".json")
+	return path, true
+}
+
+// tileCacheKey returns the key used to store the tile for the request 'path' (for example "/tiles/all/12/655/1583.mvt") in a
+// `TileCache`, in the form of "{FINGERPRINT}/{Z}/{X}/{Y}". 'fingerprints' are the strings derived from the layer name and the options
+// (for example filters) used to produce each layer's tiles. See `layerFingerprint` for details. Requests for unknown layers, or which
+// are not tile requests, return false.
+func tileCacheKey(path string, fingerprints *layerFingerprints) (string, bool) {
+
+	m := re_tile_path.FindStringSubmatch(path)
+
+	if m == nil {
+		return "", false
+	}
+
+	fingerprint, exists := fingerprints.Get(m[1])
+
+	if !exists {
+		return "", false
+	}
+
+	return fmt.Sprintf("%s/%s/%s/%s", fingerprint, m[2], m[3], m[4]), true
+}
+
+// layerFingerprintInputs are the inputs to the query used to produce a layer's tiles from which a layer's fingerprint is derived.
+type layerFingerprintInputs struct {
+	Layer                      string              `json:"layer"`
+	Datasource                 string              `json:"datasource,omitempty"`
+	Query                      string              `json:"query,omitempty"`
+	State                      string              `json:"state,omitempty"`
+	ReadOptions                *ParquetReadOptions `json:"read_options,omitempty"`
+	GeometryColumn             string              `json:"geometry_column"`
+	GeometryEncoding           string              `json:"geometry_encoding,omitempty"`
+	SourceCRS                  string              `json:"source_crs,omitempty"`
+	GeometryCollectionStrategy string              `json:"geometry_collection_strategy,omitempty"`
+	Where                      string              `json:"where,omitempty"`
+	MinZoom                    int                 `json:"min_zoom"`
+	MaxZoom                    int                 `json:"max_zoom"`
+	MinZoomColumn              string              `json:"min_zoom_column,omitempty"`
+	MaxFeatures                int                 `json:"max_features,omitempty"`
+	OrderBy                    string              `json:"order_by,omitempty"`
+	LayerBy                    string              `json:"layer_by,omitempty"`
+	IdColumn                   string              `json:"id_column,omitempty"`
+	TableColumns               []string            `json:"table_columns"`
+	Properties                 *PropertyRules      `json:"properties,omitempty"`
+	Cluster                    *ClusterOptions     `json:"cluster,omitempty"`
+	Bins                       *BinOptions         `json:"bins,omitempty"`
+}
+
+// layerFingerprint returns a 16-character string derived from the name of the layer 'name' and the inputs to the query used to
+// produce its tiles from 'opts': its data source (or query), filters, properties, zoom levels and the size and modification time
+// of each of its (local) files. This ensures that changes to any of those inputs will not be masked by tiles cached using different ones.
+func layerFingerprint(name string, opts *GetFeaturesForTileFuncOptions) (string, error) {
+
+	inputs := &layerFingerprintInputs{
+		Layer:                      name,
+		Datasource:                 opts.Datasource,
+		Query:                      opts.Query,
+		State:                      sourceState(opts.Datasource),
+		ReadOptions:                opts.ReadOptions,
+		GeometryColumn:             opts.GeometryColumn,
+		GeometryEncoding:           opts.GeometryEncoding,
+		SourceCRS:                  opts.SourceCRS,
+		GeometryCollectionStrategy: opts.GeometryCollectionStrategy,
+		Where:                      opts.Where,
+		MinZoom:                    opts.MinZoom,
+		MaxZoom:                    opts.MaxZoom,
+		MinZoomColumn:              opts.MinZoomColumn,
+		MaxFeatures:                opts.MaxFeatures,
+		OrderBy:                    opts.OrderBy,
+		LayerBy:                    opts.LayerBy,
+		IdColumn:                   opts.IdColumn,
+		TableColumns:               opts.TableColumns,
+		Properties:                 opts.Properties,
+		Cluster:                    opts.Cluster,
+		Bins:                       opts.Bins,
+	}
+
+	enc_inputs, err := json.Marshal(inputs)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal fingerprint inputs, %w", err)
+	}
+
+	h := sha256.Sum256(enc_inputs)
+	return hex.EncodeToString(h[:])[0:16], nil
+}
+
+// layerFingerprints are the fingerprints, derived by `layerFingerprint`, of the layers in a tileset. They are safe for concurrent use so
+// that they can be recalculated, when data sources change, while tiles are being served.
+type layerFingerprints struct {
+	mu           sync.RWMutex
+	fingerprints map[string]string
+	options      map[string]*GetFeaturesForTileFuncOptions
+}
+
+// newLayerFingerprints returns a new (empty) `layerFingerprints` instance.
+func newLayerFingerprints() *layerFingerprints {
+
+	f := &layerFingerprints{
+		fingerprints: make(map[string]string),
+		options:      make(map[string]*GetFeaturesForTileFuncOptions),
+	}
+
+	return f
+}
+
+// Add derives, and stores, the fingerprint for the layer 'name' from 'opts', which are retained so that it can be recalculated by `Update`.
+func (f *layerFingerprints) Add(name string, opts *GetFeaturesForTileFuncOptions) error {
+
+	fingerprint, err := layerFingerprint(name, opts)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fingerprints[name] = fingerprint
+	f.options[name] = opts
+
+	return nil
+}
+
+// Set stores 'fingerprint' as the fingerprint for the layer 'name'. It is never recalculated by `Update`.
+func (f *layerFingerprints) Set(name string, fingerprint string) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.fingerprints[name] = fingerprint
+	delete(f.options, name)
+}
+
+// Get returns the fingerprint for the layer 'name'.
+func (f *layerFingerprints) Get(name string) (string, bool) {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	fingerprint, exists := f.fingerprints[name]
+	return fingerprint, exists
+}
+
+// Values returns the fingerprints for all the layers.
+func (f *layerFingerprints) Values() []string {
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	values := make([]string, 0, len(f.fingerprints))
+
+	for _, fingerprint := range f.fingerprints {
+		values = append(values, fingerprint)
+	}
+
+	return values
+}
+
+// Update recalculates the fingerprints for the layers added using `Add`, for example after their data sources have changed,
+// and returns the previous fingerprints of the layers whose fingerprint changed.
+func (f *layerFingerprints) Update() ([]string, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stale := make([]string, 0)
+
+	for name, opts := range f.options {
+
+		fingerprint, err := layerFingerprint(name, opts)
+
+		if err != nil {
+			return stale, fmt.Errorf("Failed to derive fingerprint for layer '%s', %w", name, err)
+		}
+
+		if fingerprint != f.fingerprints[name] {
+			stale = append(stale, f.fingerprints[name])
+			f.fingerprints[name] = fingerprint
+		}
+	}
+
+	return stale, nil
+}
+
+// withTileCache returns a new `http.Handler` which serves tiles from 'cache', if present, and otherwise stores the successful
+// responses produced by 'next' in 'cache'. See `tileCacheKey` for details about 'fingerprints'.
+func withTileCache(cache *TileCache, fingerprints *layerFingerprints, next http.Handler) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		if req.Method != http.MethodGet {
+			next.ServeHTTP(rsp, req)
+			return
+		}
+
+		key, ok := tileCacheKey(req.URL.Path, fingerprints)
+
+		if !ok {
+			next.ServeHTTP(rsp, req)
+			return
+		}
+
+		t, exists := cache.Get(key)
+
+		if exists {
+
+			for k, v := range t.Header {
+				rsp.Header().Set(k, v)
+			}
+
+			rsp.Header().Set(cache_header, "HIT")
+			rsp.Write(t.Body)
+			return
+		}
+
+		rsp.Header().Set(cache_header, "MISS")
+
+		rec := &tileCacheResponseWriter{
+			ResponseWriter: rsp,
+			status_code:    http.StatusOK,
+		}
+
+		next.ServeHTTP(rec, req)
+
+		// Requests which were cancelled may have yielded incomplete (or empty) tiles.
+
+		if rec.status_code != http.StatusOK || req.Context().Err() != nil {
+			return
+		}
+
+		header := make(map[string]string)
+
+		for _, k := range cached_headers {
+
+			v := rec.Header().Get(k)
+
+			if v != "" {
+				header[k] = v
+			}
+		}
+
+		cache.Set(&cachedTile{
+			Key:    key,
+			Header: header,
+			Body:   rec.body.Bytes(),
+		})
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// tileCacheResponseWriter implements the `http.ResponseWriter` interface, recording the status code and body of
+// a response as it is written.
+type tileCacheResponseWriter struct {
+	http.ResponseWriter
+	status_code int
+	body        bytes.Buffer
+}
+
+func (w *tileCacheResponseWriter) WriteHeader(status_code int) {
+	w.status_code = status_code
+	w.ResponseWriter.WriteHeader(status_code)
+}
+
+func (w *tileCacheResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,440 @@
+package show
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTileCache(t *testing.T) {
+
+	cache, err := NewTileCache(300, t.TempDir(), 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	keys := []string{
+		"abc/1/0/0",
+		"abc/1/0/1",
+		"abc/1/1/0",
+	}
+
+	for _, k := range keys {
+
+		cache.Set(&cachedTile{
+			Key:  k,
+			Body: []byte(strings.Repeat("x", 120)),
+		})
+	}
+
+	// The first tile will have been evicted from memory (3 x 129 bytes > 300 bytes) but is still on disk.
+
+	cache.mu.Lock()
+	_, exists := cache.entries[keys[0]]
+	cache.mu.Unlock()
+
+	if exists {
+		t.Fatalf("Expected first tile to be evicted from memory")
+	}
+
+	for _, k := range keys {
+
+		_, exists := cache.Get(k)
+
+		if !exists {
+			t.Fatalf("Expected tile %s to be cached", k)
+		}
+	}
+
+	err = cache.Clear()
+
+	if err != nil {
+		t.Fatalf("Failed to clear cache, %v", err)
+	}
+
+	for _, k := range keys {
+
+		_, exists := cache.Get(k)
+
+		if exists {
+			t.Fatalf("Expected tile %s to be cleared", k)
+		}
+	}
+}
+
+func TestTileCacheClear(t *testing.T) {
+
+	root := t.TempDir()
+
+	cache, err := NewTileCache(0, root, 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	cache.Set(&cachedTile{
+		Key:  "abc/1/0/0",
+		Body: []byte("tile"),
+	})
+
+	tile_path, _ := cache.path("abc/1/0/0")
+
+	// Files which were not written by the tile cache, including those in its own sub-directories, are never removed
+
+	unrelated := []string{
+		filepath.Join(root, "notes.txt"),
+		filepath.Join(root, "tiles", "1", "0", "0.json"),
+		filepath.Join(root, tile_cache_dir_prefix+"abc", "README.md"),
+	}
+
+	for _, path := range unrelated {
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			t.Fatalf("Failed to create directory for %s, %v", path, err)
+		}
+
+		err = os.WriteFile(path, []byte("test"), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+	}
+
+	err = cache.Clear()
+
+	if err != nil {
+		t.Fatalf("Failed to clear cache, %v", err)
+	}
+
+	_, err = os.Stat(tile_path)
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected cached tile to be removed")
+	}
+
+	_, err = os.Stat(filepath.Dir(tile_path))
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected empty tile directory to be removed")
+	}
+
+	for _, path := range unrelated {
+
+		_, err := os.Stat(path)
+
+		if err != nil {
+			t.Fatalf("Expected %s not to be removed, %v", path, err)
+		}
+	}
+}
+
+func TestTileCacheClearFingerprints(t *testing.T) {
+
+	root := t.TempDir()
+
+	cache, err := NewTileCache(1024, root, 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	for _, key := range []string{"abc/1/0/0", "abc/1/1/0", "def/1/0/0"} {
+
+		cache.Set(&cachedTile{
+			Key:  key,
+			Body: []byte("tile"),
+		})
+	}
+
+	err = cache.ClearFingerprints(nil)
+
+	if err != nil {
+		t.Fatalf("Failed to clear cache, %v", err)
+	}
+
+	if len(cache.entries) != 3 || len(cache.disk_entries) != 3 {
+		t.Fatalf("Expected clearing no fingerprints to leave cache as-is")
+	}
+
+	err = cache.ClearFingerprints([]string{"abc"})
+
+	if err != nil {
+		t.Fatalf("Failed to clear cache, %v", err)
+	}
+
+	_, ok := cache.Get("abc/1/0/0")
+
+	if ok {
+		t.Fatalf("Expected tile for cleared fingerprint to be removed")
+	}
+
+	_, err = os.Stat(filepath.Join(root, tile_cache_dir_prefix+"abc"))
+
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected directory for cleared fingerprint to be removed")
+	}
+
+	_, ok = cache.Get("def/1/0/0")
+
+	if !ok {
+		t.Fatalf("Expected tile for other fingerprint to be kept")
+	}
+
+	def_path, _ := cache.path("def/1/0/0")
+
+	info, err := os.Stat(def_path)
+
+	if err != nil {
+		t.Fatalf("Expected tile for other fingerprint to be kept on disk, %v", err)
+	}
+
+	if len(cache.entries) != 1 || len(cache.disk_entries) != 1 || cache.disk_size != info.Size() {
+		t.Fatalf("Unexpected usage after clearing fingerprint: %d tiles in memory, %d tiles (%d bytes) on disk", len(cache.entries), len(cache.disk_entries), cache.disk_size)
+	}
+}
+
+func TestTileCacheDirectorySize(t *testing.T) {
+
+	root := t.TempDir()
+
+	// Each tile is stored as 51 bytes of JSON so only 2 tiles fit on disk
+
+	cache, err := NewTileCache(0, root, 150)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	keys := []string{
+		"abc/2/0/0",
+		"abc/2/0/1",
+		"abc/2/1/0",
+	}
+
+	for idx, k := range keys {
+
+		cache.Set(&cachedTile{
+			Key:  k,
+			Body: []byte("tile"),
+		})
+
+		// Read the first tile so that the second tile is the least recently used tile
+
+		if idx == 1 {
+			cache.Get(keys[0])
+		}
+	}
+
+	tests := map[string]bool{
+		keys[0]: true,
+		keys[1]: false,
+		keys[2]: true,
+	}
+
+	for k, expected := range tests {
+
+		_, exists := cache.Get(k)
+
+		if exists != expected {
+			t.Fatalf("Unexpected state for tile %s, expected to exist: %t", k, expected)
+		}
+	}
+
+	// Tiles stored by a previous instance count towards the limit
+
+	cache, err = NewTileCache(0, root, 100)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	count := 0
+
+	for _, k := range keys {
+
+		_, exists := cache.Get(k)
+
+		if exists {
+			count += 1
+		}
+	}
+
+	if count != 1 {
+		t.Fatalf("Expected 1 tile to remain on disk, got %d", count)
+	}
+}
+
+func TestLayerFingerprint(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "example.parquet")
+
+	err := os.WriteFile(path, []byte("v1"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write data source, %v", err)
+	}
+
+	opts := &GetFeaturesForTileFuncOptions{
+		Database:       &sql.DB{},
+		Datasource:     path,
+		GeometryColumn: "geometry",
+		TableColumns:   []string{"id", "geometry"},
+	}
+
+	fingerprint := func(name string) string {
+
+		f, err := layerFingerprint(name, opts)
+
+		if err != nil {
+			t.Fatalf("Failed to derive fingerprint, %v", err)
+		}
+
+		if !re_tile_cache_key.MatchString(f + "/0/0/0") {
+			t.Fatalf("Invalid fingerprint: %s", f)
+		}
+
+		return f
+	}
+
+	f := fingerprint("all")
+
+	if fingerprint("all") != f {
+		t.Fatalf("Expected fingerprint to be stable")
+	}
+
+	if fingerprint("all-bins") == f {
+		t.Fatalf("Expected fingerprint to change with layer name")
+	}
+
+	opts.Where = `"placetype" = 'region'`
+	where_f := fingerprint("all")
+
+	if where_f == f {
+		t.Fatalf("Expected fingerprint to change with filter")
+	}
+
+	// Modifying the data source changes the fingerprint
+
+	mtime := time.Now().Add(time.Hour)
+	os.Chtimes(path, mtime, mtime)
+
+	if fingerprint("all") == where_f {
+		t.Fatalf("Expected fingerprint to change with data source")
+	}
+}
+
+func TestLayerFingerprintsUpdate(t *testing.T) {
+
+	datasource := filepath.Join(t.TempDir(), "example.parquet")
+
+	err := os.WriteFile(datasource, []byte("v1"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write data source, %v", err)
+	}
+
+	fingerprints := newLayerFingerprints()
+	fingerprints.Set("static", "abc")
+
+	err = fingerprints.Add("example", &GetFeaturesForTileFuncOptions{Datasource: datasource})
+
+	if err != nil {
+		t.Fatalf("Failed to add fingerprint, %v", err)
+	}
+
+	previous, _ := fingerprints.Get("example")
+
+	stale, err := fingerprints.Update()
+
+	if err != nil || len(stale) != 0 {
+		t.Fatalf("Expected fingerprints for unchanged data sources not to change, %v %v", stale, err)
+	}
+
+	err = os.WriteFile(datasource, []byte("version 2"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write data source, %v", err)
+	}
+
+	stale, err = fingerprints.Update()
+
+	if err != nil {
+		t.Fatalf("Failed to update fingerprints, %v", err)
+	}
+
+	if len(stale) != 1 || stale[0] != previous {
+		t.Fatalf("Expected previous fingerprint to be stale, got %v", stale)
+	}
+
+	current, _ := fingerprints.Get("example")
+
+	if current == previous {
+		t.Fatalf("Expected fingerprint to change with data source")
+	}
+
+	static, _ := fingerprints.Get("static")
+
+	if static != "abc" || len(fingerprints.Values()) != 2 {
+		t.Fatalf("Unexpected fingerprints: %v", fingerprints.Values())
+	}
+}
+
+func TestWithTileCache(t *testing.T) {
+
+	cache, err := NewTileCache(1024*1024, "", 0)
+
+	if err != nil {
+		t.Fatalf("Failed to create tile cache, %v", err)
+	}
+
+	requests := 0
+
+	tile_handler := func(rsp http.ResponseWriter, req *http.Request) {
+		requests += 1
+		rsp.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+		rsp.Write([]byte("tile"))
+	}
+
+	fingerprints := newLayerFingerprints()
+	fingerprints.Set("all", "abc")
+
+	handler := withTileCache(cache, fingerprints, http.HandlerFunc(tile_handler))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/tiles/all/1/0/0.mvt", "MISS"},
+		{"/tiles/all/1/0/0.mvt", "HIT"},
+		{"/tiles/all/1/1/0.mvt", "MISS"},
+		{"/tiles/other/1/0/0.mvt", ""},
+	}
+
+	for _, test := range tests {
+
+		req := httptest.NewRequest("GET", test.path, nil)
+		rsp := httptest.NewRecorder()
+
+		handler.ServeHTTP(rsp, req)
+
+		if rsp.Header().Get(cache_header) != test.expected {
+			t.Fatalf("Unexpected cache header for %s: '%s'", test.path, rsp.Header().Get(cache_header))
+		}
+
+		if rsp.Body.String() != "tile" || rsp.Header().Get("Content-Type") != "application/vnd.mapbox-vector-tile" {
+			t.Fatalf("Unexpected response for %s", test.path)
+		}
+	}
+
+	if requests != 3 {
+		t.Fatalf("Expected 3 uncached requests, got %d", requests)
+	}
+}
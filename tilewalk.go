@@ -0,0 +1,167 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+	"golang.org/x/sync/errgroup"
+)
+
+// tileWalkRange defines the tiles to visit for a layer, for every zoom level between 'MinZoom' and 'MaxZoom' (inclusive), which intersect 'Bound'.
+type tileWalkRange struct {
+	// The name of the layer the tiles are visited for. It is passed, as-is, to the function invoked for each tile.
+	Layer   string
+	Bound   orb.Bound
+	MinZoom int
+	MaxZoom int
+}
+
+// tileWalkOptions defines options for visiting the tiles in one or more `tileWalkRange` instances in parallel.
+type tileWalkOptions struct {
+	// The ranges of tiles to visit, in order.
+	Ranges []*tileWalkRange
+	// The number of tiles to visit in parallel.
+	Workers int
+	// The message used to log progress, for example "Export progress".
+	ProgressMessage string
+	// The interval at which progress is logged.
+	ProgressInterval time.Duration
+	// An optional function returning additional key/value pairs to log with the progress.
+	ProgressAttrs func() []any
+}
+
+// countTileRanges returns the total number of tiles in 'ranges'.
+func countTileRanges(ranges []*tileWalkRange) int64 {
+
+	var count int64
+
+	for _, r := range ranges {
+		count += countTiles(r.Bound, r.MinZoom, r.MaxZoom)
+	}
+
+	return count
+}
+
+// walkTilesParallel invokes 'fn' for every tile in 'opts.Ranges' using 'opts.Workers' goroutines, logging progress every 'opts.ProgressInterval',
+// and returns the number of tiles processed. If 'fn' returns an error, or 'ctx' is cancelled, no more tiles are visited and the error is returned.
+func walkTilesParallel(ctx context.Context, opts *tileWalkOptions, fn func(context.Context, string, maptile.Tile) error) (int64, error) {
+
+	total := countTileRanges(opts.Ranges)
+	workers := max(opts.Workers, 1)
+
+	var processed atomic.Int64
+
+	g, g_ctx := errgroup.WithContext(ctx)
+
+	type tileWalkJob struct {
+		layer string
+		tile  maptile.Tile
+	}
+
+	jobs_ch := make(chan *tileWalkJob)
+
+	g.Go(func() error {
+
+		defer close(jobs_ch)
+
+		for _, r := range opts.Ranges {
+
+			ok := true
+
+			walkTiles(r.Bound, r.MinZoom, r.MaxZoom, func(t maptile.Tile) bool {
+
+				select {
+				case <-g_ctx.Done():
+					ok = false
+				case jobs_ch <- &tileWalkJob{layer: r.Layer, tile: t}:
+					// pass
+				}
+
+				return ok
+			})
+
+			if !ok {
+				break
+			}
+		}
+
+		return nil
+	})
+
+	for i := 0; i < workers; i++ {
+
+		g.Go(func() error {
+
+			for job := range jobs_ch {
+
+				err := fn(g_ctx, job.layer, job.tile)
+
+				if err != nil {
+					return err
+				}
+
+				processed.Add(1)
+			}
+
+			return nil
+		})
+	}
+
+	done_ch := make(chan bool)
+
+	go func() {
+
+		ticker := time.NewTicker(opts.ProgressInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done_ch:
+				return
+			case <-ticker.C:
+
+				count := processed.Load()
+				attrs := []any{"processed", count, "total", total, "percent", progressPercent(count, total)}
+
+				if opts.ProgressAttrs != nil {
+					attrs = append(attrs, opts.ProgressAttrs()...)
+				}
+
+				slog.Info(opts.ProgressMessage, attrs...)
+			}
+		}
+	}()
+
+	err := g.Wait()
+	close(done_ch)
+
+	if err != nil {
+		return processed.Load(), err
+	}
+
+	// The tile callbacks do not return an error when their context is cancelled so check explicitly.
+
+	err = ctx.Err()
+
+	if err != nil {
+		return processed.Load(), err
+	}
+
+	return processed.Load(), nil
+}
+
+// progressPercent returns 'count' as a percentage of 'total', formatted to one decimal place. If 'total' is 0 the
+// percentage is "100.0" since there is nothing left to process.
+func progressPercent(count int64, total int64) string {
+
+	if total <= 0 {
+		return "100.0"
+	}
+
+	return fmt.Sprintf("%.1f", float64(count)/float64(total)*100)
+}
@@ -0,0 +1,103 @@
+package show
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/maptile"
+)
+
+func TestWalkTilesParallel(t *testing.T) {
+
+	ctx := context.Background()
+
+	world := orb.Bound{
+		Min: orb.Point{-180, -85},
+		Max: orb.Point{180, 85},
+	}
+
+	opts := &tileWalkOptions{
+		Ranges: []*tileWalkRange{
+			&tileWalkRange{Layer: "a", Bound: world, MinZoom: 0, MaxZoom: 2},
+			&tileWalkRange{Layer: "b", Bound: world, MinZoom: 2, MaxZoom: 2},
+		},
+		Workers:          4,
+		ProgressMessage:  "Test progress",
+		ProgressInterval: time.Minute,
+	}
+
+	mu := new(sync.Mutex)
+	seen := make(map[string]bool)
+
+	processed, err := walkTilesParallel(ctx, opts, func(ctx context.Context, layer string, tile maptile.Tile) error {
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		seen[fmt.Sprintf("%s/%d/%d/%d", layer, tile.Z, tile.X, tile.Y)] = true
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Failed to walk tiles, %v", err)
+	}
+
+	// 1 + 4 + 16 tiles for layer "a" and 16 tiles for layer "b"
+
+	if processed != 37 || len(seen) != 37 || countTileRanges(opts.Ranges) != 37 {
+		t.Fatalf("Expected 37 tiles to be processed, got %d (%d distinct)", processed, len(seen))
+	}
+
+	if !seen["b/2/3/3"] {
+		t.Fatalf("Expected tile b/2/3/3 to be processed")
+	}
+
+	// Errors stop the walk and are returned
+
+	_, err = walkTilesParallel(ctx, opts, func(ctx context.Context, layer string, tile maptile.Tile) error {
+		return fmt.Errorf("Failed")
+	})
+
+	if err == nil {
+		t.Fatalf("Expected walk to fail")
+	}
+
+	// Walks with no ranges process no tiles
+
+	opts.Ranges = nil
+
+	processed, err = walkTilesParallel(ctx, opts, func(ctx context.Context, layer string, tile maptile.Tile) error {
+		return nil
+	})
+
+	if err != nil || processed != 0 {
+		t.Fatalf("Unexpected result for empty walk: %d, %v", processed, err)
+	}
+}
+
+func TestProgressPercent(t *testing.T) {
+
+	tests := []struct {
+		count    int64
+		total    int64
+		expected string
+	}{
+		{0, 0, "100.0"},
+		{0, 10, "0.0"},
+		{5, 8, "62.5"},
+		{8, 8, "100.0"},
+	}
+
+	for _, test := range tests {
+
+		v := progressPercent(test.count, test.total)
+
+		if v != test.expected {
+			t.Fatalf("Unexpected percent for %d/%d: %s", test.count, test.total, v)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package show
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// ValidateWhere ensures that 'where' is a valid SQL expression, which yields a boolean value, for the data in 'from'.
+func ValidateWhere(ctx context.Context, db *sql.DB, from string, where string) error {
+
+	q := fmt.Sprintf(`DESCRIBE SELECT (%s) AS w FROM %s`, where, from)
+
+	rows, err := db.QueryContext(ctx, q)
+
+	if err != nil {
+		return fmt.Errorf("Invalid WHERE expression, %w", err)
+	}
+
+	defer rows.Close()
+
+	var col_type string
+
+	for rows.Next() {
+
+		var col_name string
+		var col_null any
+		var col_key any
+		var col_default any
+		var col_extra any
+
+		err := rows.Scan(&col_name, &col_type, &col_null, &col_key, &col_default, &col_extra)
+
+		if err != nil {
+			return fmt.Errorf("Failed to scan row, %w", err)
+		}
+	}
+
+	err = rows.Err()
+
+	if err != nil {
+		return fmt.Errorf("There was a problem scanning rows, %w", err)
+	}
+
+	if strings.ToUpper(col_type) != "BOOLEAN" {
+		return fmt.Errorf("Invalid WHERE expression, expected a BOOLEAN value but expression yields %s", col_type)
+	}
+
+	return nil
+}
+
+// whereClause returns a SQL WHERE clause (including the "WHERE" keyword) for 'where' or an empty string if 'where' is empty.
+func whereClause(where string) string {
+
+	if where == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(" WHERE (%s)", where)
+}